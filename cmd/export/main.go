@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/export"
+)
+
+// repositoryFlag collects repeated -repository flags.
+type repositoryFlag []string
+
+func (f *repositoryFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repositoryFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var (
+		repositories repositoryFlag
+		dataset      = flag.String("dataset", "runs", "records to export: runs, jobs or steps")
+		format       = flag.String("format", "ndjson", "output format: csv, ndjson or parquet")
+		since        = flag.String("since", "", "start of the time range (RFC3339, default 30 days ago)")
+		until        = flag.String("until", "", "end of the time range (RFC3339, default now)")
+		output       = flag.String("output", "-", "output file, or - for stdout")
+	)
+	flag.Var(&repositories, "repository", "repository full name to export (repeatable, default all)")
+	flag.Parse()
+
+	opts := export.Options{
+		Dataset:      export.Dataset(*dataset),
+		Format:       export.Format(*format),
+		Since:        parseTime(*since, time.Now().AddDate(0, 0, -30)),
+		Until:        parseTime(*until, time.Now()),
+		Repositories: repositories,
+	}
+	if err := opts.Validate(); err != nil {
+		log.Fatalf("Invalid export options: %v", err)
+	}
+
+	// Load configuration
+	cfg := config.LoadConfig()
+
+	// Initialize database
+	database, err := db.InitDB(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		out, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer out.Close()
+	}
+
+	if err := export.Export(context.Background(), database.Conn, out, opts); err != nil {
+		log.Fatalf("Failed to export %s: %v", opts.Dataset, err)
+	}
+}
+
+func parseTime(value string, fallback time.Time) time.Time {
+	if value == "" {
+		return fallback
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid time %q: expected RFC3339\n", value)
+		os.Exit(2)
+	}
+	return t
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/exec"
@@ -8,10 +9,13 @@ import (
 	"syscall"
 
 	"github.com/moosh3/github-actions-aggregator/pkg/api"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
 	"github.com/moosh3/github-actions-aggregator/pkg/config"
 	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/events"
 	"github.com/moosh3/github-actions-aggregator/pkg/github"
 	"github.com/moosh3/github-actions-aggregator/pkg/logger"
+	"github.com/moosh3/github-actions-aggregator/pkg/tracing"
 	"github.com/moosh3/github-actions-aggregator/pkg/worker"
 )
 
@@ -34,8 +38,37 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Initialize GitHub client
-	githubClient := github.NewClient(cfg.GitHub.AccessToken)
+	// Initialize clients for github.com and any GitHub Enterprise Server connections
+	github.ConfigureTransport(cfg.GitHub.HTTP)
+	governor := github.NewGovernor(cfg.RateLimit.BackgroundFraction)
+	connections, err := github.NewConnections(cfg.GitHubConnections(), governor)
+	if err != nil {
+		log.Fatalf("Failed to configure GitHub connections: %v", err)
+	}
+
+	// Initialize trace exporter for completed workflow runs
+	var traceExporter *tracing.Exporter
+	if cfg.Tracing.Enabled {
+		traceExporter, err = tracing.NewExporter(context.Background(), cfg.Tracing)
+		if err != nil {
+			log.Fatalf("Failed to initialize trace exporter: %v", err)
+		}
+	}
+
+	// Initialize broker for live run and job updates
+	broker := events.NewBroker()
+
+	// Poll the workflows of monitored repositories
+	poller := github.NewPoller(database, connections, cfg.PollingInterval, cfg.PollingDormantInterval)
+	go poller.Start()
+
+	// Monitor repositories matching the organizations' discovery rules
+	discoverer := github.NewDiscoverer(database, connections, cfg.Discovery)
+	discoverer.Start()
+
+	// Repair runs whose webhooks were missed
+	reconciler := github.NewReconciler(database, connections, cfg.Reconciliation)
+	reconciler.Start()
 
 	// Initialize worker pool for polling
 	pollingWorkerPool := worker.NewWorkerPool(database, cfg.PollingWorkerPoolSize)
@@ -45,8 +78,17 @@ func main() {
 	webhookWorkerPool := worker.NewWorkerPool(database, cfg.WebhookWorkerPoolSize)
 	webhookWorkerPool.Start()
 
+	// Keep users' repository permissions in sync with GitHub
+	auth.ConfigureTokenEncryption(cfg.Auth.TokenEncryptionKey)
+	accessRefresher := auth.NewAccessRefresher(database.Conn, cfg.Auth.AccessRefreshInterval)
+	accessRefresher.Start()
+
+	// Sync permissions on the other connections for the users mapped to them
+	accessSyncer := github.NewAccessSyncer(database, connections, cfg.Auth.AccessRefreshInterval)
+	accessSyncer.Start()
+
 	// Start the API server
-	go api.StartServer(cfg, database, githubClient, webhookWorkerPool)
+	go api.StartServer(cfg, database, connections, poller, webhookWorkerPool, traceExporter, broker)
 
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -58,6 +100,17 @@ func main() {
 	// Stop the worker pools
 	webhookWorkerPool.Stop()
 	pollingWorkerPool.Stop()
+	accessRefresher.Stop()
+	accessSyncer.Stop()
+	discoverer.Stop()
+	reconciler.Stop()
+
+	// Flush any spans that have not been exported yet
+	if traceExporter != nil {
+		if err := traceExporter.Shutdown(context.Background()); err != nil {
+			log.Printf("Failed to shut down trace exporter: %v", err)
+		}
+	}
 	log.Println("Server exiting")
 }
 
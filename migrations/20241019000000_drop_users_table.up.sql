@@ -0,0 +1,2 @@
+-- Users are stored in git_hub_users, which is managed by the application.
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_repositories_connection_full_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_repositories_full_name ON repositories (full_name);
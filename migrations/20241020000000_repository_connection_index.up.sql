@@ -0,0 +1,3 @@
+-- Repository full names are unique per GitHub connection, not globally.
+-- The composite index replacing this one is created by the application.
+DROP INDEX IF EXISTS idx_repositories_full_name;
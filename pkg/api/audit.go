@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/audit"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type auditEventResponse struct {
+	ID         uint            `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    int64           `json:"actor_id"`
+	ActorLogin string          `json:"actor_login"`
+	TokenID    *uint           `json:"token_id,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Diff       json.RawMessage `json:"diff"`
+	IPAddress  string          `json:"ip_address"`
+	UserAgent  string          `json:"user_agent"`
+}
+
+func newAuditEventResponse(event models.AuditEvent) auditEventResponse {
+	return auditEventResponse{
+		ID:         event.ID,
+		CreatedAt:  event.CreatedAt,
+		ActorID:    event.ActorID,
+		ActorLogin: event.ActorLogin,
+		TokenID:    event.TokenID,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		Before:     rawJSON(event.Before),
+		After:      rawJSON(event.After),
+		Diff:       rawJSON(event.Diff),
+		IPAddress:  event.IPAddress,
+		UserAgent:  event.UserAgent,
+	}
+}
+
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(s)
+}
+
+// GetAuditLog returns audit events filtered by actor, action, target_type,
+// target_id, start_time and end_time. With format=ndjson every matching
+// event is streamed; otherwise at most limit events after the ID given by
+// after are returned.
+func GetAuditLog(c *gin.Context) {
+	filter := audit.Filter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParameter(c.Query("start_time"), time.Time{}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Until, err = parseTimeParameter(c.Query("end_time"), time.Time{}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	query := audit.Query(db.WithContext(c.Request.Context()), filter)
+
+	if c.Query("format") == "ndjson" {
+		streamAuditLog(c, query)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit < 1 || limit > maxAuditLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	if after := c.Query("after"); after != "" {
+		afterId, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after parameter"})
+			return
+		}
+		query = query.Where("id > ?", afterId)
+	}
+
+	var events []models.AuditEvent
+	if err := query.Limit(limit).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+
+	resp := make([]auditEventResponse, 0, len(events))
+	for _, event := range events {
+		resp = append(resp, newAuditEventResponse(event))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func streamAuditLog(c *gin.Context, query *gorm.DB) {
+	rows, err := query.Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	c.Status(http.StatusOK)
+
+	// The response is already streaming, so errors can only be logged.
+	enc := json.NewEncoder(c.Writer)
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := query.ScanRows(rows, &event); err != nil {
+			log.Printf("Error scanning audit event: %v", err)
+			return
+		}
+		if err := enc.Encode(newAuditEventResponse(event)); err != nil {
+			log.Printf("Error writing audit event: %v", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading audit events: %v", err)
+	}
+}
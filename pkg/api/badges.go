@@ -0,0 +1,177 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/badges"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBadgeDays = 30
+	maxBadgeDays     = 365
+)
+
+// GetBadge returns a handler that renders an SVG badge for a workflow from
+// the runs stored in the database. The workflow is identified by its file
+// name (e.g. ci.yml) or its name. Badges of private repositories require a
+// token query parameter issued by GetBadgeToken.
+func GetBadge(cfg config.BadgesConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workflowParam := strings.TrimSuffix(c.Param("workflow"), ".svg")
+		fullName := c.Param("owner") + "/" + c.Param("repo")
+
+		metric, err := badges.ParseMetric(c.DefaultQuery("metric", string(badges.MetricStatus)))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(defaultBadgeDays)))
+		if err != nil || days < 1 || days > maxBadgeDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxBadgeDays)})
+			return
+		}
+
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		var repo models.Repository
+		err = db.Where("connection = ? AND full_name = ?", c.DefaultQuery("connection", config.DefaultConnection), fullName).
+			First(&repo).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+			}
+			return
+		}
+
+		// Don't reveal that a private repository exists without a valid token
+		if repo.Private && !badges.VerifyToken(cfg.Secret, badgeTokenName(repo), c.Query("token")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			return
+		}
+
+		var workflow models.Workflow
+		repoWorkflows := db.Model(&models.WorkflowRun{}).Select("connection", "workflow_id").
+			Where("connection = ? AND repository_id = ?", repo.Connection, repo.RepoID)
+		err = db.Where("(connection, workflow_id) IN (?)", repoWorkflows).
+			Where("path = ? OR name = ?", ".github/workflows/"+workflowParam, workflowParam).
+			First(&workflow).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow"})
+			}
+			return
+		}
+
+		query := db.Where("connection = ? AND workflow_id = ? AND repository_id = ?", repo.Connection, workflow.WorkflowID, repo.RepoID).
+			Where("created_at >= ?", time.Now().AddDate(0, 0, -days))
+		if branch := c.Query("branch"); branch != "" {
+			query = query.Where("head_branch = ?", branch)
+		}
+		// Flakiness looks for failed attempts that passed on a re-run; the
+		// other metrics count each run once
+		if metric != badges.MetricFlakiness {
+			query = query.Scopes(models.LatestAttempts)
+		}
+
+		var runs []models.WorkflowRun
+		if err := query.Find(&runs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow runs"})
+			return
+		}
+
+		label := c.DefaultQuery("label", workflow.Name)
+		svg, err := badges.ForRuns(metric, label, runs, days).SVG()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render badge"})
+			return
+		}
+
+		sum := sha256.Sum256(svg)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+		visibility := "public"
+		if repo.Private {
+			visibility = "private"
+		}
+		c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, cfg.CacheMaxAge))
+		c.Header("ETag", etag)
+
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.Data(http.StatusOK, "image/svg+xml", svg)
+	}
+}
+
+// GetBadgeToken returns a handler that issues the badge token of a private
+// repository.
+func GetBadgeToken(cfg config.BadgesConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		repoId, err := strconv.ParseInt(c.Param("repoId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
+			return
+		}
+
+		if cfg.Secret == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Badge tokens are not configured"})
+			return
+		}
+
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		var repo models.Repository
+		err = db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).Where("id = ?", repoId).First(&repo).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"repository": repo.FullName,
+			"connection": repo.Connection,
+			"token":      badges.Token(cfg.Secret, badgeTokenName(repo)),
+		})
+	}
+}
+
+// badgeTokenName is the name a repository's badge token is derived from.
+// Names of other connections than github.com include the connection, so a
+// token doesn't grant access to a repository of the same name on another
+// connection.
+func badgeTokenName(repo models.Repository) string {
+	if repo.Connection == config.DefaultConnection {
+		return repo.FullName
+	}
+	return repo.Connection + "/" + repo.FullName
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+	"gorm.io/gorm"
+)
+
+type workflowDefinitionResponse struct {
+	ID          uint                   `json:"id"`
+	Path        string                 `json:"path"`
+	CommitSHA   string                 `json:"commit_sha"`
+	ContentSHA  string                 `json:"content_sha"`
+	FirstSeenAt time.Time              `json:"first_seen_at"`
+	Definition  definitions.Definition `json:"definition"`
+	Actions     []string               `json:"actions"`
+	ParseError  string                 `json:"parse_error,omitempty"`
+	Content     string                 `json:"content,omitempty"`
+	// Changes from the previous version; empty for the first one.
+	Changes []definitions.Change `json:"changes"`
+}
+
+// GetWorkflowDefinitions returns the versions of a workflow's file in the
+// order its commits ran, oldest first, each with the commit it changed at
+// and its changes from the version before. A version reverted to appears
+// again. The file contents are included with ?content=true.
+func GetWorkflowDefinitions(c *gin.Context) {
+	repoId, err := strconv.ParseInt(c.Param("repoId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
+		return
+	}
+	workflowId, err := strconv.ParseInt(c.Param("workflowId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+	includeContent := c.Query("content") == "true"
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	var workflow models.Workflow
+	err = db.Scopes(auth.ScopeWorkflows(auth.CurrentViewer(c))).
+		Where("id = ? AND repository_id = ?", workflowId, repoId).First(&workflow).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow"})
+		}
+		return
+	}
+
+	var commits []models.WorkflowDefinitionCommit
+	err = db.Where("connection = ? AND workflow_id = ?", workflow.Connection, workflow.WorkflowID).
+		Order("seen_at, id").Find(&commits).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow definitions"})
+		return
+	}
+	changes := models.DefinitionChanges(commits)
+
+	ids := make([]uint, 0, len(changes))
+	for _, commit := range changes {
+		ids = append(ids, commit.DefinitionID)
+	}
+	var stored []models.WorkflowDefinition
+	if len(ids) > 0 {
+		if err := db.Where("id IN ?", ids).Find(&stored).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow definitions"})
+			return
+		}
+	}
+	versions := make(map[uint]models.WorkflowDefinition, len(stored))
+	for _, version := range stored {
+		versions[version.ID] = version
+	}
+
+	resp := make([]workflowDefinitionResponse, 0, len(changes))
+	var previous *models.WorkflowDefinition
+	for _, commit := range changes {
+		version, ok := versions[commit.DefinitionID]
+		if !ok {
+			continue
+		}
+		definition := workflowDefinitionResponse{
+			ID:          version.ID,
+			Path:        version.Path,
+			CommitSHA:   commit.CommitSHA,
+			ContentSHA:  version.ContentSHA,
+			FirstSeenAt: commit.SeenAt,
+			Definition:  version.Definition,
+			Actions:     nonNil(version.Definition.Actions()),
+			ParseError:  version.ParseError,
+			Changes:     []definitions.Change{},
+		}
+		if includeContent {
+			definition.Content = version.Content
+		}
+		if previous != nil {
+			if diff := definitions.Diff(previous.Definition, version.Definition); diff != nil {
+				definition.Changes = diff
+			}
+		}
+		resp = append(resp, definition)
+		previous = &version
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/audit"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/discovery"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"gorm.io/gorm"
+)
+
+type createDiscoveryRuleRequest struct {
+	Connection      string   `json:"connection"`
+	Include         []string `json:"include"`
+	Exclude         []string `json:"exclude"`
+	Topics          []string `json:"topics"`
+	ExcludeTopics   []string `json:"exclude_topics"`
+	Visibility      string   `json:"visibility"`
+	IncludeArchived bool     `json:"include_archived"`
+	IncludeForks    bool     `json:"include_forks"`
+}
+
+type discoveryRuleResponse struct {
+	ID              uint      `json:"id"`
+	Connection      string    `json:"connection"`
+	Organization    string    `json:"organization"`
+	Include         []string  `json:"include"`
+	Exclude         []string  `json:"exclude"`
+	Topics          []string  `json:"topics"`
+	ExcludeTopics   []string  `json:"exclude_topics"`
+	Visibility      string    `json:"visibility"`
+	IncludeArchived bool      `json:"include_archived"`
+	IncludeForks    bool      `json:"include_forks"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func newDiscoveryRuleResponse(rule models.DiscoveryRule) discoveryRuleResponse {
+	return discoveryRuleResponse{
+		ID:              rule.ID,
+		Connection:      rule.Connection,
+		Organization:    rule.Organization,
+		Include:         nonNil(rule.Include),
+		Exclude:         nonNil(rule.Exclude),
+		Topics:          nonNil(rule.Topics),
+		ExcludeTopics:   nonNil(rule.ExcludeTopics),
+		Visibility:      rule.Visibility,
+		IncludeArchived: rule.IncludeArchived,
+		IncludeForks:    rule.IncludeForks,
+		CreatedAt:       rule.CreatedAt,
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// ListDiscoveryRules returns the discovery rules of an organization on the
+// connection given by the connection query parameter, github.com by
+// default. Rules from the configuration file are not included.
+func ListDiscoveryRules(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	connection := c.DefaultQuery("connection", config.DefaultConnection)
+	if !requireDiscoveryAdmin(c, db, connection) {
+		return
+	}
+
+	var rules []models.DiscoveryRule
+	err := db.Where("connection = ? AND LOWER(organization) = LOWER(?)", connection, c.Param("org")).
+		Order("id").Find(&rules).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve discovery rules"})
+		return
+	}
+
+	resp := make([]discoveryRuleResponse, 0, len(rules))
+	for _, rule := range rules {
+		resp = append(resp, newDiscoveryRuleResponse(rule))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// CreateDiscoveryRule adds a discovery rule to an organization on one of
+// the connections. It applies from the next discovery run.
+func CreateDiscoveryRule(connections *github.Connections) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createDiscoveryRuleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Connection == "" {
+			req.Connection = c.DefaultQuery("connection", config.DefaultConnection)
+		}
+		if _, err := connections.Get(req.Connection); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule := models.DiscoveryRule{
+			Connection:      req.Connection,
+			Organization:    c.Param("org"),
+			Include:         req.Include,
+			Exclude:         req.Exclude,
+			Topics:          req.Topics,
+			ExcludeTopics:   req.ExcludeTopics,
+			Visibility:      req.Visibility,
+			IncludeArchived: req.IncludeArchived,
+			IncludeForks:    req.IncludeForks,
+			CreatedByID:     auth.CurrentUser(c).ID,
+		}
+		if err := discovery.FromModel(rule).Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		if !requireDiscoveryAdmin(c, db, rule.Connection) {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&rule).Error; err != nil {
+				return err
+			}
+			return audit.Record(c, tx, audit.Entry{
+				Action:     audit.ActionDiscoveryRuleCreate,
+				TargetType: "discovery_rule",
+				TargetID:   strconv.FormatUint(uint64(rule.ID), 10),
+				After:      newDiscoveryRuleResponse(rule),
+			})
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create discovery rule"})
+			return
+		}
+		c.JSON(http.StatusCreated, newDiscoveryRuleResponse(rule))
+	}
+}
+
+// DeleteDiscoveryRule removes a discovery rule from an organization on the
+// connection given by the connection query parameter, github.com by default.
+// Repositories only it matched stop being monitored on the next discovery
+// run.
+func DeleteDiscoveryRule(c *gin.Context) {
+	ruleId, err := strconv.ParseUint(c.Param("ruleId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discovery rule ID"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	connection := c.DefaultQuery("connection", config.DefaultConnection)
+	if !requireDiscoveryAdmin(c, db, connection) {
+		return
+	}
+
+	var rule models.DiscoveryRule
+	err = db.Where("id = ? AND connection = ? AND LOWER(organization) = LOWER(?)", ruleId, connection, c.Param("org")).
+		First(&rule).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Discovery rule not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve discovery rule"})
+		}
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&rule).Error; err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionDiscoveryRuleDelete,
+			TargetType: "discovery_rule",
+			TargetID:   strconv.FormatUint(uint64(rule.ID), 10),
+			Before:     newDiscoveryRuleResponse(rule),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete discovery rule"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// requireDiscoveryAdmin responds with an error and returns false unless the
+// user is an admin of the route's organization on the connection.
+// Organizations of the same name on different connections are different
+// organizations.
+func requireDiscoveryAdmin(c *gin.Context, db *gorm.DB, connection string) bool {
+	role, err := auth.OrganizationRole(db, auth.CurrentUser(c), connection, c.Param("org"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve role"})
+		return false
+	}
+	if !auth.RoleAtLeast(role, auth.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The admin role is required"})
+		return false
+	}
+	return true
+}
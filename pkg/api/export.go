@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/export"
+	"gorm.io/gorm"
+)
+
+// ExportData streams workflow runs, jobs or steps for a time range as CSV,
+// NDJSON or Parquet.
+func ExportData(c *gin.Context) {
+	startTime, err := parseTimeParameter(c.Query("start_time"), time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endTime, err := parseTimeParameter(c.Query("end_time"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := export.Options{
+		Dataset:      export.Dataset(c.Param("dataset")),
+		Format:       export.Format(c.DefaultQuery("format", string(export.FormatNDJSON))),
+		Since:        startTime,
+		Until:        endTime,
+		Repositories: c.QueryArray("repository"),
+	}
+	viewer := auth.CurrentViewer(c)
+	opts.Viewer = &viewer
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", opts.Dataset, endTime.Format("20060102T150405"), opts.Format)
+	c.Header("Content-Type", opts.Format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// The response is already streaming, so errors can only be logged.
+	if err := export.Export(c.Request.Context(), db, c.Writer, opts); err != nil {
+		log.Printf("Error exporting %s: %v", opts.Dataset, err)
+	}
+}
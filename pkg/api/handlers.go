@@ -6,13 +6,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
 	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
 	"gorm.io/gorm"
 )
 
 // GetRepository returns a single repository by ID.
 func GetRepository(c *gin.Context) {
-	repoIdParam := c.Param("id")
+	repoIdParam := c.Param("repoId")
 	repoId, err := strconv.ParseInt(repoIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
@@ -26,9 +28,13 @@ func GetRepository(c *gin.Context) {
 	}
 
 	var repo models.Repository
-	err = db.Where("id = ?", repoId).First(&repo).Error
+	err = db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).Where("id = ?", repoId).First(&repo).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+		}
 		return
 	}
 
@@ -43,7 +49,7 @@ func GetRepositories(c *gin.Context) {
 	}
 
 	var repos []models.Repository
-	err := db.Find(&repos).Error
+	err := db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).Find(&repos).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repositories"})
 		return
@@ -54,7 +60,7 @@ func GetRepositories(c *gin.Context) {
 
 // GetRepositoryWorkflows returns all workflows for a given repository.
 func GetRepositoryWorkflows(c *gin.Context) {
-	repoIdParam := c.Param("id")
+	repoIdParam := c.Param("repoId")
 	repoId, err := strconv.ParseInt(repoIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
@@ -68,7 +74,7 @@ func GetRepositoryWorkflows(c *gin.Context) {
 	}
 
 	var workflows []models.Workflow
-	err = db.Where("repository_id = ?", repoId).Find(&workflows).Error
+	err = db.Scopes(auth.ScopeWorkflows(auth.CurrentViewer(c))).Where("repository_id = ?", repoId).Find(&workflows).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflows"})
 		return
@@ -78,7 +84,7 @@ func GetRepositoryWorkflows(c *gin.Context) {
 }
 
 func GetWorkflow(c *gin.Context) {
-	workflowIdParam := c.Param("id")
+	workflowIdParam := c.Param("workflowId")
 	workflowId, err := strconv.ParseInt(workflowIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
@@ -92,9 +98,13 @@ func GetWorkflow(c *gin.Context) {
 	}
 
 	var workflow models.Workflow
-	err = db.Where("id = ?", workflowId).First(&workflow).Error
+	err = db.Scopes(auth.ScopeWorkflows(auth.CurrentViewer(c))).Where("id = ?", workflowId).First(&workflow).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow"})
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow"})
+		}
 		return
 	}
 
@@ -102,7 +112,7 @@ func GetWorkflow(c *gin.Context) {
 }
 
 func GetWorkflowJobs(c *gin.Context) {
-	workflowIdParam := c.Param("id")
+	workflowIdParam := c.Param("workflowId")
 	workflowId, err := strconv.ParseInt(workflowIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
@@ -116,7 +126,9 @@ func GetWorkflowJobs(c *gin.Context) {
 	}
 
 	var jobs []models.Job
-	err = db.Where("workflow_id = ?", workflowId).Find(&jobs).Error
+	err = db.Scopes(auth.ScopeJobs(auth.CurrentViewer(c))).
+		Where("connection = ? AND workflow_id = ?", c.DefaultQuery("connection", config.DefaultConnection), workflowId).
+		Find(&jobs).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow jobs"})
 		return
@@ -127,7 +139,7 @@ func GetWorkflowJobs(c *gin.Context) {
 
 // GetWorkflowRuns returns all runs for a given workflow.
 func GetWorkflowRuns(c *gin.Context) {
-	workflowIdParam := c.Param("id")
+	workflowIdParam := c.Param("workflowId")
 	workflowId, err := strconv.ParseInt(workflowIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
@@ -141,7 +153,9 @@ func GetWorkflowRuns(c *gin.Context) {
 	}
 
 	var runs []models.WorkflowRun
-	err = db.Where("workflow_id = ?", workflowId).Find(&runs).Error
+	err = db.Scopes(auth.ScopeWorkflowRuns(auth.CurrentViewer(c))).
+		Where("connection = ? AND workflow_id = ?", c.DefaultQuery("connection", config.DefaultConnection), workflowId).
+		Find(&runs).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow runs"})
 		return
@@ -152,7 +166,7 @@ func GetWorkflowRuns(c *gin.Context) {
 
 // GetWorkflowRun returns a single workflow run by ID.
 func GetWorkflowRun(c *gin.Context) {
-	runIdParam := c.Param("id")
+	runIdParam := c.Param("runId")
 	runId, err := strconv.ParseInt(runIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run ID"})
@@ -166,9 +180,13 @@ func GetWorkflowRun(c *gin.Context) {
 	}
 
 	var run models.WorkflowRun
-	err = db.Where("id = ?", runId).First(&run).Error
+	err = db.Scopes(auth.ScopeWorkflowRuns(auth.CurrentViewer(c))).Where("id = ?", runId).First(&run).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow run"})
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow run not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow run"})
+		}
 		return
 	}
 
@@ -177,7 +195,7 @@ func GetWorkflowRun(c *gin.Context) {
 
 // GetJob returns a single job by ID.
 func GetJob(c *gin.Context) {
-	jobIdParam := c.Param("id")
+	jobIdParam := c.Param("jobId")
 	jobId, err := strconv.ParseInt(jobIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
@@ -191,9 +209,13 @@ func GetJob(c *gin.Context) {
 	}
 
 	var job models.Job
-	err = db.Where("id = ?", jobId).First(&job).Error
+	err = db.Scopes(auth.ScopeJobs(auth.CurrentViewer(c))).Where("id = ?", jobId).First(&job).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job"})
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job"})
+		}
 		return
 	}
 
@@ -202,7 +224,7 @@ func GetJob(c *gin.Context) {
 
 // GetJobSteps returns all steps for a given job.
 func GetJobSteps(c *gin.Context) {
-	jobIdParam := c.Param("id")
+	jobIdParam := c.Param("jobId")
 	jobId, err := strconv.ParseInt(jobIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
@@ -216,7 +238,7 @@ func GetJobSteps(c *gin.Context) {
 	}
 
 	var steps []models.TaskStep
-	err = db.Where("job_id = ?", jobId).Find(&steps).Error
+	err = db.Scopes(auth.ScopeSteps(auth.CurrentViewer(c))).Where("job_id = ?", jobId).Find(&steps).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job steps"})
 		return
@@ -227,7 +249,7 @@ func GetJobSteps(c *gin.Context) {
 
 // GetJobStats returns statistics for a given job.
 func GetJobStats(c *gin.Context) {
-	jobIdParam := c.Param("id")
+	jobIdParam := c.Param("jobId")
 	jobId, err := strconv.ParseInt(jobIdParam, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
@@ -240,6 +262,18 @@ func GetJobStats(c *gin.Context) {
 		return
 	}
 
+	// Make sure the job belongs to a repository the user can see
+	var job models.Job
+	err = db.Scopes(auth.ScopeJobs(auth.CurrentViewer(c))).Where("id = ?", jobId).First(&job).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job"})
+		}
+		return
+	}
+
 	var stats models.JobStatistics
 	err = db.Where("job_id = ?", jobId).First(&stats).Error
 	if err != nil {
@@ -252,7 +286,7 @@ func GetJobStats(c *gin.Context) {
 
 // GetWorkflowStats returns statistics for a given workflow.
 func GetWorkflowStats(c *gin.Context) {
-	workflowIDParam := c.Param("id")
+	workflowIDParam := c.Param("workflowId")
 	startTimeParam := c.Query("start_time")
 	endTimeParam := c.Query("end_time")
 
@@ -290,7 +324,7 @@ func GetWorkflowStats(c *gin.Context) {
 
 	// Check if the workflow exists
 	var workflow models.Workflow
-	err = db.First(&workflow, "id = ?", workflowID).Error
+	err = db.Scopes(auth.ScopeWorkflows(auth.CurrentViewer(c))).First(&workflow, "id = ?", workflowID).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
@@ -302,7 +336,8 @@ func GetWorkflowStats(c *gin.Context) {
 
 	// Query workflow runs
 	var runs []models.WorkflowRun
-	err = db.Where("workflow_id = ?", workflowID).
+	err = db.Scopes(auth.ScopeWorkflowRuns(auth.CurrentViewer(c)), models.LatestAttempts).
+		Where("connection = ? AND workflow_id = ?", workflow.Connection, workflow.WorkflowID).
 		Where("created_at BETWEEN ? AND ?", startTime, endTime).
 		Find(&runs).Error
 	if err != nil {
@@ -316,54 +351,23 @@ func GetWorkflowStats(c *gin.Context) {
 		return
 	}
 
-	// Initialize counters
-	totalRuns := len(runs)
-	successCount := 0
-	failureCount := 0
-	cancelledCount := 0
-	timedOutCount := 0
-	actionRequiredCount := 0
-
-	for _, run := range runs {
-		switch run.Conclusion {
-		case "success":
-			successCount++
-		case "failure":
-			failureCount++
-		case "cancelled":
-			cancelledCount++
-		case "timed_out":
-			timedOutCount++
-		case "action_required":
-			actionRequiredCount++
-		}
-	}
-
-	// Calculate percentages
-	var successRate, failureRate, cancelledRate, timedOutRate, actionRequiredRate float64
-	if totalRuns > 0 {
-		successRate = float64(successCount) / float64(totalRuns) * 100
-		failureRate = float64(failureCount) / float64(totalRuns) * 100
-		cancelledRate = float64(cancelledCount) / float64(totalRuns) * 100
-		timedOutRate = float64(timedOutCount) / float64(totalRuns) * 100
-		actionRequiredRate = float64(actionRequiredCount) / float64(totalRuns) * 100
-	}
+	summary := models.SummarizeRuns(runs)
 
 	// Respond with extended statistics
 	c.JSON(http.StatusOK, gin.H{
 		"workflow_id":           workflowID,
 		"workflow_name":         workflow.Name,
-		"total_runs":            totalRuns,
-		"success_count":         successCount,
-		"failure_count":         failureCount,
-		"cancelled_count":       cancelledCount,
-		"timed_out_count":       timedOutCount,
-		"action_required_count": actionRequiredCount,
-		"success_rate":          successRate,
-		"failure_rate":          failureRate,
-		"cancelled_rate":        cancelledRate,
-		"timed_out_rate":        timedOutRate,
-		"action_required_rate":  actionRequiredRate,
+		"total_runs":            summary.TotalRuns,
+		"success_count":         summary.SuccessCount,
+		"failure_count":         summary.FailureCount,
+		"cancelled_count":       summary.CancelledCount,
+		"timed_out_count":       summary.TimedOutCount,
+		"action_required_count": summary.ActionRequiredCount,
+		"success_rate":          summary.SuccessRate,
+		"failure_rate":          summary.FailureRate,
+		"cancelled_rate":        summary.CancelledRate,
+		"timed_out_rate":        summary.TimedOutRate,
+		"action_required_rate":  summary.ActionRequiredRate,
 		"start_time":            startTime.Format(time.RFC3339),
 		"end_time":              endTime.Format(time.RFC3339),
 	})
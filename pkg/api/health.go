@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"gorm.io/gorm"
+)
+
+// GetHealth reports whether the database is reachable and the circuit
+// breaker state of every GitHub host. The status is "degraded" while a
+// breaker is open and the response is 503 when the database is down.
+func GetHealth(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	status, code := "ok", http.StatusOK
+	database := "ok"
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(c.Request.Context())
+	}
+	if err != nil {
+		database = "unavailable"
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	breakers := github.BreakerStates()
+	for _, state := range breakers {
+		if state == github.BreakerOpen && code == http.StatusOK {
+			status = "degraded"
+		}
+	}
+
+	c.JSON(code, gin.H{
+		"status":   status,
+		"database": database,
+		"github":   breakers,
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/inventory"
+	"gorm.io/gorm"
+)
+
+// GetActionInventory lists every action and reusable workflow used by the
+// latest version of the workflows of the monitored repositories visible to
+// the user, with the workflows using each version. Filter with action
+// (substring), repository (full name, repeatable), kind, pinned, deprecated
+// and flagged (unpinned or deprecated).
+func GetActionInventory(checker *inventory.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filters := make(map[string]*bool)
+		for _, name := range []string{"pinned", "deprecated", "flagged"} {
+			value, err := parseBoolQuery(c, name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " filter"})
+				return
+			}
+			filters[name] = value
+		}
+
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		query := db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).Where("monitor = ?", true)
+		if names := c.QueryArray("repository"); len(names) > 0 {
+			query = query.Where("full_name IN ?", names)
+		}
+		var repos []models.Repository
+		if err := query.Find(&repos).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repositories"})
+			return
+		}
+		reposByID := make(map[uint]models.Repository, len(repos))
+		repoIDs := make([]uint, 0, len(repos))
+		for _, repo := range repos {
+			reposByID[repo.ID] = repo
+			repoIDs = append(repoIDs, repo.ID)
+		}
+
+		// The latest version of each workflow's file
+		latest := db.Scopes(models.LatestWorkflowDefinitions).
+			Where("(connection, workflow_id) IN (?)", db.Model(&models.WorkflowDefinition{}).
+				Select("connection", "workflow_id").Where("repository_id IN ?", repoIDs))
+		var versions []models.WorkflowDefinition
+		if len(repoIDs) > 0 {
+			err := db.Omit("content").Where("id IN (?)", latest).Find(&versions).Error
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow definitions"})
+				return
+			}
+		}
+
+		builder := inventory.NewBuilder(checker)
+		for _, version := range versions {
+			repo := reposByID[version.RepositoryID]
+			for _, job := range version.Definition.Jobs {
+				for _, uses := range job.Uses {
+					builder.Add(repo.Connection, repo.FullName, version.Path, job.ID, uses)
+				}
+			}
+		}
+
+		action := strings.ToLower(c.Query("action"))
+		kind := c.Query("kind")
+		entries := make([]inventory.Entry, 0)
+		for _, entry := range builder.Entries() {
+			if action != "" && !strings.Contains(strings.ToLower(entry.Action), action) {
+				continue
+			}
+			if kind != "" && entry.Kind != kind {
+				continue
+			}
+			if f := filters["pinned"]; f != nil && entry.Pinned != *f {
+				continue
+			}
+			if f := filters["deprecated"]; f != nil && entry.Deprecated != *f {
+				continue
+			}
+			if f := filters["flagged"]; f != nil && entry.Flagged() != *f {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// parseBoolQuery reads an optional boolean query parameter, returning nil
+// when it is not set.
+func parseBoolQuery(c *gin.Context, name string) (*bool, error) {
+	param := c.Query(name)
+	if param == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(param)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
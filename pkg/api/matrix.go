@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+	"github.com/moosh3/github-actions-aggregator/pkg/matrix"
+	"gorm.io/gorm"
+)
+
+// GetWorkflowMatrix returns statistics for the matrix jobs of a workflow,
+// grouped by job, for each combination of matrix values and for each value
+// of each dimension. Dimensions are named from the version of the
+// workflow's file at its most recently run commit. Jobs completed between
+// start_time and end_time, by default the last 30 days, are counted.
+func GetWorkflowMatrix(c *gin.Context) {
+	repoId, err := strconv.ParseInt(c.Param("repoId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
+		return
+	}
+	workflowId, err := strconv.ParseInt(c.Param("workflowId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+	startTime, err := parseTimeParameter(c.Query("start_time"), time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	endTime, err := parseTimeParameter(c.Query("end_time"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	viewer := auth.CurrentViewer(c)
+	var workflow models.Workflow
+	err = db.Scopes(auth.ScopeWorkflows(viewer)).
+		Where("id = ? AND repository_id = ?", workflowId, repoId).First(&workflow).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow"})
+		}
+		return
+	}
+
+	var jobs []models.Job
+	err = db.Scopes(auth.ScopeJobs(viewer)).
+		Where("connection = ? AND workflow_id = ? AND status = ?", workflow.Connection, workflow.WorkflowID, "completed").
+		Where("completed_at BETWEEN ? AND ?", startTime, endTime).
+		Find(&jobs).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow jobs"})
+		return
+	}
+
+	var definition *definitions.Definition
+	var versions []models.WorkflowDefinition
+	latest := db.Scopes(models.LatestWorkflowDefinitions).
+		Where("connection = ? AND workflow_id = ?", workflow.Connection, workflow.WorkflowID)
+	err = db.Omit("content").Where("id IN (?)", latest).Find(&versions).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow definitions"})
+		return
+	}
+	if len(versions) > 0 {
+		definition = &versions[0].Definition
+	}
+
+	c.JSON(http.StatusOK, matrix.Aggregate(jobs, definition))
+}
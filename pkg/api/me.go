@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+type meOrganization struct {
+	ID         int64  `json:"id"`
+	Connection string `json:"connection"`
+	Login      string `json:"login"`
+	Role       string `json:"role"`
+}
+
+type meTeam struct {
+	ID           int64  `json:"id"`
+	Organization string `json:"organization"`
+	Slug         string `json:"slug"`
+	Name         string `json:"name"`
+}
+
+type meRepository struct {
+	ID         uint   `json:"id"`
+	Connection string `json:"connection"`
+	FullName   string `json:"full_name"`
+	Private    bool   `json:"private"`
+	Permission string `json:"permission"`
+}
+
+// GetMe returns the current user's profile, organizations, teams and the
+// repositories they can access.
+func GetMe(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	user := auth.CurrentUser(c)
+
+	var orgMemberships []models.OrganizationMembership
+	if err := db.Where("user_id = ?", user.ID).Order("connection, organization").Find(&orgMemberships).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve organizations"})
+		return
+	}
+	orgs := make([]meOrganization, 0, len(orgMemberships))
+	for _, m := range orgMemberships {
+		orgs = append(orgs, meOrganization{ID: m.OrganizationID, Connection: m.Connection, Login: m.Organization, Role: m.Role})
+	}
+
+	var teamMemberships []models.TeamMembership
+	if err := db.Where("user_id = ?", user.ID).Order("organization, slug").Find(&teamMemberships).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve teams"})
+		return
+	}
+	teams := make([]meTeam, 0, len(teamMemberships))
+	for _, m := range teamMemberships {
+		teams = append(teams, meTeam{ID: m.TeamID, Organization: m.Organization, Slug: m.Slug, Name: m.Name})
+	}
+
+	var repos []models.Repository
+	if err := db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).Order("full_name").Find(&repos).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repositories"})
+		return
+	}
+	var access []models.RepositoryAccess
+	if err := db.Where("user_id = ?", user.ID).Find(&access).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository access"})
+		return
+	}
+	permissions := make(map[uint]string, len(access))
+	for _, a := range access {
+		permissions[a.RepositoryID] = a.Permission
+	}
+	repositories := make([]meRepository, 0, len(repos))
+	for _, repo := range repos {
+		permission, ok := permissions[repo.ID]
+		if !ok {
+			// Public repositories are readable by everyone
+			permission = "pull"
+		}
+		repositories = append(repositories, meRepository{
+			ID:         repo.ID,
+			Connection: repo.Connection,
+			FullName:   repo.FullName,
+			Private:    repo.Private,
+			Permission: permission,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":            user.ID,
+		"login":         user.Login,
+		"name":          user.Name,
+		"email":         user.Email,
+		"avatar_url":    user.AvatarURL,
+		"type":          user.Type,
+		"organizations": orgs,
+		"teams":         teams,
+		"repositories":  repositories,
+	})
+}
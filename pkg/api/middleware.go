@@ -1,3 +1,17 @@
 package api
 
 // Middleware functions for request logging, authentication checks, etc.
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DatabaseMiddleware makes the database connection available to handlers
+// under the "db" key.
+func DatabaseMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("db", db)
+		c.Next()
+	}
+}
@@ -0,0 +1,422 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/audit"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"gorm.io/gorm"
+)
+
+const (
+	minPollIntervalSeconds = 60
+	maxPollIntervalSeconds = 24 * 60 * 60
+)
+
+type monitorRepositoryRequest struct {
+	FullName string `json:"full_name" binding:"required"`
+	// Connection names the GitHub connection; empty is the default one.
+	Connection          string   `json:"connection"`
+	PollIntervalSeconds int      `json:"poll_interval_seconds"`
+	TrackedWorkflows    []string `json:"tracked_workflows"`
+	IgnoredBranches     []string `json:"ignored_branches"`
+}
+
+// updateMonitoredRepositoryRequest only changes the fields that are set.
+type updateMonitoredRepositoryRequest struct {
+	PollIntervalSeconds *int      `json:"poll_interval_seconds"`
+	TrackedWorkflows    *[]string `json:"tracked_workflows"`
+	IgnoredBranches     *[]string `json:"ignored_branches"`
+}
+
+type monitoredRepositoryResponse struct {
+	ID                  uint       `json:"id"`
+	Connection          string     `json:"connection"`
+	FullName            string     `json:"full_name"`
+	Private             bool       `json:"private"`
+	Monitor             bool       `json:"monitor"`
+	MonitorSource       string     `json:"monitor_source"`
+	PollIntervalSeconds int        `json:"poll_interval_seconds"`
+	TrackedWorkflows    []string   `json:"tracked_workflows"`
+	IgnoredBranches     []string   `json:"ignored_branches"`
+	LastPolledAt        *time.Time `json:"last_polled_at,omitempty"`
+	NextPollAt          *time.Time `json:"next_poll_at,omitempty"`
+}
+
+func newMonitoredRepositoryResponse(repo models.Repository) monitoredRepositoryResponse {
+	return monitoredRepositoryResponse{
+		ID:                  repo.ID,
+		Connection:          repo.Connection,
+		FullName:            repo.FullName,
+		Private:             repo.Private,
+		Monitor:             repo.Monitor,
+		MonitorSource:       repo.MonitorSource,
+		PollIntervalSeconds: repo.PollIntervalSeconds,
+		TrackedWorkflows:    nonNil(repo.TrackedWorkflows),
+		IgnoredBranches:     nonNil(repo.IgnoredBranches),
+		LastPolledAt:        repo.LastPolledAt,
+		NextPollAt:          repo.NextPollAt,
+	}
+}
+
+func validatePollInterval(seconds int) error {
+	if seconds != 0 && (seconds < minPollIntervalSeconds || seconds > maxPollIntervalSeconds) {
+		return fmt.Errorf("poll_interval_seconds must be 0 or between %d and %d", minPollIntervalSeconds, maxPollIntervalSeconds)
+	}
+	return nil
+}
+
+// ListMonitoredRepositories returns the monitored repositories visible to
+// the user.
+func ListMonitoredRepositories(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	var repos []models.Repository
+	err := db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).
+		Where("monitor = ?", true).Order("full_name").Find(&repos).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repositories"})
+		return
+	}
+
+	resp := make([]monitoredRepositoryResponse, 0, len(repos))
+	for _, repo := range repos {
+		resp = append(resp, newMonitoredRepositoryResponse(repo))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// MonitorRepository starts monitoring a repository given by connection and
+// full name. Repositories of the default connection are resolved with the
+// user's GitHub token, or the aggregator's token for service tokens; others
+// with the connection's token. Its workflows are synced in the background.
+// The user needs the maintainer role on the repository; nothing is stored
+// otherwise, and the response is the same as for a missing repository.
+func MonitorRepository(database *db.Database, connections *github.Connections, poller *github.Poller) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req monitorRepositoryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		owner, name, found := strings.Cut(req.FullName, "/")
+		if !found || owner == "" || name == "" || strings.Contains(name, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "full_name must be owner/repo"})
+			return
+		}
+		if err := validatePollInterval(req.PollIntervalSeconds); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Connection == "" {
+			req.Connection = config.DefaultConnection
+		}
+		connection, err := connections.Get(req.Connection)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+		user := auth.CurrentUser(c)
+
+		// User tokens are only valid on the default connection
+		var ghRepo *gh.Repository
+		userToken := connection.Name == config.DefaultConnection
+		if userToken {
+			ghRepo, err = auth.FetchRepository(c.Request.Context(), db, user.ID, owner, name)
+			if errors.Is(err, auth.ErrNoStoredToken) {
+				userToken = false
+			}
+		}
+		if !userToken {
+			ghRepo, err = connection.Client.GetRepository(owner, name)
+		}
+		if err != nil {
+			var ghErr *gh.ErrorResponse
+			if errors.As(err, &ghErr) && ghErr.Response.StatusCode == http.StatusNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			} else {
+				log.Printf("Error resolving repository %s: %v", req.FullName, err)
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve repository"})
+			}
+			return
+		}
+
+		// The role is checked before anything is stored. Repositories the
+		// user may not monitor look the same as missing ones, as the
+		// aggregator's token may see repositories the user can't.
+		allowed, err := canMonitor(db, user, connection.Name, ghRepo, userToken)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve role"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			return
+		}
+
+		repo, err := database.SaveGitHubRepository(connection.Name, ghRepo)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save repository"})
+			return
+		}
+		if userToken {
+			if err := auth.SaveRepositoryPermission(db, user.ID, *repo, ghRepo.GetPermissions()); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save repository permission"})
+				return
+			}
+		}
+		if repo.Monitor {
+			c.JSON(http.StatusConflict, gin.H{"error": "Repository is already monitored"})
+			return
+		}
+
+		before := newMonitoredRepositoryResponse(*repo)
+		repo.Monitor = true
+		repo.MonitorSource = models.MonitorSourceManual
+		repo.PollIntervalSeconds = req.PollIntervalSeconds
+		repo.TrackedWorkflows = req.TrackedWorkflows
+		repo.IgnoredBranches = req.IgnoredBranches
+		after := newMonitoredRepositoryResponse(*repo)
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Omit("Owner").Save(repo).Error; err != nil {
+				return err
+			}
+			return audit.Record(c, tx, audit.Entry{
+				Action:     audit.ActionRepositoryMonitor,
+				TargetType: "repository",
+				TargetID:   strconv.FormatUint(uint64(repo.ID), 10),
+				Before:     before,
+				After:      after,
+			})
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to monitor repository"})
+			return
+		}
+
+		// Initial sync, so the repository's workflows show up right away
+		poller.SyncNow(*repo)
+
+		c.JSON(http.StatusCreated, after)
+	}
+}
+
+// canMonitor reports whether the user has the maintainer role on a
+// repository resolved from GitHub, from its stored copy if any and, when it
+// was resolved with the user's token, the user's permission on GitHub.
+func canMonitor(db *gorm.DB, user *models.GitHubUser, connection string, ghRepo *gh.Repository, userToken bool) (bool, error) {
+	if userToken && auth.RoleAtLeast(auth.PermissionsRole(ghRepo.GetPermissions()), auth.RoleMaintainer) {
+		return true, nil
+	}
+
+	var stored []models.Repository
+	err := db.Where("connection = ? AND full_name = ?", connection, ghRepo.GetFullName()).Limit(1).Find(&stored).Error
+	if err != nil {
+		return false, err
+	}
+	repo := models.Repository{Connection: connection}
+	if len(stored) > 0 {
+		repo = stored[0]
+	}
+	repo.FullName = ghRepo.GetFullName()
+	repo.Private = ghRepo.GetPrivate()
+
+	role, err := auth.RepositoryRole(db, user, repo)
+	if err != nil {
+		return false, err
+	}
+	return auth.RoleAtLeast(role, auth.RoleMaintainer), nil
+}
+
+// UpdateMonitoredRepository changes the polling settings of a monitored
+// repository. The user needs the maintainer role on the repository.
+func UpdateMonitoredRepository(c *gin.Context) {
+	var req updateMonitoredRepositoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PollIntervalSeconds != nil {
+		if err := validatePollInterval(*req.PollIntervalSeconds); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	repo, ok := loadMonitoredRepository(c, db)
+	if !ok || !requireRepositoryRole(c, db, repo, auth.RoleMaintainer) {
+		return
+	}
+
+	before := newMonitoredRepositoryResponse(repo)
+	if req.PollIntervalSeconds != nil {
+		repo.PollIntervalSeconds = *req.PollIntervalSeconds
+	}
+	if req.TrackedWorkflows != nil {
+		repo.TrackedWorkflows = *req.TrackedWorkflows
+	}
+	if req.IgnoredBranches != nil {
+		repo.IgnoredBranches = *req.IgnoredBranches
+	}
+	after := newMonitoredRepositoryResponse(repo)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Owner").Save(&repo).Error; err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionRepositoryUpdate,
+			TargetType: "repository",
+			TargetID:   strconv.FormatUint(uint64(repo.ID), 10),
+			Before:     before,
+			After:      after,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update repository"})
+		return
+	}
+	c.JSON(http.StatusOK, after)
+}
+
+// SyncMonitoredRepository syncs a monitored repository right away instead
+// of waiting for its next poll. The sync runs in the background and is
+// recorded in the audit log; the user needs the maintainer role on the
+// repository.
+func SyncMonitoredRepository(poller *github.Poller) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		repo, ok := loadMonitoredRepository(c, db)
+		if !ok || !requireRepositoryRole(c, db, repo, auth.RoleMaintainer) {
+			return
+		}
+
+		if !poller.SyncNow(repo) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Repository is already being synced"})
+			return
+		}
+		resp := newMonitoredRepositoryResponse(repo)
+		err := audit.Record(c, db, audit.Entry{
+			Action:     audit.ActionRepositorySync,
+			TargetType: "repository",
+			TargetID:   strconv.FormatUint(uint64(repo.ID), 10),
+			Before:     resp,
+			After:      resp,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record sync"})
+			return
+		}
+		c.JSON(http.StatusAccepted, resp)
+	}
+}
+
+// UnmonitorRepository stops polling a repository. Its stored workflows and
+// runs are kept. The user needs the maintainer role on the repository.
+func UnmonitorRepository(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	repo, ok := loadMonitoredRepository(c, db)
+	if !ok || !requireRepositoryRole(c, db, repo, auth.RoleMaintainer) {
+		return
+	}
+
+	before := newMonitoredRepositoryResponse(repo)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Marking the change as manual keeps discovery from monitoring the
+		// repository again.
+		err := tx.Model(&repo).Updates(map[string]interface{}{
+			"monitor":        false,
+			"monitor_source": models.MonitorSourceManual,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionRepositoryUnmonitor,
+			TargetType: "repository",
+			TargetID:   strconv.FormatUint(uint64(repo.ID), 10),
+			Before:     before,
+			After:      newMonitoredRepositoryResponse(repo),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop monitoring repository"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadMonitoredRepository loads the monitored repository named by the owner
+// and repo route parameters on the connection given by the connection query
+// parameter, responding with 404 if it does not exist or is not visible to
+// the user.
+func loadMonitoredRepository(c *gin.Context, db *gorm.DB) (models.Repository, bool) {
+	var repo models.Repository
+	err := db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).
+		Where("connection = ?", c.DefaultQuery("connection", config.DefaultConnection)).
+		Where("LOWER(full_name) = LOWER(?) AND monitor = ?", c.Param("owner")+"/"+c.Param("repo"), true).
+		First(&repo).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Monitored repository not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+		}
+		return repo, false
+	}
+	return repo, true
+}
+
+// requireRepositoryRole responds with 403 unless the user has at least min
+// on the repository.
+func requireRepositoryRole(c *gin.Context, db *gorm.DB, repo models.Repository, min string) bool {
+	role, err := auth.RepositoryRole(db, auth.CurrentUser(c), repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve role"})
+		return false
+	}
+	if !auth.RoleAtLeast(role, min) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("The %s role is required", min)})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/pullrequests"
+	"gorm.io/gorm"
+)
+
+type pullRequestResponse struct {
+	Number     int        `json:"number"`
+	Title      string     `json:"title"`
+	Author     string     `json:"author"`
+	State      string     `json:"state"`
+	Draft      bool       `json:"draft"`
+	HeadBranch string     `json:"head_branch"`
+	BaseBranch string     `json:"base_branch"`
+	HTMLURL    string     `json:"html_url"`
+	OpenedAt   *time.Time `json:"opened_at"`
+	ClosedAt   *time.Time `json:"closed_at"`
+	MergedAt   *time.Time `json:"merged_at"`
+	pullrequests.Summary
+}
+
+type pullRequestRunResponse struct {
+	RunID           int64      `json:"run_id"`
+	RunAttempt      int        `json:"run_attempt"`
+	WorkflowID      int64      `json:"workflow_id"`
+	Name            string     `json:"name"`
+	HeadSHA         string     `json:"head_sha"`
+	Event           string     `json:"event"`
+	Status          string     `json:"status"`
+	Conclusion      string     `json:"conclusion"`
+	TriggeringActor string     `json:"triggering_actor"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RunStartedAt    *time.Time `json:"run_started_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	HTMLURL         string     `json:"html_url"`
+}
+
+// GetPullRequests lists the pull requests of a repository with their CI
+// history, and totals across them. Filter with state (open, closed or
+// merged) and author; pull requests opened between start_time and end_time,
+// by default the last 30 days, are included.
+func GetPullRequests(c *gin.Context) {
+	startTime, err := parseTimeParameter(c.Query("start_time"), time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	endTime, err := parseTimeParameter(c.Query("end_time"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, repo, ok := pullRequestRepository(c)
+	if !ok {
+		return
+	}
+
+	// Pull requests only known from run payloads have no open time
+	query := db.Preload("WorkflowRuns", scopeRuns(c)).
+		Where("connection = ? AND repository_id = ?", repo.Connection, repo.RepoID).
+		Where("COALESCE(opened_at, created_at) BETWEEN ? AND ?", startTime, endTime)
+	switch state := c.Query("state"); state {
+	case "":
+	case "open":
+		query = query.Where("state = ?", "open")
+	case "closed":
+		query = query.Where("state = ? AND merged_at IS NULL", "closed")
+	case "merged":
+		query = query.Where("merged_at IS NOT NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state filter"})
+		return
+	}
+	if author := c.Query("author"); author != "" {
+		query = query.Where("author = ?", author)
+	}
+
+	var pulls []models.PullRequest
+	if err := query.Order("number DESC").Find(&pulls).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve pull requests"})
+		return
+	}
+
+	resp := make([]pullRequestResponse, 0, len(pulls))
+	summaries := make([]pullrequests.Summary, 0, len(pulls))
+	for _, pr := range pulls {
+		item := newPullRequestResponse(pr)
+		resp = append(resp, item)
+		summaries = append(summaries, item.Summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pull_requests": resp,
+		"totals":        pullrequests.Total(summaries),
+		"start_time":    startTime.Format(time.RFC3339),
+		"end_time":      endTime.Format(time.RFC3339),
+	})
+}
+
+// GetPullRequest returns the CI history of a pull request with every
+// attempt of its workflow runs, oldest first.
+func GetPullRequest(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pull request number"})
+		return
+	}
+
+	db, repo, ok := pullRequestRepository(c)
+	if !ok {
+		return
+	}
+
+	var pr models.PullRequest
+	err = db.Preload("WorkflowRuns", scopeRuns(c), func(db *gorm.DB) *gorm.DB {
+		return db.Order("workflow_runs.created_at, workflow_runs.run_id, workflow_runs.run_attempt")
+	}).Where("connection = ? AND repository_id = ? AND number = ?", repo.Connection, repo.RepoID, number).First(&pr).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pull request not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve pull request"})
+		}
+		return
+	}
+
+	runs := make([]pullRequestRunResponse, 0, len(pr.WorkflowRuns))
+	for _, run := range pr.WorkflowRuns {
+		runs = append(runs, pullRequestRunResponse{
+			RunID:           run.RunID,
+			RunAttempt:      run.RunAttempt,
+			WorkflowID:      run.WorkflowID,
+			Name:            run.Name,
+			HeadSHA:         run.HeadSHA,
+			Event:           run.Event,
+			Status:          run.Status,
+			Conclusion:      run.Conclusion,
+			TriggeringActor: run.TriggeringActor,
+			CreatedAt:       run.CreatedAt,
+			RunStartedAt:    run.RunStartedAt,
+			UpdatedAt:       run.UpdatedAt,
+			HTMLURL:         run.HTMLURL,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pull_request": newPullRequestResponse(pr),
+		"runs":         runs,
+	})
+}
+
+// pullRequestRepository loads the repository of the request's repoId,
+// writing an error response and returning false when it isn't visible.
+func pullRequestRepository(c *gin.Context) (*gorm.DB, models.Repository, bool) {
+	repoId, err := strconv.ParseInt(c.Param("repoId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
+		return nil, models.Repository{}, false
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return nil, models.Repository{}, false
+	}
+
+	var repo models.Repository
+	err = db.Scopes(auth.ScopeRepositories(auth.CurrentViewer(c))).Where("id = ?", repoId).First(&repo).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+		}
+		return nil, models.Repository{}, false
+	}
+	return db, repo, true
+}
+
+// scopeRuns limits preloaded workflow runs to those the user can see.
+func scopeRuns(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return auth.ScopeWorkflowRuns(auth.CurrentViewer(c))
+}
+
+func newPullRequestResponse(pr models.PullRequest) pullRequestResponse {
+	return pullRequestResponse{
+		Number:     pr.Number,
+		Title:      pr.Title,
+		Author:     pr.Author,
+		State:      pr.State,
+		Draft:      pr.Draft,
+		HeadBranch: pr.HeadBranch,
+		BaseBranch: pr.BaseBranch,
+		HTMLURL:    pr.HTMLURL,
+		OpenedAt:   pr.OpenedAt,
+		ClosedAt:   pr.ClosedAt,
+		MergedAt:   pr.MergedAt,
+		Summary:    pullrequests.Summarize(pr, pr.WorkflowRuns),
+	}
+}
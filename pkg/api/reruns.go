@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+// GetWorkflowReruns returns re-run statistics for the runs of a workflow
+// created between start_time and end_time, by default the last 30 days.
+func GetWorkflowReruns(c *gin.Context) {
+	repoId, err := strconv.ParseInt(c.Param("repoId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository ID"})
+		return
+	}
+	workflowId, err := strconv.ParseInt(c.Param("workflowId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+	startTime, err := parseTimeParameter(c.Query("start_time"), time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	endTime, err := parseTimeParameter(c.Query("end_time"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !startTime.Before(endTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_time must be before end_time"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	viewer := auth.CurrentViewer(c)
+	var workflow models.Workflow
+	err = db.Scopes(auth.ScopeWorkflows(viewer)).
+		Where("id = ? AND repository_id = ?", workflowId, repoId).First(&workflow).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow"})
+		}
+		return
+	}
+
+	// Every attempt of the runs; attempts share their run's creation time
+	var attempts []models.WorkflowRun
+	err = db.Scopes(auth.ScopeWorkflowRuns(viewer)).
+		Where("connection = ? AND workflow_id = ?", workflow.Connection, workflow.WorkflowID).
+		Where("created_at BETWEEN ? AND ?", startTime, endTime).
+		Find(&attempts).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workflow runs"})
+		return
+	}
+
+	summary := models.SummarizeReruns(attempts)
+	c.JSON(http.StatusOK, gin.H{
+		"workflow_id":          workflow.ID,
+		"workflow_name":        workflow.Name,
+		"total_runs":           summary.TotalRuns,
+		"rerun_runs":           summary.RerunRuns,
+		"rerun_rate":           summary.RerunRate,
+		"reruns":               summary.Reruns,
+		"failed_jobs_reruns":   summary.FailedJobsReruns,
+		"full_reruns":          summary.FullReruns,
+		"unrecorded_reruns":    summary.UnrecordedReruns,
+		"passed_on_rerun":      summary.PassedOnRerun,
+		"passed_on_rerun_rate": summary.PassedOnRerunRate,
+		"superseded_minutes":   summary.SupersededMinutes,
+		"reruns_by_actor":      summary.RerunsByActor,
+		"start_time":           startTime.Format(time.RFC3339),
+		"end_time":             endTime.Format(time.RFC3339),
+	})
+}
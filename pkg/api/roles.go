@@ -0,0 +1,227 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/audit"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+type createRoleBindingRequest struct {
+	Role string `json:"role" binding:"required"`
+	// Exactly one of User (a login) and Team (a team slug) must be set.
+	User string `json:"user"`
+	Team string `json:"team"`
+	// Repository optionally limits the binding to one repository of the
+	// organization, by full name.
+	Repository string `json:"repository"`
+}
+
+type roleBindingResponse struct {
+	ID         uint      `json:"id"`
+	Role       string    `json:"role"`
+	User       string    `json:"user,omitempty"`
+	Team       string    `json:"team,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ListRoleBindings returns the role bindings of an organization.
+func ListRoleBindings(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	var bindings []models.RoleBinding
+	err := db.Where("LOWER(organization) = LOWER(?)", c.Param("org")).Order("id").Find(&bindings).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve role bindings"})
+		return
+	}
+
+	resp := make([]roleBindingResponse, 0, len(bindings))
+	for _, binding := range bindings {
+		r, err := newRoleBindingResponse(db, binding)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve role bindings"})
+			return
+		}
+		resp = append(resp, r)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// CreateRoleBinding grants a role in an organization, or on one of its
+// repositories, to a user or team.
+func CreateRoleBinding(c *gin.Context) {
+	var req createRoleBindingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be viewer, maintainer or admin"})
+		return
+	}
+	if (req.User == "") == (req.Team == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of user and team is required"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	org := c.Param("org")
+	binding := models.RoleBinding{Organization: org, Role: req.Role}
+
+	if req.User != "" {
+		var user models.GitHubUser
+		err := db.Where("LOWER(login) = LOWER(?) AND type <> ?", req.User, "Organization").First(&user).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found; they have to log in once first"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			}
+			return
+		}
+		binding.SubjectType = auth.SubjectUser
+		binding.UserID = user.ID
+	} else {
+		binding.SubjectType = auth.SubjectTeam
+		binding.TeamSlug = req.Team
+	}
+
+	if req.Repository != "" {
+		owner, _, _ := strings.Cut(req.Repository, "/")
+		if !strings.EqualFold(owner, org) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Repository does not belong to the organization"})
+			return
+		}
+		var repo models.Repository
+		// Bindings are of github.com organizations, so only their
+		// repositories can be bound
+		err := db.Where("connection = ? AND LOWER(full_name) = LOWER(?)", config.DefaultConnection, req.Repository).
+			First(&repo).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repository"})
+			}
+			return
+		}
+		binding.RepositoryID = &repo.ID
+	}
+
+	var resp roleBindingResponse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&binding).Error; err != nil {
+			return err
+		}
+		var err error
+		resp, err = newRoleBindingResponse(tx, binding)
+		if err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionRoleCreate,
+			TargetType: "role_binding",
+			TargetID:   strconv.FormatUint(uint64(binding.ID), 10),
+			After:      resp,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role binding"})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// DeleteRoleBinding removes a role binding from an organization.
+func DeleteRoleBinding(c *gin.Context) {
+	roleId, err := strconv.ParseUint(c.Param("roleId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role binding ID"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	var binding models.RoleBinding
+	err = db.Where("id = ? AND LOWER(organization) = LOWER(?)", roleId, c.Param("org")).First(&binding).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role binding not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve role binding"})
+		}
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		before, err := newRoleBindingResponse(tx, binding)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&binding).Error; err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionRoleDelete,
+			TargetType: "role_binding",
+			TargetID:   strconv.FormatUint(uint64(binding.ID), 10),
+			Before:     before,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role binding"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func newRoleBindingResponse(db *gorm.DB, binding models.RoleBinding) (roleBindingResponse, error) {
+	resp := roleBindingResponse{
+		ID:        binding.ID,
+		Role:      binding.Role,
+		Team:      binding.TeamSlug,
+		CreatedAt: binding.CreatedAt,
+	}
+
+	if binding.SubjectType == auth.SubjectUser {
+		var user models.GitHubUser
+		if err := db.Select("login").Where("id = ?", binding.UserID).First(&user).Error; err != nil {
+			return resp, err
+		}
+		resp.User = user.Login
+	}
+
+	if binding.RepositoryID != nil {
+		var repo models.Repository
+		if err := db.Select("full_name").Where("id = ?", *binding.RepositoryID).First(&repo).Error; err != nil {
+			return resp, err
+		}
+		resp.Repository = repo.FullName
+	}
+
+	return resp, nil
+}
@@ -1,39 +1,155 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"log"
+
 	"github.com/gin-gonic/gin"
 	"github.com/moosh3/github-actions-aggregator/pkg/auth"
 	"github.com/moosh3/github-actions-aggregator/pkg/config"
 	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/events"
 	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/graphql"
+	"github.com/moosh3/github-actions-aggregator/pkg/inventory"
+	"github.com/moosh3/github-actions-aggregator/pkg/tracing"
 	"github.com/moosh3/github-actions-aggregator/pkg/worker"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func StartServer(cfg *config.Config, db *db.Database, githubClient *github.Client, worker *worker.WorkerPool) {
+// NewEngine creates the gin engine, trusting X-Forwarded-For only from the
+// configured proxies, so client IPs in the audit log can't be spoofed.
+func NewEngine(cfg *config.Config) (*gin.Engine, error) {
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	return r, nil
+}
+
+func StartServer(cfg *config.Config, db *db.Database, connections *github.Connections, poller *github.Poller, worker *worker.WorkerPool, tracer *tracing.Exporter, broker *events.Broker) {
+	r, err := NewEngine(cfg)
+	if err != nil {
+		log.Fatalf("Error creating server: %v", err)
+	}
+	r.Use(DatabaseMiddleware(db.Conn))
+	auth.ConfigureSessions(cfg.Session)
+	auth.ConfigureAdmins(cfg.Auth.Admins)
+
+	// Health check and Prometheus metrics
+	r.GET("/health", GetHealth)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Public routes for Github OAuth
 	r.GET("/login", auth.GitHubLogin)
 	r.GET("/callback", auth.GitHubCallback)
+	r.POST("/logout", auth.RequireSameOrigin(), auth.Logout)
+
+	// Optional company SSO login, linked to GitHub identities
+	if cfg.OIDC.Enabled {
+		provider, err := auth.NewOIDCProvider(context.Background(), cfg.OIDC)
+		if err != nil {
+			log.Printf("OIDC login disabled: %v", err)
+		} else {
+			r.GET("/login/"+provider.Name(), auth.Login(provider))
+			r.GET("/callback/"+provider.Name(), auth.Callback(provider))
+		}
+	}
+
+	// Webhook routes for Github events, one per connection (exclude middleware that could interfere)
+	for _, connection := range connections.All() {
+		webhookHandler := github.NewWebhookHandler(db, connection, worker, tracer, broker)
+		r.POST("/webhook/"+connection.Name, webhookHandler.HandleWebhook)
+		if connection.Name == config.DefaultConnection {
+			r.POST("/webhook", webhookHandler.HandleWebhook)
+		}
+	}
+
+	// Live stream of workflow run and job updates
+	r.GET("/stream", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeRead), StreamEvents(broker))
+
+	// GraphQL API over repositories, workflows, runs, jobs and steps
+	r.POST("/graphql", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeRead), gin.WrapH(graphql.NewHandler(db.Conn)))
+
+	// Public SVG badges computed from stored runs
+	r.GET("/badges/:owner/:repo/:workflow", GetBadge(cfg.Badges))
+
+	// Inventory of the actions used by monitored repositories
+	r.GET("/actions/inventory", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeRead),
+		GetActionInventory(inventory.NewChecker(cfg.Actions.Deprecated)))
+
+	// Bulk export of runs, jobs and steps
+	r.GET("/export/:dataset", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeExport), ExportData)
 
-	// Webhook route for Github events (exclude middleware that could interfere)
-	webhookHandler := github.NewWebhookHandler(db, githubClient, cfg.GitHub.WebhookSecret, worker)
-	r.POST("/webhook", webhookHandler.HandleWebhook)
+	// Profile of the current user
+	r.GET("/me", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeRead), GetMe)
+
+	// Personal and service API tokens. Personal tokens act with their
+	// owner's roles, so any user manages their own; creating and revoking
+	// service tokens checks the admin role in their organization
+	tokens := r.Group("/tokens", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeAdmin))
+	{
+		tokens.POST("", CreateToken)
+		tokens.GET("", ListTokens)
+		tokens.DELETE("/:tokenId", RevokeToken)
+	}
+
+	// Role management, restricted to admins of the github.com organization
+	orgs := r.Group("/organizations/:org", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeAdmin),
+		auth.RequireOrganizationRole(auth.RoleAdmin, "org"))
+	{
+		orgs.GET("/roles", ListRoleBindings)
+		orgs.POST("/roles", CreateRoleBinding)
+		orgs.DELETE("/roles/:roleId", DeleteRoleBinding)
+	}
+
+	// Discovery rule management. The handlers check the admin role in the
+	// organization on the rules' connection
+	discoveryRules := r.Group("/organizations/:org/discovery-rules", auth.AuthMiddleware(),
+		auth.RequireScope(auth.TokenScopeAdmin))
+	{
+		discoveryRules.GET("", ListDiscoveryRules)
+		discoveryRules.POST("", CreateDiscoveryRule(connections))
+		discoveryRules.DELETE("/:ruleId", DeleteDiscoveryRule)
+	}
+
+	// Instance administration
+	admin := r.Group("/admin", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeAdmin), auth.RequireAdmin())
+	{
+		admin.GET("/audit", GetAuditLog)
+	}
+
+	// Management of the repositories whose workflows are polled
+	monitored := r.Group("/monitored-repositories", auth.AuthMiddleware())
+	{
+		monitored.GET("", auth.RequireScope(auth.TokenScopeRead), ListMonitoredRepositories)
+		monitored.POST("", auth.RequireScope(auth.TokenScopeAdmin), MonitorRepository(db, connections, poller))
+		monitored.PATCH("/:owner/:repo", auth.RequireScope(auth.TokenScopeAdmin), UpdateMonitoredRepository)
+		monitored.POST("/:owner/:repo/sync", auth.RequireScope(auth.TokenScopeAdmin), SyncMonitoredRepository(poller))
+		monitored.DELETE("/:owner/:repo", auth.RequireScope(auth.TokenScopeAdmin), UnmonitorRepository)
+	}
 
 	// Require authentication for all repository routes
-	protected := r.Group("/repositories", auth.AuthMiddleware())
+	protected := r.Group("/repositories", auth.AuthMiddleware(), auth.RequireScope(auth.TokenScopeRead))
 	{
 		protected.GET("", GetRepositories)
 		protected.GET("/:repoId", GetRepository)
-		protected.GET("/:repoId/workflows", GetRepositoryWorkflows)                    // Get all workflows for a repository
-		protected.GET("/:repoId/workflows/:workflowId", GetWorkflow)                   // Get a specific workflow
-		protected.GET("/:repoId/workflows/:workflowId/runs", GetWorkflowRuns)          // Get all runs for a workflow
-		protected.GET("/:repoId/workflows/:workflowId/runs/:runId", GetWorkflowRun)    // Get a specific run
-		protected.GET("/:repoId/workflows/:workflowId/stats", GetWorkflowStats)        // Get stats for a workflow
-		protected.GET("/:repoId/workflows/:workflowId/jobs", GetWorkflowJobs)          // Get all jobs for a workflow
-		protected.GET("/:repoId/workflows/:workflowId/jobs/:jobId", GetJob)            // Get a specific job
-		protected.GET("/:repoId/workflows/:workflowId/jobs/:jobId/steps", GetJobSteps) // Get all steps for a job
-		protected.GET("/:repoId/workflows/:workflowId/jobs/:jobId/stats", GetJobStats) // Get stats for a job
+		protected.GET("/:repoId/badge-token", GetBadgeToken(cfg.Badges))                    // Get the badge token for a private repository
+		protected.GET("/:repoId/pulls", GetPullRequests)                                    // Get the CI history of a repository's pull requests
+		protected.GET("/:repoId/pulls/:number", GetPullRequest)                             // Get the CI history of a pull request
+		protected.GET("/:repoId/workflows", GetRepositoryWorkflows)                         // Get all workflows for a repository
+		protected.GET("/:repoId/workflows/:workflowId", GetWorkflow)                        // Get a specific workflow
+		protected.GET("/:repoId/workflows/:workflowId/runs", GetWorkflowRuns)               // Get all runs for a workflow
+		protected.GET("/:repoId/workflows/:workflowId/runs/:runId", GetWorkflowRun)         // Get a specific run
+		protected.GET("/:repoId/workflows/:workflowId/stats", GetWorkflowStats)             // Get stats for a workflow
+		protected.GET("/:repoId/workflows/:workflowId/reruns", GetWorkflowReruns)           // Get re-run stats for a workflow
+		protected.GET("/:repoId/workflows/:workflowId/definitions", GetWorkflowDefinitions) // Get the history of a workflow's file
+		protected.GET("/:repoId/workflows/:workflowId/jobs", GetWorkflowJobs)               // Get all jobs for a workflow
+		protected.GET("/:repoId/workflows/:workflowId/matrix", GetWorkflowMatrix)           // Get matrix job stats for a workflow
+		protected.GET("/:repoId/workflows/:workflowId/jobs/:jobId", GetJob)                 // Get a specific job
+		protected.GET("/:repoId/workflows/:workflowId/jobs/:jobId/steps", GetJobSteps)      // Get all steps for a job
+		protected.GET("/:repoId/workflows/:workflowId/jobs/:jobId/stats", GetJobStats)      // Get stats for a job
 	}
 
 	r.Run(":" + cfg.ServerPort)
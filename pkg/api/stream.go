@@ -0,0 +1,98 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/events"
+	"gorm.io/gorm"
+)
+
+// streamHeartbeatInterval is how often a comment is written to idle streams
+// so proxies don't close the connection.
+const streamHeartbeatInterval = 15 * time.Second
+
+// streamAccessInterval is how often the repositories a stream may send
+// events of are reloaded, so access synced since it was opened applies.
+const streamAccessInterval = time.Minute
+
+// StreamEvents returns a handler that streams workflow run and job state
+// changes as Server-Sent Events. The stream can be narrowed with one or more
+// repository, workflow and branch query parameters. Only events of
+// repositories the user can see are streamed; they are reloaded every
+// streamAccessInterval.
+func StreamEvents(broker *events.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		viewer := auth.CurrentViewer(c)
+		visible, err := visibleRepositories(db, viewer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repositories"})
+			return
+		}
+		allowed := events.NewAllowlist(visible)
+
+		filter := events.Filter{
+			Repositories:        c.QueryArray("repository"),
+			Workflows:           c.QueryArray("workflow"),
+			Branches:            c.QueryArray("branch"),
+			AllowedRepositories: allowed,
+		}
+
+		sub := broker.Subscribe(filter)
+		defer broker.Unsubscribe(sub)
+
+		heartbeat := time.NewTicker(streamHeartbeatInterval)
+		defer heartbeat.Stop()
+		access := time.NewTicker(streamAccessInterval)
+		defer access.Stop()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-sub.C:
+				if !ok {
+					return false
+				}
+				c.SSEvent(event.Type, event)
+				return true
+			case <-access.C:
+				visible, err := visibleRepositories(db, viewer)
+				if err != nil {
+					// Keep the repositories loaded last
+					log.Printf("Error reloading repositories of stream: %v", err)
+					return true
+				}
+				allowed.Replace(visible)
+				return true
+			case <-heartbeat.C:
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err == nil
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// visibleRepositories returns the repositories the viewer can see.
+func visibleRepositories(db *gorm.DB, viewer auth.Viewer) ([]events.RepositoryKey, error) {
+	var visible []events.RepositoryKey
+	err := db.Model(&models.Repository{}).Scopes(auth.ScopeRepositories(viewer)).
+		Select("connection", "full_name").Find(&visible).Error
+	return visible, err
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/audit"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+type createTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+	Organization  string   `json:"organization"`
+	Connection    string   `json:"connection"`
+	Repositories  []string `json:"repositories"`
+}
+
+type tokenResponse struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Kind         string     `json:"kind"`
+	Prefix       string     `json:"prefix"`
+	Scopes       []string   `json:"scopes"`
+	Organization string     `json:"organization,omitempty"`
+	Connection   string     `json:"connection,omitempty"`
+	Repositories []uint     `json:"repository_ids,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP   string     `json:"last_used_ip,omitempty"`
+	// Token is the plaintext token, only returned when it is created.
+	Token string `json:"token,omitempty"`
+}
+
+func newTokenResponse(token models.APIToken) tokenResponse {
+	resp := tokenResponse{
+		ID:           token.ID,
+		Name:         token.Name,
+		Kind:         token.Kind,
+		Prefix:       token.Prefix,
+		Scopes:       strings.Fields(token.Scopes),
+		Organization: token.Organization,
+		Repositories: token.RepositoryIDs,
+		CreatedAt:    token.CreatedAt,
+		ExpiresAt:    token.ExpiresAt,
+		RevokedAt:    token.RevokedAt,
+		LastUsedAt:   token.LastUsedAt,
+		LastUsedIP:   token.LastUsedIP,
+	}
+	if token.Kind == auth.TokenKindService {
+		resp.Connection = token.Connection
+	}
+	return resp
+}
+
+// CreateToken creates a personal API token, or a service token when an
+// organization is given.
+func CreateToken(c *gin.Context) {
+	var req createTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	var raw string
+	var token *models.APIToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		raw, token, err = auth.CreateToken(c.Request.Context(), tx, auth.CurrentUser(c), auth.TokenRequest{
+			Name:         req.Name,
+			Scopes:       req.Scopes,
+			ExpiresIn:    time.Duration(req.ExpiresInDays) * 24 * time.Hour,
+			Organization: req.Organization,
+			Connection:   req.Connection,
+			Repositories: req.Repositories,
+		})
+		if err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionTokenCreate,
+			TargetType: "api_token",
+			TargetID:   strconv.FormatUint(uint64(token.ID), 10),
+			After:      newTokenResponse(*token),
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidScope), errors.Is(err, auth.ErrInvalidLifetime),
+			errors.Is(err, auth.ErrPersonalTokenGrant), errors.Is(err, auth.ErrUnknownRepository):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, auth.ErrNotOrganizationAdmin), errors.Is(err, auth.ErrServicePrincipalToken):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, auth.ErrUnknownOrganization):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		}
+		return
+	}
+
+	resp := newTokenResponse(*token)
+	resp.Token = raw
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListTokens returns the API tokens created by the current user.
+func ListTokens(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	tokens, err := auth.ListTokens(db, auth.CurrentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tokens"})
+		return
+	}
+
+	resp := make([]tokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		resp = append(resp, newTokenResponse(token))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeToken revokes an API token created by the current user, or a
+// service token of an organization the user administers.
+func RevokeToken(c *gin.Context) {
+	tokenId, err := strconv.ParseUint(c.Param("tokenId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		before, err := auth.RevokeToken(tx, auth.CurrentUser(c), uint(tokenId))
+		if err != nil {
+			return err
+		}
+		var after models.APIToken
+		if err := tx.First(&after, before.ID).Error; err != nil {
+			return err
+		}
+		return audit.Record(c, tx, audit.Entry{
+			Action:     audit.ActionTokenRevoke,
+			TargetType: "api_token",
+			TargetID:   strconv.FormatUint(uint64(before.ID), 10),
+			Before:     newTokenResponse(*before),
+			After:      newTokenResponse(after),
+		})
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionTokenCreate = "token.create"
+	ActionTokenRevoke = "token.revoke"
+	ActionRoleCreate  = "role.create"
+	ActionRoleDelete  = "role.delete"
+
+	ActionRepositoryMonitor   = "repository.monitor"
+	ActionRepositoryUpdate    = "repository.update"
+	ActionRepositoryUnmonitor = "repository.unmonitor"
+	ActionRepositorySync      = "repository.sync"
+
+	ActionDiscoveryRuleCreate = "discovery_rule.create"
+	ActionDiscoveryRuleDelete = "discovery_rule.delete"
+)
+
+// Entry describes a mutating action. Before and After are the state of the
+// target around the action and are stored as JSON; either may be nil.
+type Entry struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+}
+
+// Change is the before and after value of a changed field.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Record appends an audit event for an action performed by the request's
+// user. Pass the transaction the action ran in, so the action and its audit
+// event are committed together.
+func Record(c *gin.Context, db *gorm.DB, entry Entry) error {
+	event, err := newEvent(entry)
+	if err != nil {
+		return err
+	}
+
+	if user := auth.CurrentUser(c); user != nil {
+		event.ActorID = user.ID
+		event.ActorLogin = user.Login
+	}
+	if token := auth.CurrentToken(c); token != nil {
+		event.TokenID = &token.ID
+	}
+	event.IPAddress = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+
+	if err := db.Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func newEvent(entry Entry) (models.AuditEvent, error) {
+	before, err := json.Marshal(entry.Before)
+	if err != nil {
+		return models.AuditEvent{}, fmt.Errorf("failed to encode before state: %w", err)
+	}
+	after, err := json.Marshal(entry.After)
+	if err != nil {
+		return models.AuditEvent{}, fmt.Errorf("failed to encode after state: %w", err)
+	}
+	changes, err := Diff(entry.Before, entry.After)
+	if err != nil {
+		return models.AuditEvent{}, err
+	}
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return models.AuditEvent{}, fmt.Errorf("failed to encode diff: %w", err)
+	}
+
+	return models.AuditEvent{
+		CreatedAt:  time.Now(),
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     string(before),
+		After:      string(after),
+		Diff:       string(diff),
+	}, nil
+}
+
+// Diff compares the JSON encodings of before and after and returns the
+// top-level fields whose values differ.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	for key, value := range b {
+		if !reflect.DeepEqual(value, a[key]) {
+			changes[key] = Change{Before: value, After: a[key]}
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			changes[key] = Change{Before: nil, After: value}
+		}
+	}
+	return changes, nil
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if v == nil {
+		return m, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state: %w", err)
+	}
+	if string(data) == "null" {
+		return m, nil
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("state must encode to a JSON object: %w", err)
+	}
+	return m, nil
+}
+
+// Filter selects audit events. Zero fields match everything.
+type Filter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Query returns a query for the audit events matching the filter, oldest
+// first.
+func Query(db *gorm.DB, filter Filter) *gorm.DB {
+	query := db.Model(&models.AuditEvent{}).Order("id")
+	if filter.Actor != "" {
+		query = query.Where("LOWER(actor_login) = LOWER(?)", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at <= ?", filter.Until)
+	}
+	return query
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository permissions in increasing order of privilege, as reported by
+// the GitHub API.
+var permissionOrder = []string{"pull", "triage", "push", "maintain", "admin"}
+
+// defaultAccessRefreshInterval is used when no refresh interval is configured.
+const defaultAccessRefreshInterval = 15 * time.Minute
+
+// SyncRepositoryAccess replaces the user's cached permissions on github.com
+// repositories with the ones GitHub reports for the user's token. Only
+// repositories known to the aggregator are stored.
+func SyncRepositoryAccess(ctx context.Context, db *gorm.DB, userID int64, token *oauth2.Token) error {
+	client := gh.NewClient(oauthConfig.Client(ctx, token))
+
+	opt := &gh.RepositoryListOptions{
+		Affiliation: "owner,collaborator,organization_member",
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+
+	permissions := make(map[string]string)
+	for {
+		repos, resp, err := client.Repositories.List(ctx, "", opt)
+		if err != nil {
+			return fmt.Errorf("failed to list repositories for user %d: %w", userID, err)
+		}
+		for _, repo := range repos {
+			permissions[repo.GetFullName()] = HighestPermission(repo.Permissions)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	var repos []models.Repository
+	// OAuth tokens are issued by github.com, so only repositories of the
+	// default connection are matched.
+	err := db.WithContext(ctx).Select("id", "full_name").
+		Where("connection = ?", config.DefaultConnection).Find(&repos).Error
+	if err != nil {
+		return fmt.Errorf("failed to load repositories: %w", err)
+	}
+
+	now := time.Now()
+	var access []models.RepositoryAccess
+	for _, repo := range repos {
+		permission, ok := permissions[repo.FullName]
+		if !ok || permission == "" {
+			continue
+		}
+		access = append(access, models.RepositoryAccess{
+			UserID:       userID,
+			RepositoryID: repo.ID,
+			Permission:   permission,
+			SyncedAt:     now,
+		})
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("user_id = ? AND repository_id IN (?)", userID, defaultConnectionRepositoryIDs(tx)).
+			Delete(&models.RepositoryAccess{}).Error
+		if err != nil {
+			return err
+		}
+		if len(access) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&access).Error
+	})
+}
+
+// defaultConnectionRepositoryIDs returns a subquery selecting the IDs of
+// github.com repositories. Permissions on other connections are synced with
+// their own tokens, not users' OAuth tokens.
+func defaultConnectionRepositoryIDs(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Model(&models.Repository{}).Select("id").
+		Where("connection = ?", config.DefaultConnection)
+}
+
+// HighestPermission returns the most privileged of the permissions GitHub
+// reports on a repository, or "" for none.
+func HighestPermission(permissions map[string]bool) string {
+	for i := len(permissionOrder) - 1; i >= 0; i-- {
+		if permissions[permissionOrder[i]] {
+			return permissionOrder[i]
+		}
+	}
+	return ""
+}
+
+// Viewer is who records are scoped for: a user, or the organization a
+// service token acts as.
+type Viewer struct {
+	UserID int64
+	// Organization is set for service tokens. They see the private
+	// repositories of the organization on Connection listed in
+	// Repositories, which are the ones granted to the token, and no others.
+	Organization string
+	Connection   string
+	Repositories []uint
+}
+
+// ViewerFor returns the viewer of requests by user, authenticated with
+// token if it is not nil.
+func ViewerFor(user *models.GitHubUser, token *models.APIToken) Viewer {
+	if token != nil && token.Kind == TokenKindService {
+		return Viewer{UserID: token.UserID, Organization: token.Organization, Connection: tokenConnection(token), Repositories: token.RepositoryIDs}
+	}
+	return Viewer{UserID: user.ID}
+}
+
+// VisibleRepositoryIDs returns a subquery selecting the IDs of repositories
+// the viewer may read: every public repository plus the private repositories
+// the user has access to on GitHub or, for a service token, the
+// organization's repositories granted to the token.
+func VisibleRepositoryIDs(db *gorm.DB, viewer Viewer) *gorm.DB {
+	db = db.Session(&gorm.Session{NewDB: true})
+	query := db.Model(&models.Repository{}).Select("id")
+	if viewer.Organization == "" {
+		return query.Where("(private = ? OR id IN (?))", false,
+			db.Model(&models.RepositoryAccess{}).Select("repository_id").Where("user_id = ?", viewer.UserID))
+	}
+	if len(viewer.Repositories) == 0 {
+		return query.Where("private = ?", false)
+	}
+	return query.Where("(private = ? OR (connection = ? AND LOWER(owner_name) = LOWER(?) AND id IN ?))", false,
+		viewer.Connection, viewer.Organization, viewer.Repositories)
+}
+
+// VisibleGitHubRepositoryIDs is like VisibleRepositoryIDs but selects the
+// connections and GitHub IDs of the repositories, which is what workflow
+// runs reference. GitHub IDs are only unique per connection.
+func VisibleGitHubRepositoryIDs(db *gorm.DB, viewer Viewer) *gorm.DB {
+	db = db.Session(&gorm.Session{NewDB: true})
+	return db.Model(&models.Repository{}).Select("connection", "repo_id").
+		Where("id IN (?)", VisibleRepositoryIDs(db, viewer))
+}
+
+// ScopeRepositories limits a repositories query to the viewer's visible
+// repositories.
+func ScopeRepositories(viewer Viewer) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (?)", VisibleRepositoryIDs(db, viewer))
+	}
+}
+
+// ScopeWorkflows limits a workflows query to workflows of the viewer's
+// visible repositories.
+func ScopeWorkflows(viewer Viewer) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		runs := db.Session(&gorm.Session{NewDB: true}).Model(&models.WorkflowRun{}).Select("connection", "workflow_id").
+			Where("(connection, repository_id) IN (?)", VisibleGitHubRepositoryIDs(db, viewer))
+		return db.Where("(repository_id IN (?) OR (connection, workflow_id) IN (?))", VisibleRepositoryIDs(db, viewer), runs)
+	}
+}
+
+// ScopeWorkflowRuns limits a workflow runs query to runs of the viewer's
+// visible repositories.
+func ScopeWorkflowRuns(viewer Viewer) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(connection, repository_id) IN (?)", VisibleGitHubRepositoryIDs(db, viewer))
+	}
+}
+
+// ScopeJobs limits a jobs query to jobs of the viewer's visible
+// repositories.
+func ScopeJobs(viewer Viewer) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		runs := db.Session(&gorm.Session{NewDB: true}).Model(&models.WorkflowRun{}).Select("connection", "run_id").
+			Where("(connection, repository_id) IN (?)", VisibleGitHubRepositoryIDs(db, viewer))
+		return db.Where("(connection, run_id) IN (?)", runs)
+	}
+}
+
+// ScopeSteps limits a steps query to steps of the viewer's visible
+// repositories.
+func ScopeSteps(viewer Viewer) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		jobs := db.Session(&gorm.Session{NewDB: true}).Model(&models.Job{}).Select("id").Scopes(ScopeJobs(viewer))
+		return db.Where("job_id IN (?)", jobs)
+	}
+}
+
+// AccessRefresher periodically re-syncs the repository access and
+// memberships of users whose OAuth tokens are stored.
+type AccessRefresher struct {
+	db       *gorm.DB
+	interval time.Duration
+	stopChan chan struct{}
+}
+
+// NewAccessRefresher creates an AccessRefresher that syncs every interval.
+func NewAccessRefresher(db *gorm.DB, interval time.Duration) *AccessRefresher {
+	if interval <= 0 {
+		interval = defaultAccessRefreshInterval
+	}
+	return &AccessRefresher{
+		db:       db,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins refreshing in the background.
+func (r *AccessRefresher) Start() {
+	ticker := time.NewTicker(r.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.refresh()
+			case <-r.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the refresher.
+func (r *AccessRefresher) Stop() {
+	close(r.stopChan)
+}
+
+func (r *AccessRefresher) refresh() {
+	userIDs, err := storedTokenUserIDs(r.db)
+	if err != nil {
+		log.Printf("Error listing stored tokens: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		token, ok := loadUserToken(r.db, userID)
+		if !ok {
+			continue
+		}
+
+		err := SyncRepositoryAccess(context.Background(), r.db, userID, token)
+		if err == nil {
+			err = SyncMemberships(context.Background(), r.db, userID, token)
+		}
+		if err != nil {
+			log.Printf("Error refreshing access for user %d: %v", userID, err)
+			var ghErr *gh.ErrorResponse
+			if errors.As(err, &ghErr) && ghErr.Response.StatusCode == http.StatusUnauthorized {
+				// The token was revoked, so drop the user's private access
+				// on github.com and sessions until they log in again.
+				r.revoke(userID)
+			}
+		}
+	}
+}
+
+func (r *AccessRefresher) revoke(userID int64) {
+	if err := deleteUserToken(r.db, userID); err != nil {
+		log.Printf("Error deleting token of user %d: %v", userID, err)
+	}
+	r.db.Unscoped().Where("user_id = ? AND repository_id IN (?)", userID, defaultConnectionRepositoryIDs(r.db)).
+		Delete(&models.RepositoryAccess{})
+	if err := RevokeUserSessions(r.db, userID); err != nil {
+		log.Printf("Error revoking sessions for user %d: %v", userID, err)
+	}
+}
+
+// ErrNoStoredToken is returned when an operation needs the user's OAuth
+// token but none is stored, as is the case for service tokens.
+var ErrNoStoredToken = errors.New("no OAuth token stored for user")
+
+// FetchRepository fetches a repository from GitHub with the user's stored
+// OAuth token, so only repositories the user can see are found.
+func FetchRepository(ctx context.Context, db *gorm.DB, userID int64, owner, repo string) (*gh.Repository, error) {
+	token, ok := loadUserToken(db, userID)
+	if !ok {
+		return nil, ErrNoStoredToken
+	}
+	client := gh.NewClient(oauthConfig.Client(ctx, token))
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	return repository, err
+}
+
+// SaveRepositoryPermission caches the user's permission on a single
+// repository, as reported by GitHub for the user's token.
+func SaveRepositoryPermission(db *gorm.DB, userID int64, repo models.Repository, permissions map[string]bool) error {
+	permission := HighestPermission(permissions)
+	if permission == "" {
+		return db.Unscoped().Where("user_id = ? AND repository_id = ?", userID, repo.ID).
+			Delete(&models.RepositoryAccess{}).Error
+	}
+	access := models.RepositoryAccess{
+		UserID:       userID,
+		RepositoryID: repo.ID,
+		Permission:   permission,
+		SyncedAt:     time.Now(),
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "repository_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission", "synced_at", "updated_at"}),
+	}).Create(&access).Error
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// SyncMemberships replaces the user's stored github.com organization and
+// team memberships with the ones GitHub reports for the user's token. The
+// organizations are stored as well.
+func SyncMemberships(ctx context.Context, db *gorm.DB, userID int64, token *oauth2.Token) error {
+	client := gh.NewClient(oauthConfig.Client(ctx, token))
+	now := time.Now()
+
+	var orgs []*gh.Organization
+	var orgMemberships []models.OrganizationMembership
+	orgOpt := &gh.ListOrgMembershipsOptions{
+		State:       "active",
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+	for {
+		memberships, resp, err := client.Organizations.ListOrgMemberships(ctx, orgOpt)
+		if err != nil {
+			return fmt.Errorf("failed to list organization memberships for user %d: %w", userID, err)
+		}
+		for _, membership := range memberships {
+			org := membership.GetOrganization()
+			orgs = append(orgs, org)
+			orgMemberships = append(orgMemberships, models.OrganizationMembership{
+				UserID:         userID,
+				OrganizationID: org.GetID(),
+				Connection:     config.DefaultConnection,
+				Organization:   org.GetLogin(),
+				Role:           membership.GetRole(),
+				SyncedAt:       now,
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		orgOpt.Page = resp.NextPage
+	}
+
+	var teamMemberships []models.TeamMembership
+	teamOpt := &gh.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := client.Teams.ListUserTeams(ctx, teamOpt)
+		if err != nil {
+			return fmt.Errorf("failed to list teams for user %d: %w", userID, err)
+		}
+		for _, team := range teams {
+			teamMemberships = append(teamMemberships, models.TeamMembership{
+				UserID:         userID,
+				TeamID:         team.GetID(),
+				OrganizationID: team.GetOrganization().GetID(),
+				Organization:   team.GetOrganization().GetLogin(),
+				Slug:           team.GetSlug(),
+				Name:           team.GetName(),
+				SyncedAt:       now,
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		teamOpt.Page = resp.NextPage
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, org := range orgs {
+			if err := saveOrganization(tx, org); err != nil {
+				return err
+			}
+		}
+		err := tx.Unscoped().Where("user_id = ? AND connection = ?", userID, config.DefaultConnection).
+			Delete(&models.OrganizationMembership{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.TeamMembership{}).Error; err != nil {
+			return err
+		}
+		if len(orgMemberships) > 0 {
+			if err := tx.Create(&orgMemberships).Error; err != nil {
+				return err
+			}
+		}
+		if len(teamMemberships) > 0 {
+			if err := tx.Create(&teamMemberships).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
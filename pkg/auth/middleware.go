@@ -1,35 +1,114 @@
 package auth
 
 import (
+	"context"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
 )
 
+type contextKey struct{ name string }
+
+// userContextKey and tokenContextKey are the request context keys the
+// authenticated user and API token are stored under, for handlers that only
+// see the http.Request.
+var (
+	userContextKey  = contextKey{"user"}
+	tokenContextKey = contextKey{"api_token"}
+)
+
+// AuthMiddleware authenticates requests with an API token in the
+// Authorization header or, failing that, with the session cookie.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user := getUserFromSession(c)
+		var user *models.GitHubUser
+		if token, ok := bearerToken(c); ok {
+			user = getUserFromToken(c, token)
+		} else {
+			user = getUserFromSession(c)
+		}
 		if user == nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
 		c.Set("user", user)
+		ctx := WithUser(c.Request.Context(), user)
+		if token := CurrentToken(c); token != nil {
+			ctx = WithToken(ctx, token)
+		}
+		c.Request = c.Request.WithContext(ctx)
 		c.Next()
 	}
 }
 
-func getUserFromSession(c *gin.Context) *models.GitHubUser {
-	// Retrieve user information from session or cookie
-	// For example, using a secure cookie:
-	userID, err := c.Cookie("user_id")
-	if err != nil {
-		return nil
+// WithUser returns a copy of ctx carrying the authenticated user.
+func WithUser(ctx context.Context, user *models.GitHubUser) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the authenticated user stored in ctx, if any.
+func UserFromContext(ctx context.Context) *models.GitHubUser {
+	user, _ := ctx.Value(userContextKey).(*models.GitHubUser)
+	return user
+}
+
+// WithToken returns a copy of ctx carrying the API token the request was
+// authenticated with.
+func WithToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// TokenFromContext returns the API token stored in ctx, if any.
+func TokenFromContext(ctx context.Context) *models.APIToken {
+	token, _ := ctx.Value(tokenContextKey).(*models.APIToken)
+	return token
+}
+
+// ViewerFromContext returns the viewer of the user and token stored in ctx.
+// It returns false if ctx carries no user.
+func ViewerFromContext(ctx context.Context) (Viewer, bool) {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return Viewer{}, false
+	}
+	return ViewerFor(user, TokenFromContext(ctx)), true
+}
+
+// CurrentViewer returns the viewer of the request. It must run after
+// AuthMiddleware.
+func CurrentViewer(c *gin.Context) Viewer {
+	return ViewerFor(CurrentUser(c), CurrentToken(c))
+}
+
+// CurrentUser returns the user set by AuthMiddleware, if any.
+func CurrentUser(c *gin.Context) *models.GitHubUser {
+	user, _ := c.Get("user")
+	u, _ := user.(*models.GitHubUser)
+	return u
+}
+
+// RequireSameOrigin rejects requests a browser sent from another site, for
+// routes that act on the session cookie alone. Browsers send Sec-Fetch-Site
+// or Origin with cross-site requests; clients sending neither aren't
+// browsers and can't be forged into the request.
+func RequireSameOrigin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.GetHeader("Sec-Fetch-Site") {
+		case "", "same-origin", "none":
+		default:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Cross-site request rejected"})
+			return
+		}
+		if origin := c.GetHeader("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, c.Request.Host) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Cross-site request rejected"})
+				return
+			}
+		}
+		c.Next()
 	}
-	// Convert userID to int64
-	id, _ := strconv.ParseInt(userID, 10, 64)
-	// Fetch user from database using userID
-	// Return the user object
-	return &models.GitHubUser{ID: id}
 }
@@ -2,16 +2,17 @@ package auth
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
-	"strconv"
 
-	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -20,88 +21,113 @@ var (
 		ClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
 		Endpoint:     github.Endpoint,
 		RedirectURL:  getEnv("GITHUB_REDIRECT_URL", "http://localhost:8080/callback"),
-		Scopes:       []string{"read:user", "repo", "workflow"},
+		Scopes:       []string{"read:user", "read:org", "repo", "workflow"},
 	}
 )
 
-func GitHubLogin(c *gin.Context) {
-	state := generateStateString()
-	saveOAuthState(c, state)
-	url := oauthConfig.AuthCodeURL(state)
-	c.Redirect(http.StatusFound, url)
+// GitHubProvider logs users in with GitHub OAuth.
+var GitHubProvider IdentityProvider = githubProvider{}
+
+// GitHubLogin starts a GitHub OAuth login.
+var GitHubLogin = Login(GitHubProvider)
+
+// GitHubCallback completes a GitHub OAuth login.
+var GitHubCallback = Callback(GitHubProvider)
+
+type githubProvider struct{}
+
+func (githubProvider) Name() string {
+	return "github"
 }
 
-func GitHubCallback(c *gin.Context) {
-	state := c.Query("state")
-	code := c.Query("code")
+func (githubProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return oauthConfig.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
 
-	savedState := getSavedOAuthState(c)
-	if state != savedState {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
-		return
+// Authenticate stores the GitHub user and their token, and syncs their
+// organizations, teams and repository access.
+func (githubProvider) Authenticate(ctx context.Context, db *gorm.DB, code, nonce, verifier string, current *models.GitHubUser) (*models.GitHubUser, error) {
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
 	}
 
-	token, err := oauthConfig.Exchange(context.Background(), code)
+	user, err := getUserInfo(ctx, token)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Token exchange failed"})
-		return
+		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 
-	user, err := getUserInfo(token)
+	record, err := saveUser(db, user)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
-		return
+		return nil, fmt.Errorf("failed to save user: %w", err)
 	}
 
-	// Save or update user in database (implement saveOrUpdateUser)
-	// Set user session (implement setUserSession)
-	setUserSession(c, user)
+	// Keep the token so the user's access can be refreshed periodically
+	if err := saveUserToken(db, user.ID, token); err != nil {
+		return nil, fmt.Errorf("failed to save token: %w", err)
+	}
+
+	// Sync the organizations, teams and repositories the user can access
+	if err := SyncMemberships(ctx, db, user.ID, token); err != nil {
+		return nil, err
+	}
+	if err := SyncRepositoryAccess(ctx, db, user.ID, token); err != nil {
+		return nil, err
+	}
 
-	c.Redirect(http.StatusFound, "/dashboard")
+	return record, nil
 }
 
-func getUserInfo(token *oauth2.Token) (*GitHubUser, error) {
-	client := oauthConfig.Client(context.Background(), token)
+func getUserInfo(ctx context.Context, token *oauth2.Token) (*GitHubUser, error) {
+	client := oauthConfig.Client(ctx, token)
 	resp, err := client.Get("https://api.github.com/user")
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
 
 	var user GitHubUser
 	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
 		return nil, err
 	}
+	if user.ID == 0 {
+		return nil, errors.New("response has no user ID")
+	}
 	return &user, nil
 }
 
 type GitHubUser struct {
 	ID        int64  `json:"id"`
+	NodeID    string `json:"node_id"`
 	Login     string `json:"login"`
 	Name      string `json:"name"`
 	Email     string `json:"email"`
 	AvatarURL string `json:"avatar_url"`
+	Type      string `json:"type"`
 }
 
-func generateStateString() string {
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
-	if err != nil {
-		panic(err)
+// saveUser creates or updates the user's profile.
+func saveUser(db *gorm.DB, user *GitHubUser) (*models.GitHubUser, error) {
+	record := models.GitHubUser{
+		ID:        user.ID,
+		NodeID:    user.NodeID,
+		Login:     user.Login,
+		Name:      user.Name,
+		Email:     user.Email,
+		AvatarURL: user.AvatarURL,
+		Type:      user.Type,
 	}
-	return hex.EncodeToString(b)
-}
-
-func saveOAuthState(c *gin.Context, state string) {
-	c.SetCookie("oauth_state", state, 300, "/", "", false, true)
-}
-
-func getSavedOAuthState(c *gin.Context) string {
-	state, err := c.Cookie("oauth_state")
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"node_id", "login", "name", "email", "avatar_url", "type", "updated_at"}),
+	}).Create(&record).Error
 	if err != nil {
-		return ""
+		return nil, err
 	}
-	return state
+	return &record, nil
 }
 
 func getEnv(key, fallback string) string {
@@ -110,7 +136,3 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
-
-func setUserSession(c *gin.Context, user *GitHubUser) {
-	c.SetCookie("user_id", strconv.FormatInt(user.ID, 10), 300, "/", "", false, true)
-}
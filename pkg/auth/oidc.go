@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// Identity is a user as asserted by a verified OIDC ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// GitHubLogin is the value of the configured GitHub login claim, if any.
+	GitHubLogin string
+}
+
+// OIDCProvider logs users in with an OpenID Connect provider, such as the
+// company SSO, using the authorization code flow with PKCE.
+type OIDCProvider struct {
+	name             string
+	oauth            oauth2.Config
+	verifier         *oidc.IDTokenVerifier
+	githubLoginClaim string
+	matchEmail       bool
+}
+
+// NewOIDCProvider discovers the provider's endpoints and keys from its
+// issuer URL.
+//
+// Parameters:
+//   - ctx: the context used for discovery and for fetching signing keys later on
+//   - cfg: the provider configuration
+//
+// Returns:
+//   - *OIDCProvider: the configured provider
+//   - error: if discovery fails
+func NewOIDCProvider(ctx context.Context, cfg config.OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", cfg.IssuerURL, err)
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = "oidc"
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	return &OIDCProvider{
+		name: name,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+		},
+		verifier:         provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		githubLoginClaim: cfg.GitHubLoginClaim,
+		matchEmail:       cfg.MatchEmail,
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems the authorization code and returns the identity asserted
+// by the verified ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+
+	identity := &Identity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	if p.githubLoginClaim != "" {
+		identity.GitHubLogin, _ = claims[p.githubLoginClaim].(string)
+	}
+	return identity, nil
+}
+
+// Authenticate verifies the login and resolves the identity to a GitHub
+// user, linking it on first use.
+func (p *OIDCProvider) Authenticate(ctx context.Context, db *gorm.DB, code, nonce, verifier string, current *models.GitHubUser) (*models.GitHubUser, error) {
+	identity, err := p.Exchange(ctx, code, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return p.linkIdentity(db.WithContext(ctx), identity, current)
+}
+
+// linkIdentity returns the GitHub user an identity is linked to. An unlinked
+// identity is linked to the user that is signed in, else to the user named
+// by the GitHub login claim, else, if enabled, to the user with the same
+// verified email address.
+func (p *OIDCProvider) linkIdentity(db *gorm.DB, identity *Identity, current *models.GitHubUser) (*models.GitHubUser, error) {
+	var link models.IdentityLink
+	err := db.Where("provider = ? AND subject = ?", p.name, identity.Subject).First(&link).Error
+	if err == nil {
+		var user models.GitHubUser
+		if err := db.Where("id = ?", link.UserID).First(&user).Error; err != nil {
+			return nil, fmt.Errorf("failed to load linked user %d: %w", link.UserID, err)
+		}
+		if link.Email != identity.Email {
+			if err := db.Model(&link).Update("email", identity.Email).Error; err != nil {
+				return nil, fmt.Errorf("failed to update identity link: %w", err)
+			}
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load identity link: %w", err)
+	}
+
+	user, err := p.findGitHubUser(db, identity, current)
+	if err != nil {
+		return nil, err
+	}
+
+	link = models.IdentityLink{
+		Provider: p.name,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		UserID:   user.ID,
+	}
+	if err := db.Create(&link).Error; err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+	return user, nil
+}
+
+func (p *OIDCProvider) findGitHubUser(db *gorm.DB, identity *Identity, current *models.GitHubUser) (*models.GitHubUser, error) {
+	if current != nil && current.Type != "Organization" {
+		return current, nil
+	}
+
+	var users []models.GitHubUser
+	switch {
+	case identity.GitHubLogin != "":
+		if err := db.Where("LOWER(login) = LOWER(?) AND type <> ?", identity.GitHubLogin, "Organization").Find(&users).Error; err != nil {
+			return nil, fmt.Errorf("failed to load user %s: %w", identity.GitHubLogin, err)
+		}
+	case p.matchEmail && identity.EmailVerified && identity.Email != "":
+		if err := db.Where("LOWER(email) = LOWER(?) AND type <> ?", identity.Email, "Organization").Find(&users).Error; err != nil {
+			return nil, fmt.Errorf("failed to load user by email: %w", err)
+		}
+	}
+
+	if len(users) != 1 {
+		return nil, fmt.Errorf("%w: log in with GitHub once to link your account", ErrIdentityNotLinked)
+	}
+	return &users[0], nil
+}
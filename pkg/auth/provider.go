@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// loginFlowMaxAge is how long, in seconds, a user has to complete a login
+// at the identity provider.
+const loginFlowMaxAge = 300
+
+// ErrIdentityNotLinked is returned when an identity cannot be mapped to a
+// GitHub user.
+var ErrIdentityNotLinked = errors.New("identity is not linked to a GitHub user")
+
+// IdentityProvider is a login service users can sign in with. Whatever the
+// provider, a login resolves to a GitHub user, because repository
+// authorization is based on GitHub permissions.
+type IdentityProvider interface {
+	// Name identifies the provider in routes and cookies.
+	Name() string
+	// AuthCodeURL returns the URL to send the user to. nonce and verifier
+	// are single-use values the provider must bind to the login, for OIDC
+	// nonce and PKCE respectively.
+	AuthCodeURL(state, nonce, verifier string) string
+	// Authenticate completes the login with the authorization code and
+	// returns the GitHub user it resolves to. current is the user that is
+	// already signed in, if any, which providers may link the new identity
+	// to.
+	Authenticate(ctx context.Context, db *gorm.DB, code, nonce, verifier string, current *models.GitHubUser) (*models.GitHubUser, error)
+}
+
+// Login returns a handler that starts a login with the provider.
+func Login(provider IdentityProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := generateStateString()
+		nonce := generateStateString()
+		verifier := oauth2.GenerateVerifier()
+
+		cfg, _ := currentSessionConfig()
+		setLoginCookie(c, cfg, loginCookie(provider, "state"), state, loginFlowMaxAge)
+		setLoginCookie(c, cfg, loginCookie(provider, "nonce"), nonce, loginFlowMaxAge)
+		setLoginCookie(c, cfg, loginCookie(provider, "verifier"), verifier, loginFlowMaxAge)
+
+		c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, verifier))
+	}
+}
+
+// Callback returns a handler that completes a login with the provider and
+// starts a session for the resulting user.
+func Callback(provider IdentityProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, _ := c.Cookie(loginCookie(provider, "state"))
+		nonce, _ := c.Cookie(loginCookie(provider, "nonce"))
+		verifier, _ := c.Cookie(loginCookie(provider, "verifier"))
+
+		cfg, _ := currentSessionConfig()
+		for _, name := range []string{"state", "nonce", "verifier"} {
+			setLoginCookie(c, cfg, loginCookie(provider, name), "", -1)
+		}
+
+		if state == "" || c.Query("state") != state {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
+			return
+		}
+		if errParam := c.Query("error"); errParam != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Login failed: " + errParam})
+			return
+		}
+
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		user, err := provider.Authenticate(c.Request.Context(), db, c.Query("code"), nonce, verifier, getUserFromSession(c))
+		if err != nil {
+			log.Printf("Error completing %s login: %v", provider.Name(), err)
+			if errors.Is(err, ErrIdentityNotLinked) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			}
+			return
+		}
+
+		if err := createSession(c, db, user.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+
+		c.Redirect(http.StatusFound, "/dashboard")
+	}
+}
+
+func loginCookie(provider IdentityProvider, name string) string {
+	return "login_" + provider.Name() + "_" + name
+}
+
+func generateStateString() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
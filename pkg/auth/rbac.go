@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+// Roles in increasing order of privilege. Viewers can read, maintainers can
+// change what is monitored and admins can additionally manage roles and
+// service tokens.
+const (
+	RoleViewer     = "viewer"
+	RoleMaintainer = "maintainer"
+	RoleAdmin      = "admin"
+
+	SubjectUser = "user"
+	SubjectTeam = "team"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+
+var roleOrder = []string{RoleViewer, RoleMaintainer, RoleAdmin}
+
+var (
+	adminsMu sync.RWMutex
+	admins   = map[string]bool{}
+)
+
+// ConfigureAdmins sets the GitHub logins that are administrators of the
+// whole instance.
+func ConfigureAdmins(logins []string) {
+	set := make(map[string]bool, len(logins))
+	for _, login := range logins {
+		set[strings.ToLower(login)] = true
+	}
+
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+	admins = set
+}
+
+// IsAdmin reports whether the user is an administrator of the instance.
+func IsAdmin(user *models.GitHubUser) bool {
+	if user == nil || user.Type == "Organization" {
+		return false
+	}
+	adminsMu.RLock()
+	defer adminsMu.RUnlock()
+	return admins[strings.ToLower(user.Login)]
+}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of min.
+func RoleAtLeast(role, min string) bool {
+	return roleRank(role) >= roleRank(min) && roleRank(role) >= 0
+}
+
+func roleRank(role string) int {
+	for i, r := range roleOrder {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func maxRole(a, b string) string {
+	if roleRank(b) > roleRank(a) {
+		return b
+	}
+	return a
+}
+
+// OrganizationRole returns the user's role in an organization of a
+// connection, or "" if the user has none. Roles come from instance
+// administrators, the user's GitHub organization role (owners are admins,
+// members are viewers) and role bindings on the user or one of their teams.
+// A service token's organization is an admin of itself.
+//
+// Organizations of different connections are distinct even when their
+// logins are the same. Memberships on other connections are synced for the
+// users mapped to their logins there; role bindings are github.com's.
+func OrganizationRole(db *gorm.DB, user *models.GitHubUser, connection, org string) (string, error) {
+	if IsAdmin(user) {
+		return RoleAdmin, nil
+	}
+	if user.Type == "Organization" {
+		if strings.EqualFold(user.Login, org) && accountConnection(user) == connection {
+			return RoleAdmin, nil
+		}
+		return "", nil
+	}
+
+	role := ""
+
+	var memberships []models.OrganizationMembership
+	err := db.Where("user_id = ? AND connection = ? AND LOWER(organization) = LOWER(?)", user.ID, connection, org).
+		Find(&memberships).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to load organization membership: %w", err)
+	}
+	for _, membership := range memberships {
+		switch membership.Role {
+		case "admin":
+			role = maxRole(role, RoleAdmin)
+		case "member":
+			role = maxRole(role, RoleViewer)
+		}
+	}
+	if connection != config.DefaultConnection {
+		return role, nil
+	}
+
+	var bindings []models.RoleBinding
+	err = roleBindings(db, user, org).Where("repository_id IS NULL").Find(&bindings).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to load role bindings: %w", err)
+	}
+	for _, binding := range bindings {
+		role = maxRole(role, binding.Role)
+	}
+
+	return role, nil
+}
+
+// RepositoryRole returns the user's role on a repository: the highest of
+// their role in the repository's organization on its connection, the role
+// implied by their GitHub permission on the repository and repository role
+// bindings. Everyone is a viewer of public repositories.
+func RepositoryRole(db *gorm.DB, user *models.GitHubUser, repo models.Repository) (string, error) {
+	org := repo.OwnerLogin()
+	role, err := OrganizationRole(db, user, repo.Connection, org)
+	if err != nil {
+		return "", err
+	}
+
+	if !repo.Private {
+		role = maxRole(role, RoleViewer)
+	}
+
+	var access []models.RepositoryAccess
+	if err := db.Where("user_id = ? AND repository_id = ?", user.ID, repo.ID).Find(&access).Error; err != nil {
+		return "", fmt.Errorf("failed to load repository access: %w", err)
+	}
+	for _, a := range access {
+		role = maxRole(role, permissionRole(a.Permission))
+	}
+	if repo.Connection != config.DefaultConnection {
+		// Role bindings are only stored for github.com
+		return role, nil
+	}
+
+	var bindings []models.RoleBinding
+	if err := roleBindings(db, user, org).Where("repository_id = ?", repo.ID).Find(&bindings).Error; err != nil {
+		return "", fmt.Errorf("failed to load role bindings: %w", err)
+	}
+	for _, binding := range bindings {
+		role = maxRole(role, binding.Role)
+	}
+
+	return role, nil
+}
+
+// PermissionsRole returns the role implied by a user's permissions on a
+// GitHub repository, as returned by the GitHub API, or "" for none.
+func PermissionsRole(permissions map[string]bool) string {
+	return permissionRole(HighestPermission(permissions))
+}
+
+func permissionRole(permission string) string {
+	switch permission {
+	case "admin":
+		return RoleAdmin
+	case "maintain", "push":
+		return RoleMaintainer
+	case "triage", "pull":
+		return RoleViewer
+	}
+	return ""
+}
+
+// roleBindings selects the bindings of an organization that apply to the
+// user directly or through one of their teams.
+func roleBindings(db *gorm.DB, user *models.GitHubUser, org string) *gorm.DB {
+	teams := db.Session(&gorm.Session{NewDB: true}).Model(&models.TeamMembership{}).Select("slug").
+		Where("user_id = ? AND LOWER(organization) = LOWER(?)", user.ID, org)
+	return db.Model(&models.RoleBinding{}).
+		Where("LOWER(organization) = LOWER(?)", org).
+		Where("((subject_type = ? AND user_id = ?) OR (subject_type = ? AND team_slug IN (?)))",
+			SubjectUser, user.ID, SubjectTeam, teams)
+}
+
+// accountConnection returns the connection of the account a user or service
+// token acts as.
+func accountConnection(user *models.GitHubUser) string {
+	if user.Connection == "" {
+		return config.DefaultConnection
+	}
+	return user.Connection
+}
+
+// RequireAdmin rejects requests from users that are not administrators of
+// the instance. It must run after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsAdmin(CurrentUser(c)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireOrganizationRole rejects requests from users without at least min
+// in the github.com organization named by the param route parameter. It
+// must run after AuthMiddleware.
+func RequireOrganizationRole(min, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, ok := c.MustGet("db").(*gorm.DB)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+			return
+		}
+
+		role, err := OrganizationRole(db, CurrentUser(c), config.DefaultConnection, c.Param(param))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve role"})
+			return
+		}
+		if !RoleAtLeast(role, min) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("The %s role is required", min)})
+			return
+		}
+		c.Next()
+	}
+}
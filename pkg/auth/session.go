@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSessionCookieName = "session"
+	defaultSessionTTL        = 24 * time.Hour
+
+	// lastSeenResolution limits how often a session's last-seen time is
+	// written back to the database.
+	lastSeenResolution = time.Minute
+)
+
+var (
+	sessionMu     sync.RWMutex
+	sessionConfig = config.SessionConfig{
+		CookieName: defaultSessionCookieName,
+		TTL:        defaultSessionTTL,
+		Secure:     true,
+		SameSite:   "lax",
+	}
+	sessionSecret = randomSecret()
+)
+
+// ConfigureSessions sets the signing secret and cookie attributes used for
+// login sessions. Without a secret a random one is generated, which means
+// sessions do not survive a restart.
+func ConfigureSessions(cfg config.SessionConfig) {
+	if cfg.CookieName == "" {
+		cfg.CookieName = defaultSessionCookieName
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultSessionTTL
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		log.Println("No session secret configured; sessions will be invalidated on restart")
+		secret = randomSecret()
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	sessionConfig = cfg
+	sessionSecret = secret
+}
+
+func currentSessionConfig() (config.SessionConfig, []byte) {
+	sessionMu.RLock()
+	defer sessionMu.RUnlock()
+	return sessionConfig, sessionSecret
+}
+
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// createSession stores a new session for the user and sets the signed
+// session cookie.
+func createSession(c *gin.Context, db *gorm.DB, userID int64) error {
+	cfg, secret := currentSessionConfig()
+
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	sessionID := base64.RawURLEncoding.EncodeToString(id)
+
+	now := time.Now()
+	session := models.Session{
+		TokenHash:  hashSessionID(sessionID),
+		UserID:     userID,
+		ExpiresAt:  now.Add(cfg.TTL),
+		LastSeenAt: now,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Drop the user's expired sessions while we are here
+		if err := tx.Unscoped().Where("user_id = ? AND expires_at < ?", userID, now).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&session).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	setCookie(c, cfg, cfg.CookieName, signSessionID(secret, sessionID), int(cfg.TTL.Seconds()))
+	return nil
+}
+
+// getUserFromSession returns the user of the request's session, or nil if
+// the cookie is missing, tampered with, expired or revoked.
+func getUserFromSession(c *gin.Context) *models.GitHubUser {
+	cfg, secret := currentSessionConfig()
+
+	cookie, err := c.Cookie(cfg.CookieName)
+	if err != nil {
+		return nil
+	}
+	sessionID, ok := verifySessionID(secret, cookie)
+	if !ok {
+		return nil
+	}
+
+	value, ok := c.Get("db")
+	if !ok {
+		return nil
+	}
+	db := value.(*gorm.DB)
+
+	var session models.Session
+	err = db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashSessionID(sessionID), time.Now()).
+		First(&session).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading session: %v", err)
+		}
+		return nil
+	}
+
+	var user models.GitHubUser
+	if err := db.Where("id = ?", session.UserID).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading user %d: %v", session.UserID, err)
+		}
+		return nil
+	}
+
+	if time.Since(session.LastSeenAt) > lastSeenResolution {
+		db.Model(&session).Update("last_seen_at", time.Now())
+	}
+
+	c.Set("session", &session)
+	return &user
+}
+
+// Logout revokes the current session and clears the session cookie.
+func Logout(c *gin.Context) {
+	cfg, secret := currentSessionConfig()
+
+	if cookie, err := c.Cookie(cfg.CookieName); err == nil {
+		if sessionID, ok := verifySessionID(secret, cookie); ok {
+			db, ok := c.MustGet("db").(*gorm.DB)
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database connection not found"})
+				return
+			}
+			err := db.Model(&models.Session{}).
+				Where("token_hash = ? AND revoked_at IS NULL", hashSessionID(sessionID)).
+				Update("revoked_at", time.Now()).Error
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+				return
+			}
+		}
+	}
+
+	setCookie(c, cfg, cfg.CookieName, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// RevokeUserSessions revokes every active session of the user.
+func RevokeUserSessions(db *gorm.DB, userID int64) error {
+	return db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// signSessionID returns the cookie value for a session ID: the ID followed
+// by its HMAC-SHA256 signature.
+func signSessionID(secret []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sessionID))
+	return sessionID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySessionID checks the signature of a cookie value and returns the
+// session ID it carries.
+func verifySessionID(secret []byte, value string) (string, bool) {
+	sessionID, _, ok := strings.Cut(value, ".")
+	if !ok || sessionID == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signSessionID(secret, sessionID)), []byte(value)) {
+		return "", false
+	}
+	return sessionID, true
+}
+
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+func setCookie(c *gin.Context, cfg config.SessionConfig, name, value string, maxAge int) {
+	c.SetSameSite(parseSameSite(cfg.SameSite))
+	c.SetCookie(name, value, maxAge, "/", cfg.Domain, cfg.Secure, true)
+}
+
+// setLoginCookie sets a cookie of a login flow. They are always Lax, since a
+// strict cookie isn't sent on the redirect back from the identity provider.
+func setLoginCookie(c *gin.Context, cfg config.SessionConfig, name, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", cfg.Domain, cfg.Secure, true)
+}
+
+func parseSameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	tokenCipherMu sync.RWMutex
+	tokenCipher   = newTokenCipher(randomSecret())
+)
+
+// ConfigureTokenEncryption sets the key OAuth tokens are encrypted with at
+// rest. Without a key a random one is generated, which means stored tokens
+// cannot be read after a restart and users have to log in again.
+func ConfigureTokenEncryption(key string) {
+	secret := []byte(key)
+	if len(secret) == 0 {
+		log.Println("No token encryption key configured; stored OAuth tokens will be unreadable after restart")
+		secret = randomSecret()
+	}
+
+	tokenCipherMu.Lock()
+	defer tokenCipherMu.Unlock()
+	tokenCipher = newTokenCipher(secret)
+}
+
+// newTokenCipher derives an AES-256-GCM cipher from secret.
+func newTokenCipher(secret []byte) cipher.AEAD {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func currentTokenCipher() cipher.AEAD {
+	tokenCipherMu.RLock()
+	defer tokenCipherMu.RUnlock()
+	return tokenCipher
+}
+
+func encryptToken(token *oauth2.Token) ([]byte, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := currentTokenCipher()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decryptToken(ciphertext []byte) (*oauth2.Token, error) {
+	aead := currentTokenCipher()
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// saveUserToken stores the user's OAuth token, replacing any previous one.
+func saveUserToken(db *gorm.DB, userID int64, token *oauth2.Token) error {
+	encrypted, err := encryptToken(token)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt token: %w", err)
+	}
+	record := models.UserToken{UserID: userID, EncryptedToken: encrypted}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"encrypted_token", "updated_at"}),
+	}).Create(&record).Error
+}
+
+// loadUserToken returns the user's stored OAuth token. It returns false if
+// there is none or it cannot be decrypted with the current key.
+func loadUserToken(db *gorm.DB, userID int64) (*oauth2.Token, bool) {
+	var record models.UserToken
+	if err := db.Where("user_id = ?", userID).First(&record).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading token of user %d: %v", userID, err)
+		}
+		return nil, false
+	}
+
+	token, err := decryptToken(record.EncryptedToken)
+	if err != nil {
+		log.Printf("Error decrypting token of user %d: %v", userID, err)
+		return nil, false
+	}
+	return token, true
+}
+
+// deleteUserToken removes the user's stored OAuth token.
+func deleteUserToken(db *gorm.DB, userID int64) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&models.UserToken{}).Error
+}
+
+// storedTokenUserIDs returns the IDs of users whose OAuth token is stored.
+func storedTokenUserIDs(db *gorm.DB) ([]int64, error) {
+	var ids []int64
+	err := db.Model(&models.UserToken{}).Pluck("user_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,356 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TokenKindPersonal = "personal"
+	TokenKindService  = "service"
+
+	// TokenScopeRead allows reading repositories, workflows, runs and stats.
+	TokenScopeRead = "read"
+	// TokenScopeExport allows bulk exports.
+	TokenScopeExport = "export"
+	// TokenScopeAdmin allows everything, including managing API tokens.
+	TokenScopeAdmin = "admin"
+
+	tokenPrefix          = "gaa_"
+	DefaultTokenLifetime = 30 * 24 * time.Hour
+	MaxTokenLifetime     = 365 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidScope          = errors.New("invalid token scope")
+	ErrInvalidLifetime       = errors.New("token lifetime must be between 1 day and 365 days")
+	ErrNotOrganizationAdmin  = errors.New("only organization admins can create service tokens")
+	ErrUnknownOrganization   = errors.New("organization is not known; a member has to log in first")
+	ErrServicePrincipalToken = errors.New("service tokens cannot create API tokens")
+	ErrPersonalTokenGrant    = errors.New("repositories can only be granted to service tokens")
+	ErrUnknownRepository     = errors.New("repository is not known or not owned by the organization")
+)
+
+var tokenScopes = []string{TokenScopeRead, TokenScopeExport, TokenScopeAdmin}
+
+// TokenRequest describes an API token to create.
+type TokenRequest struct {
+	Name   string
+	Scopes []string
+	// ExpiresIn is the lifetime of the token; zero uses DefaultTokenLifetime.
+	ExpiresIn time.Duration
+	// Organization, when set, creates a service token for the organization
+	// instead of a personal token.
+	Organization string
+	// Connection is the GitHub instance of Organization; empty means
+	// github.com.
+	Connection string
+	// Repositories are the full names of the organization's repositories
+	// granted to a service token. It reads no other private repositories.
+	Repositories []string
+}
+
+// CreateToken creates an API token on behalf of creator. The plaintext token
+// is returned only here; afterwards only its hash is known.
+func CreateToken(ctx context.Context, db *gorm.DB, creator *models.GitHubUser, req TokenRequest) (string, *models.APIToken, error) {
+	if creator.Type == "Organization" {
+		return "", nil, ErrServicePrincipalToken
+	}
+	if len(req.Scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range req.Scopes {
+		if !validTokenScope(scope) {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	if req.ExpiresIn == 0 {
+		req.ExpiresIn = DefaultTokenLifetime
+	}
+	if req.ExpiresIn < 24*time.Hour || req.ExpiresIn > MaxTokenLifetime {
+		return "", nil, ErrInvalidLifetime
+	}
+	if req.Organization == "" && len(req.Repositories) > 0 {
+		return "", nil, ErrPersonalTokenGrant
+	}
+
+	token := &models.APIToken{
+		Name:        req.Name,
+		Kind:        TokenKindPersonal,
+		Scopes:      strings.Join(req.Scopes, " "),
+		UserID:      creator.ID,
+		CreatedByID: creator.ID,
+		ExpiresAt:   time.Now().Add(req.ExpiresIn),
+	}
+
+	if req.Organization != "" {
+		if req.Connection == "" {
+			req.Connection = config.DefaultConnection
+		}
+		org, err := organizationForServiceToken(db, creator, req.Connection, req.Organization)
+		if err != nil {
+			return "", nil, err
+		}
+		token.Kind = TokenKindService
+		token.UserID = org.ID
+		token.Organization = org.Login
+		token.Connection = req.Connection
+		token.RepositoryIDs, err = grantedRepositories(db, req.Connection, org.Login, req.Repositories)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	raw, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token.TokenHash = hashToken(raw)
+	token.Prefix = raw[:len(tokenPrefix)+8]
+
+	if err := db.WithContext(ctx).Create(token).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	return raw, token, nil
+}
+
+// ListTokens returns the API tokens created by the user, newest first.
+func ListTokens(db *gorm.DB, creatorID int64) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := db.Where("created_by_id = ?", creatorID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeToken revokes an API token and returns it as it was before. Users can
+// revoke the tokens they created; organization admins can also revoke the
+// organization's service tokens. It returns gorm.ErrRecordNotFound if there
+// is no such active token.
+func RevokeToken(db *gorm.DB, user *models.GitHubUser, tokenID uint) (*models.APIToken, error) {
+	var token models.APIToken
+	if err := db.Where("id = ? AND revoked_at IS NULL", tokenID).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	if token.CreatedByID != user.ID {
+		if token.Kind != TokenKindService {
+			return nil, gorm.ErrRecordNotFound
+		}
+		role, err := OrganizationRole(db, user, tokenConnection(&token), token.Organization)
+		if err != nil {
+			return nil, err
+		}
+		if !RoleAtLeast(role, RoleAdmin) {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+
+	before := token
+	if err := db.Model(&token).Update("revoked_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+// HasScope reports whether the token grants scope. The admin scope grants
+// every scope.
+func HasScope(token *models.APIToken, scope string) bool {
+	for _, s := range strings.Fields(token.Scopes) {
+		if s == scope || s == TokenScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects requests authenticated with an API token that lacks
+// scope. Browser sessions are not restricted. It must run after
+// AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := CurrentToken(c); token != nil && !HasScope(token, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Token is missing the %q scope", scope)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentToken returns the API token the request was authenticated with, if
+// any.
+func CurrentToken(c *gin.Context) *models.APIToken {
+	token, _ := c.Get("api_token")
+	t, _ := token.(*models.APIToken)
+	return t
+}
+
+// getUserFromToken authenticates a bearer token and returns the user or
+// organization it acts as.
+func getUserFromToken(c *gin.Context, raw string) *models.GitHubUser {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return nil
+	}
+
+	value, ok := c.Get("db")
+	if !ok {
+		return nil
+	}
+	db := value.(*gorm.DB)
+
+	var token models.APIToken
+	err := db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(raw), time.Now()).
+		First(&token).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading API token: %v", err)
+		}
+		return nil
+	}
+
+	var user models.GitHubUser
+	if token.Kind == TokenKindService && tokenConnection(&token) != config.DefaultConnection {
+		// Only github.com accounts are stored
+		user = models.GitHubUser{Login: token.Organization, Type: "Organization", Connection: token.Connection}
+	} else if err := db.Where("id = ?", token.UserID).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading user %d for API token %d: %v", token.UserID, token.ID, err)
+		}
+		return nil
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > lastSeenResolution || token.LastUsedIP != c.ClientIP() {
+		now := time.Now()
+		db.Model(&token).Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": c.ClientIP()})
+	}
+
+	c.Set("api_token", &token)
+	return &user
+}
+
+// tokenConnection returns the connection of a token's organization.
+func tokenConnection(token *models.APIToken) string {
+	if token.Connection == "" {
+		return config.DefaultConnection
+	}
+	return token.Connection
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// organizationForServiceToken returns the organization of a connection a
+// service token is requested for, provided the creator is one of its
+// admins. Organizations of other connections aren't stored, so their
+// accounts have no ID.
+func organizationForServiceToken(db *gorm.DB, creator *models.GitHubUser, connection, login string) (*models.GitHubUser, error) {
+	role, err := OrganizationRole(db, creator, connection, login)
+	if err != nil {
+		return nil, err
+	}
+	if !RoleAtLeast(role, RoleAdmin) {
+		return nil, ErrNotOrganizationAdmin
+	}
+	if connection != config.DefaultConnection {
+		return &models.GitHubUser{Login: login, Type: "Organization", Connection: connection}, nil
+	}
+
+	var org models.GitHubUser
+	err = db.Where("LOWER(login) = LOWER(?) AND type = ?", login, "Organization").First(&org).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownOrganization
+		}
+		return nil, fmt.Errorf("failed to load organization %s: %w", login, err)
+	}
+	return &org, nil
+}
+
+// grantedRepositories returns the IDs of the repositories with the given
+// full names of an organization on a connection. Every name has to match a
+// repository the organization owns.
+func grantedRepositories(db *gorm.DB, connection, org string, fullNames []string) ([]uint, error) {
+	if len(fullNames) == 0 {
+		return nil, nil
+	}
+
+	var repos []models.Repository
+	err := db.Select("id", "full_name").
+		Where("connection = ? AND LOWER(owner_name) = LOWER(?) AND full_name IN ?", connection, org, fullNames).
+		Find(&repos).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories: %w", err)
+	}
+
+	found := make(map[string]bool, len(repos))
+	ids := make([]uint, 0, len(repos))
+	for _, repo := range repos {
+		found[repo.FullName] = true
+		ids = append(ids, repo.ID)
+	}
+	for _, name := range fullNames {
+		if !found[name] {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRepository, name)
+		}
+	}
+	return ids, nil
+}
+
+// saveOrganization creates or updates the account of an organization, so
+// service tokens can act as it.
+func saveOrganization(db *gorm.DB, org *gh.Organization) error {
+	record := models.GitHubUser{
+		ID:        org.GetID(),
+		NodeID:    org.GetNodeID(),
+		Login:     org.GetLogin(),
+		Name:      org.GetName(),
+		AvatarURL: org.GetAvatarURL(),
+		Type:      "Organization",
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"node_id", "login", "name", "avatar_url", "type", "updated_at"}),
+	}).Create(&record).Error
+}
+
+func validTokenScope(scope string) bool {
+	for _, s := range tokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
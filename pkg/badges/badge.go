@@ -0,0 +1,96 @@
+package badges
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// Shields-style colors used for badges.
+const (
+	ColorBrightGreen = "#4c1"
+	ColorGreen       = "#97ca00"
+	ColorYellow      = "#dfb317"
+	ColorOrange      = "#fe7d37"
+	ColorRed         = "#e05d44"
+	ColorGrey        = "#9f9f9f"
+	ColorBlue        = "#007ec6"
+)
+
+const (
+	horizontalPadding = 10
+	defaultCharWidth  = 7
+)
+
+// charWidths approximates the advance width of characters in 11px Verdana,
+// the font used by shields.io badges. Characters not listed use
+// defaultCharWidth.
+var charWidths = map[rune]int{
+	' ': 4, '!': 4, '%': 12, '(': 5, ')': 5, ',': 4, '-': 5, '.': 4, '/': 5,
+	':': 5, 'I': 5, 'J': 5, 'f': 4, 'i': 3, 'j': 4, 'l': 3, 'r': 5, 't': 4,
+	'm': 11, 'w': 9, 'M': 10, 'W': 11,
+}
+
+// Badge is a two-part label/message badge.
+type Badge struct {
+	Label   string
+	Message string
+	Color   string
+}
+
+var badgeTemplate = template.Must(template.New("badge").Parse(
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">` +
+		`<title>{{.Label}}: {{.Message}}</title>` +
+		`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>` +
+		`<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>` +
+		`<g clip-path="url(#r)">` +
+		`<rect width="{{.LabelWidth}}" height="20" fill="#555"/>` +
+		`<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>` +
+		`<rect width="{{.Width}}" height="20" fill="url(#s)"/>` +
+		`</g>` +
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">` +
+		`<text x="{{.LabelX}}" y="15" fill="#010101" fill-opacity=".3">{{.Label}}</text>` +
+		`<text x="{{.LabelX}}" y="14">{{.Label}}</text>` +
+		`<text x="{{.MessageX}}" y="15" fill="#010101" fill-opacity=".3">{{.Message}}</text>` +
+		`<text x="{{.MessageX}}" y="14">{{.Message}}</text>` +
+		`</g></svg>`,
+))
+
+// SVG renders the badge as a flat shields-style SVG image.
+func (b Badge) SVG() ([]byte, error) {
+	labelWidth := textWidth(b.Label) + horizontalPadding
+	messageWidth := textWidth(b.Message) + horizontalPadding
+
+	var buf bytes.Buffer
+	err := badgeTemplate.Execute(&buf, struct {
+		Badge
+		Width        int
+		LabelWidth   int
+		MessageWidth int
+		LabelX       string
+		MessageX     string
+	}{
+		Badge:        b,
+		Width:        labelWidth + messageWidth,
+		LabelWidth:   labelWidth,
+		MessageWidth: messageWidth,
+		LabelX:       fmt.Sprintf("%.1f", float64(labelWidth)/2),
+		MessageX:     fmt.Sprintf("%.1f", float64(labelWidth)+float64(messageWidth)/2),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func textWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if w, ok := charWidths[r]; ok {
+			width += w
+		} else {
+			width += defaultCharWidth
+		}
+	}
+	return width
+}
@@ -0,0 +1,181 @@
+package badges
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+)
+
+// Metric is the value shown on a badge.
+type Metric string
+
+const (
+	MetricStatus      Metric = "status"
+	MetricSuccessRate Metric = "success_rate"
+	MetricDuration    Metric = "duration"
+	MetricFlakiness   Metric = "flakiness"
+)
+
+// ParseMetric returns the metric with the given name.
+func ParseMetric(name string) (Metric, error) {
+	switch m := Metric(name); m {
+	case MetricStatus, MetricSuccessRate, MetricDuration, MetricFlakiness:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unsupported metric: %q", name)
+	}
+}
+
+// ForRuns builds a badge for the metric from a workflow's runs. Only
+// completed runs are considered; days is the window shown in the message.
+func ForRuns(metric Metric, label string, runs []models.WorkflowRun, days int) Badge {
+	completed := completedRuns(runs)
+	window := fmt.Sprintf("(%dd)", days)
+
+	switch metric {
+	case MetricSuccessRate:
+		if len(completed) == 0 {
+			return Badge{Label: label, Message: "no runs", Color: ColorGrey}
+		}
+		rate := models.SummarizeRuns(completed).SuccessRate
+		return Badge{Label: label, Message: fmt.Sprintf("%.0f%% %s", rate, window), Color: rateColor(rate)}
+
+	case MetricDuration:
+		median, ok := MedianDuration(completed)
+		if !ok {
+			return Badge{Label: label, Message: "no runs", Color: ColorGrey}
+		}
+		return Badge{Label: label, Message: fmt.Sprintf("%s %s", formatDuration(median), window), Color: ColorBlue}
+
+	case MetricFlakiness:
+		rate, ok := Flakiness(completed)
+		if !ok {
+			return Badge{Label: label, Message: "no runs", Color: ColorGrey}
+		}
+		return Badge{Label: label, Message: fmt.Sprintf("%.0f%% %s", rate, window), Color: rateColor(100 - rate)}
+
+	default:
+		if len(completed) == 0 {
+			return Badge{Label: label, Message: "no status", Color: ColorGrey}
+		}
+		return statusBadge(label, latestRun(completed).Conclusion)
+	}
+}
+
+// MedianDuration returns the median wall time of the given runs.
+func MedianDuration(runs []models.WorkflowRun) (time.Duration, bool) {
+	if len(runs) == 0 {
+		return 0, false
+	}
+
+	durations := make([]time.Duration, 0, len(runs))
+	for _, run := range runs {
+		start := run.CreatedAt
+		if run.RunStartedAt != nil {
+			start = *run.RunStartedAt
+		}
+		durations = append(durations, run.UpdatedAt.Sub(start))
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2, true
+	}
+	return durations[mid], true
+}
+
+// Flakiness returns the percentage of commits whose runs had both failing
+// and successful conclusions, i.e. commits that only went green on a retry.
+func Flakiness(runs []models.WorkflowRun) (float64, bool) {
+	type outcome struct{ failed, succeeded bool }
+
+	bySHA := make(map[string]*outcome)
+	for _, run := range runs {
+		o, ok := bySHA[run.HeadSHA]
+		if !ok {
+			o = &outcome{}
+			bySHA[run.HeadSHA] = o
+		}
+		switch run.Conclusion {
+		case "success":
+			o.succeeded = true
+		case "failure", "timed_out":
+			o.failed = true
+		}
+	}
+	if len(bySHA) == 0 {
+		return 0, false
+	}
+
+	flaky := 0
+	for _, o := range bySHA {
+		if o.failed && o.succeeded {
+			flaky++
+		}
+	}
+	return float64(flaky) / float64(len(bySHA)) * 100, true
+}
+
+func completedRuns(runs []models.WorkflowRun) []models.WorkflowRun {
+	completed := make([]models.WorkflowRun, 0, len(runs))
+	for _, run := range runs {
+		if run.Status == "completed" {
+			completed = append(completed, run)
+		}
+	}
+	return completed
+}
+
+func latestRun(runs []models.WorkflowRun) models.WorkflowRun {
+	latest := runs[0]
+	for _, run := range runs[1:] {
+		if run.CreatedAt.After(latest.CreatedAt) {
+			latest = run
+		}
+	}
+	return latest
+}
+
+func statusBadge(label, conclusion string) Badge {
+	switch conclusion {
+	case "success":
+		return Badge{Label: label, Message: "passing", Color: ColorBrightGreen}
+	case "failure", "timed_out", "startup_failure":
+		return Badge{Label: label, Message: "failing", Color: ColorRed}
+	case "cancelled":
+		return Badge{Label: label, Message: "cancelled", Color: ColorGrey}
+	default:
+		return Badge{Label: label, Message: conclusion, Color: ColorYellow}
+	}
+}
+
+// rateColor picks a color for a percentage where higher is better.
+func rateColor(rate float64) string {
+	switch {
+	case rate >= 95:
+		return ColorBrightGreen
+	case rate >= 90:
+		return ColorGreen
+	case rate >= 75:
+		return ColorYellow
+	case rate >= 50:
+		return ColorOrange
+	default:
+		return ColorRed
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+}
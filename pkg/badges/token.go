@@ -0,0 +1,28 @@
+package badges
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// tokenLength is the number of hex characters kept from the HMAC so badge
+// URLs stay short.
+const tokenLength = 32
+
+// Token returns the token that grants access to badges of a private
+// repository. Tokens are derived from the secret, so rotating the secret
+// revokes every token.
+func Token(secret, fullName string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fullName))
+	return hex.EncodeToString(mac.Sum(nil))[:tokenLength]
+}
+
+// VerifyToken reports whether token grants access to the repository's badges.
+func VerifyToken(secret, fullName, token string) bool {
+	if secret == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(Token(secret, fullName)), []byte(token))
+}
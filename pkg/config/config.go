@@ -2,15 +2,45 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// DefaultConnection is the name of the connection configured by the github
+// section, which is also used for OAuth login.
+const DefaultConnection = "github.com"
+
 type GitHubConfig struct {
 	ClientID      string
 	ClientSecret  string
 	AccessToken   string
 	WebhookSecret string
+	HTTP          GitHubHTTPConfig
+}
+
+// GitHubHTTPConfig tunes the HTTP transport of every GitHub connection.
+type GitHubHTTPConfig struct {
+	Timeout          time.Duration
+	MaxRetries       int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// ConnectionConfig configures an additional GitHub instance, such as a
+// GitHub Enterprise Server.
+type ConnectionConfig struct {
+	Name string `mapstructure:"name"`
+	// BaseURL and UploadURL are the API endpoints, for example
+	// https://github.example.com/api/v3/. Empty means api.github.com.
+	BaseURL       string `mapstructure:"base_url"`
+	UploadURL     string `mapstructure:"upload_url"`
+	AccessToken   string `mapstructure:"access_token"`
+	WebhookSecret string `mapstructure:"webhook_secret"`
+	// Users maps github.com logins to logins on this instance. Their
+	// repository permissions and organization roles here are synced with
+	// AccessToken; users not listed can only read its public repositories.
+	Users map[string]string `mapstructure:"users"`
 }
 
 type DatabaseConfig struct {
@@ -20,13 +50,110 @@ type DatabaseConfig struct {
 	Password string
 }
 
+type TracingConfig struct {
+	Enabled     bool
+	Endpoint    string
+	Insecure    bool
+	Headers     map[string]string
+	ServiceName string
+}
+
+type BadgesConfig struct {
+	Secret      string
+	CacheMaxAge int
+}
+
+type AuthConfig struct {
+	AccessRefreshInterval time.Duration
+	TokenEncryptionKey    string
+	Admins                []string
+}
+
+type SessionConfig struct {
+	Secret     string
+	CookieName string
+	TTL        time.Duration
+	Secure     bool
+	Domain     string
+	SameSite   string
+}
+
+type OIDCConfig struct {
+	Enabled          bool
+	Name             string
+	IssuerURL        string
+	ClientID         string
+	ClientSecret     string
+	RedirectURL      string
+	Scopes           []string
+	GitHubLoginClaim string
+	MatchEmail       bool
+}
+
+type RateLimitConfig struct {
+	// BackgroundFraction is the share of each token's hourly budget that
+	// polling and discovery may use; the rest is kept for webhooks and users.
+	BackgroundFraction float64
+}
+
+type DiscoveryRuleConfig struct {
+	Connection      string   `mapstructure:"connection"`
+	Organization    string   `mapstructure:"organization"`
+	Include         []string `mapstructure:"include"`
+	Exclude         []string `mapstructure:"exclude"`
+	Topics          []string `mapstructure:"topics"`
+	ExcludeTopics   []string `mapstructure:"exclude_topics"`
+	Visibility      string   `mapstructure:"visibility"`
+	IncludeArchived bool     `mapstructure:"include_archived"`
+	IncludeForks    bool     `mapstructure:"include_forks"`
+}
+
+type DiscoveryConfig struct {
+	Interval time.Duration
+	Rules    []DiscoveryRuleConfig
+}
+
+type ActionsConfig struct {
+	// Deprecated adds to the built-in list of deprecated actions, written as
+	// owner/repo@version, or owner/repo for every version.
+	Deprecated []string
+}
+
+type ReconciliationConfig struct {
+	Interval time.Duration
+	// Window is how far back runs are compared with GitHub.
+	Window time.Duration
+	// StuckAfter is how long a queued or in-progress run may go without
+	// updates before it is fetched again.
+	StuckAfter time.Duration
+	// Grace skips runs updated more recently than this, whose webhooks may
+	// still be on their way.
+	Grace time.Duration
+}
+
 type Config struct {
-	ServerPort            string
-	LogLevel              string
-	GitHub                GitHubConfig
-	Database              DatabaseConfig
-	PollingWorkerPoolSize int
-	WebhookWorkerPoolSize int
+	ServerPort string
+	// TrustedProxies lists the addresses or CIDRs of reverse proxies whose
+	// X-Forwarded-For headers are trusted for the client IP; empty trusts
+	// none.
+	TrustedProxies         []string
+	LogLevel               string
+	GitHub                 GitHubConfig
+	Connections            []ConnectionConfig
+	Database               DatabaseConfig
+	Tracing                TracingConfig
+	Badges                 BadgesConfig
+	Auth                   AuthConfig
+	Session                SessionConfig
+	OIDC                   OIDCConfig
+	Discovery              DiscoveryConfig
+	RateLimit              RateLimitConfig
+	Reconciliation         ReconciliationConfig
+	Actions                ActionsConfig
+	PollingInterval        time.Duration
+	PollingDormantInterval time.Duration
+	PollingWorkerPoolSize  int
+	WebhookWorkerPoolSize  int
 }
 
 func LoadConfig() *Config {
@@ -39,22 +166,104 @@ func LoadConfig() *Config {
 		log.Fatalf("Error reading config file: %v", err)
 	}
 
+	var connections []ConnectionConfig
+	if err := viper.UnmarshalKey("connections", &connections); err != nil {
+		log.Fatalf("Error reading connections: %v", err)
+	}
+
+	var discoveryRules []DiscoveryRuleConfig
+	if err := viper.UnmarshalKey("discovery.rules", &discoveryRules); err != nil {
+		log.Fatalf("Error reading discovery rules: %v", err)
+	}
+
 	return &Config{
-		ServerPort:            viper.GetString("server.port"),
-		LogLevel:              viper.GetString("log.level"),
-		PollingWorkerPoolSize: viper.GetInt("polling_worker_pool_size"),
-		WebhookWorkerPoolSize: viper.GetInt("webhook_worker_pool_size"),
+		ServerPort:             viper.GetString("server.port"),
+		TrustedProxies:         viper.GetStringSlice("server.trusted_proxies"),
+		LogLevel:               viper.GetString("log.level"),
+		PollingInterval:        viper.GetDuration("polling_interval"),
+		PollingDormantInterval: viper.GetDuration("polling_dormant_interval"),
+		PollingWorkerPoolSize:  viper.GetInt("polling_worker_pool_size"),
+		WebhookWorkerPoolSize:  viper.GetInt("webhook_worker_pool_size"),
 		GitHub: GitHubConfig{
 			ClientID:      viper.GetString("github.client_id"),
 			ClientSecret:  viper.GetString("github.client_secret"),
 			AccessToken:   viper.GetString("github.access_token"),
 			WebhookSecret: viper.GetString("github.webhook_secret"),
+			HTTP: GitHubHTTPConfig{
+				Timeout:          viper.GetDuration("github.http.timeout"),
+				MaxRetries:       viper.GetInt("github.http.max_retries"),
+				BreakerThreshold: viper.GetInt("github.http.breaker_threshold"),
+				BreakerCooldown:  viper.GetDuration("github.http.breaker_cooldown"),
+			},
 		},
+		Connections: connections,
 		Database: DatabaseConfig{
 			Host:     viper.GetString("database.host"),
 			Port:     viper.GetString("database.port"),
 			User:     viper.GetString("database.user"),
 			Password: viper.GetString("database.password"),
 		},
+		Tracing: TracingConfig{
+			Enabled:     viper.GetBool("tracing.enabled"),
+			Endpoint:    viper.GetString("tracing.endpoint"),
+			Insecure:    viper.GetBool("tracing.insecure"),
+			Headers:     viper.GetStringMapString("tracing.headers"),
+			ServiceName: viper.GetString("tracing.service_name"),
+		},
+		Badges: BadgesConfig{
+			Secret:      viper.GetString("badges.secret"),
+			CacheMaxAge: viper.GetInt("badges.cache_max_age"),
+		},
+		Auth: AuthConfig{
+			AccessRefreshInterval: viper.GetDuration("auth.access_refresh_interval"),
+			TokenEncryptionKey:    viper.GetString("auth.token_encryption_key"),
+			Admins:                viper.GetStringSlice("auth.admins"),
+		},
+		Session: SessionConfig{
+			Secret:     viper.GetString("session.secret"),
+			CookieName: viper.GetString("session.cookie_name"),
+			TTL:        viper.GetDuration("session.ttl"),
+			Secure:     viper.GetBool("session.secure"),
+			Domain:     viper.GetString("session.domain"),
+			SameSite:   viper.GetString("session.same_site"),
+		},
+		OIDC: OIDCConfig{
+			Enabled:          viper.GetBool("oidc.enabled"),
+			Name:             viper.GetString("oidc.name"),
+			IssuerURL:        viper.GetString("oidc.issuer_url"),
+			ClientID:         viper.GetString("oidc.client_id"),
+			ClientSecret:     viper.GetString("oidc.client_secret"),
+			RedirectURL:      viper.GetString("oidc.redirect_url"),
+			Scopes:           viper.GetStringSlice("oidc.scopes"),
+			GitHubLoginClaim: viper.GetString("oidc.github_login_claim"),
+			MatchEmail:       viper.GetBool("oidc.match_email"),
+		},
+		Discovery: DiscoveryConfig{
+			Interval: viper.GetDuration("discovery.interval"),
+			Rules:    discoveryRules,
+		},
+		RateLimit: RateLimitConfig{
+			BackgroundFraction: viper.GetFloat64("rate_limit.background_fraction"),
+		},
+		Actions: ActionsConfig{
+			Deprecated: viper.GetStringSlice("actions.deprecated"),
+		},
+		Reconciliation: ReconciliationConfig{
+			Interval:   viper.GetDuration("reconciliation.interval"),
+			Window:     viper.GetDuration("reconciliation.window"),
+			StuckAfter: viper.GetDuration("reconciliation.stuck_after"),
+			Grace:      viper.GetDuration("reconciliation.grace"),
+		},
 	}
 }
+
+// GitHubConnections returns every configured GitHub connection, starting
+// with the default connection from the github section.
+func (c *Config) GitHubConnections() []ConnectionConfig {
+	connections := []ConnectionConfig{{
+		Name:          DefaultConnection,
+		AccessToken:   c.GitHub.AccessToken,
+		WebhookSecret: c.GitHub.WebhookSecret,
+	}}
+	return append(connections, c.Connections...)
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/go-github/v50/github"
 	"github.com/moosh3/github-actions-aggregator/pkg/config"
@@ -24,13 +26,202 @@ func InitDB(cfg *config.Config) (*Database, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := Migrate(conn); err != nil {
+		return nil, err
+	}
+
+	return &Database{Conn: conn}, nil
+}
+
+// Migrate brings the schema up to date, cleaning up and backfilling rows
+// saved by earlier versions.
+func Migrate(conn *gorm.DB) error {
+	if err := cleanUpWorkflowRuns(conn); err != nil {
+		return fmt.Errorf("failed to clean up workflow runs: %w", err)
+	}
+
+	// Workflows, runs and jobs were keyed by GitHub ID alone before their
+	// connection was recorded
+	recordConnections := conn.Migrator().HasTable(&models.WorkflowRun{}) &&
+		!conn.Migrator().HasColumn(&models.WorkflowRun{}, "connection")
+
 	// Auto-migrate the schema
-	err = conn.AutoMigrate(&models.Repository{}, &models.WorkflowRun{}, &models.WorkflowStatistics{}, &models.JobStatistics{})
+	err := conn.AutoMigrate(
+		&models.Repository{},
+		&models.Workflow{},
+		&models.WorkflowRun{},
+		&models.PullRequest{},
+		&models.Job{},
+		&models.TaskStep{},
+		&models.WorkflowDefinition{},
+		&models.WorkflowDefinitionCommit{},
+		&models.WorkflowStatistics{},
+		&models.JobStatistics{},
+		&models.RepositoryAccess{},
+		&models.GitHubUser{},
+		&models.Session{},
+		&models.APIToken{},
+		&models.OrganizationMembership{},
+		&models.TeamMembership{},
+		&models.UserToken{},
+		&models.RoleBinding{},
+		&models.IdentityLink{},
+		&models.AuditEvent{},
+		&models.DiscoveryRule{},
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to auto-migrate schema: %w", err)
+		return fmt.Errorf("failed to auto-migrate schema: %w", err)
 	}
 
-	return &Database{Conn: conn}, nil
+	if recordConnections {
+		if err := backfillConnections(conn); err != nil {
+			return fmt.Errorf("failed to backfill connections: %w", err)
+		}
+	}
+
+	if err := dropReplacedIndexes(conn); err != nil {
+		return fmt.Errorf("failed to drop replaced indexes: %w", err)
+	}
+
+	if err := backfillRepositoryOwners(conn); err != nil {
+		return fmt.Errorf("failed to backfill repository owners: %w", err)
+	}
+
+	if err := backfillDefinitionCommits(conn); err != nil {
+		return fmt.Errorf("failed to backfill workflow definition commits: %w", err)
+	}
+
+	if err := protectAuditLog(conn); err != nil {
+		return fmt.Errorf("failed to protect audit log: %w", err)
+	}
+
+	return nil
+}
+
+// cleanUpWorkflowRuns prepares workflow runs saved by earlier versions for
+// the unique index on (connection, run_id, run_attempt): runs used to be
+// saved without their run ID, and attempts could be saved more than once.
+// Rows without a run ID can't be matched to GitHub again and are removed, as
+// are all but the newest copy of each attempt; polling saves them again.
+func cleanUpWorkflowRuns(conn *gorm.DB) error {
+	if !conn.Migrator().HasTable(&models.WorkflowRun{}) {
+		return nil
+	}
+	if !conn.Migrator().HasColumn(&models.WorkflowRun{}, "run_id") {
+		// The table predates run IDs, so none of its rows have one
+		return conn.Exec(`DELETE FROM workflow_runs`).Error
+	}
+	sameAttempt := `newer.run_id = workflow_runs.run_id AND newer.run_attempt = workflow_runs.run_attempt`
+	if conn.Migrator().HasColumn(&models.WorkflowRun{}, "connection") {
+		sameAttempt += ` AND newer.connection = workflow_runs.connection`
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`DELETE FROM workflow_runs WHERE run_id = 0 OR run_id IS NULL`,
+			`DELETE FROM workflow_runs USING workflow_runs AS newer
+			WHERE ` + sameAttempt + ` AND newer.id > workflow_runs.id`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// backfillConnections sets the connection of workflows, runs, jobs, pull
+// requests and workflow definitions saved before it was recorded to the
+// connection of their repository. They were saved with the default
+// connection.
+func backfillConnections(conn *gorm.DB) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`UPDATE workflows SET connection = repositories.connection FROM repositories
+			WHERE repositories.id = workflows.repository_id`,
+			`UPDATE workflow_runs SET connection = repositories.connection FROM repositories
+			WHERE repositories.repo_id = workflow_runs.repository_id`,
+			`UPDATE jobs SET connection = workflow_runs.connection FROM workflow_runs
+			WHERE workflow_runs.run_id = jobs.run_id`,
+			`UPDATE pull_requests SET connection = repositories.connection FROM repositories
+			WHERE repositories.repo_id = pull_requests.repository_id`,
+			`UPDATE workflow_definitions SET connection = repositories.connection FROM repositories
+			WHERE repositories.id = workflow_definitions.repository_id`,
+			`UPDATE workflow_definition_commits SET connection = workflow_definitions.connection
+			FROM workflow_definitions WHERE workflow_definitions.id = workflow_definition_commits.definition_id`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropReplacedIndexes drops the indexes on GitHub IDs alone, which the
+// indexes including the connection replace.
+func dropReplacedIndexes(conn *gorm.DB) error {
+	indexes := []string{
+		"idx_workflows_workflow_id",
+		"idx_workflow_runs_run_attempt",
+		"idx_jobs_job_id",
+		"idx_pull_requests_repository_number",
+		"idx_workflow_definitions_workflow_content",
+		"idx_workflow_definition_commits_workflow_commit",
+		"idx_organization_membership_user_org",
+	}
+	for _, index := range indexes {
+		if err := conn.Exec("DROP INDEX IF EXISTS " + index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillRepositoryOwners sets the owner of repositories saved before
+// OwnerName was added from their full names.
+func backfillRepositoryOwners(conn *gorm.DB) error {
+	return conn.Exec(`UPDATE repositories SET owner_name = split_part(full_name, '/', 1)
+		WHERE owner_name IS NULL OR owner_name = ''`).Error
+}
+
+// backfillDefinitionCommits sets when commits recorded before SeenAt was
+// added were seen: at their earliest stored run, or else when their version
+// was first seen.
+func backfillDefinitionCommits(conn *gorm.DB) error {
+	return conn.Exec(`UPDATE workflow_definition_commits SET seen_at = COALESCE(
+		(SELECT MIN(workflow_runs.created_at) FROM workflow_runs
+		WHERE workflow_runs.connection = workflow_definition_commits.connection
+		AND workflow_runs.workflow_id = workflow_definition_commits.workflow_id
+		AND workflow_runs.head_sha = workflow_definition_commits.commit_sha),
+		(SELECT workflow_definitions.first_seen_at FROM workflow_definitions
+		WHERE workflow_definitions.id = workflow_definition_commits.definition_id))
+	WHERE seen_at IS NULL`).Error
+}
+
+// protectAuditLog makes the database reject updates and deletes of audit
+// events, in addition to the model hooks, so the audit log stays append-only
+// for every client.
+func protectAuditLog(conn *gorm.DB) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+			`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+			FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (db *Database) GetRepository() (models.Repository, error) {
@@ -47,6 +238,7 @@ func (db *Database) GetRepositories() ([]models.Repository, error) {
 
 func (db *Database) SaveRepository(repo *models.Repository) error {
 	repository := models.Repository{
+		RepoID:      repo.RepoID,
 		Name:        repo.Name,
 		Owner:       repo.Owner,
 		FullName:    repo.FullName,
@@ -63,9 +255,152 @@ func (db *Database) SaveRepository(repo *models.Repository) error {
 		HasProjects: repo.HasProjects,
 		HasWiki:     repo.HasWiki,
 	}
+	repository.OwnerName = repository.OwnerLogin()
 	return db.Conn.Create(repository).Error
 }
 
+// SaveGitHubRepository creates or updates a repository from the GitHub API
+// of a connection, keeping its monitoring settings.
+func (db *Database) SaveGitHubRepository(connection string, repo *github.Repository) (*models.Repository, error) {
+	repository := models.Repository{
+		Connection:  connection,
+		RepoID:      repo.GetID(),
+		Name:        repo.GetName(),
+		FullName:    repo.GetFullName(),
+		Description: repo.GetDescription(),
+		Private:     repo.GetPrivate(),
+		Fork:        repo.GetFork(),
+		CreatedAt:   repo.GetCreatedAt().Time,
+		UpdatedAt:   repo.GetUpdatedAt().Time,
+		PushedAt:    repo.GetPushedAt().Time,
+		Size:        repo.GetSize(),
+		StarCount:   repo.GetStargazersCount(),
+		Language:    repo.GetLanguage(),
+		HasIssues:   repo.GetHasIssues(),
+		HasProjects: repo.GetHasProjects(),
+		HasWiki:     repo.GetHasWiki(),
+	}
+	repository.OwnerName = repository.OwnerLogin()
+
+	err := db.Conn.Omit("Owner").Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "connection"}, {Name: "full_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"repo_id", "name", "owner_name", "description", "private", "fork", "updated_at", "pushed_at",
+			"size", "star_count", "language", "has_issues", "has_projects", "has_wiki",
+		}),
+	}).Create(&repository).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload to pick up the ID and settings of an existing row
+	err = db.Conn.Where("connection = ? AND full_name = ?", connection, repository.FullName).First(&repository).Error
+	return &repository, err
+}
+
+// GetDueRepositories returns the monitored repositories whose next poll is
+// due at the given time, including ones that were never polled.
+func (db *Database) GetDueRepositories(now time.Time) ([]models.Repository, error) {
+	var repos []models.Repository
+	err := db.Conn.Where("monitor = ? AND (next_poll_at IS NULL OR next_poll_at <= ?)", true, now).
+		Order("next_poll_at NULLS FIRST").Find(&repos).Error
+	return repos, err
+}
+
+// ScheduleRepositoryPoll records when a repository was polled and when it is
+// polled next.
+func (db *Database) ScheduleRepositoryPoll(id uint, polledAt, next time.Time) error {
+	return db.Conn.Model(&models.Repository{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"last_polled_at": polledAt,
+		"next_poll_at":   next,
+	}).Error
+}
+
+// GetRepositoryActivity returns when a run of a repository, by its
+// connection and GitHub ID, was last updated, and whether any run with one
+// of the unfinished statuses was updated since the given time.
+func (db *Database) GetRepositoryActivity(connection string, repoID int64, unfinishedStatuses []string, since time.Time) (time.Time, bool, error) {
+	var activity struct {
+		LastRun    *time.Time
+		Unfinished int64
+	}
+	err := db.Conn.Model(&models.WorkflowRun{}).
+		Select("MAX(updated_at) AS last_run, COUNT(CASE WHEN status IN ? AND updated_at >= ? THEN 1 END) AS unfinished", unfinishedStatuses, since).
+		Where("connection = ? AND repository_id = ?", connection, repoID).
+		Scan(&activity).Error
+	if err != nil || activity.LastRun == nil {
+		return time.Time{}, false, err
+	}
+	return *activity.LastRun, activity.Unfinished > 0, nil
+}
+
+// MonitorDiscoveredRepository saves a repository found by discovery and
+// monitors it, unless a user has changed its monitoring.
+func (db *Database) MonitorDiscoveredRepository(connection string, repo *github.Repository) (*models.Repository, error) {
+	repository, err := db.SaveGitHubRepository(connection, repo)
+	if err != nil {
+		return nil, err
+	}
+	if repository.Monitor || repository.MonitorSource == models.MonitorSourceManual {
+		return repository, nil
+	}
+
+	repository.Monitor = true
+	repository.MonitorSource = models.MonitorSourceDiscovery
+	err = db.Conn.Model(repository).Updates(map[string]interface{}{
+		"monitor":        true,
+		"monitor_source": models.MonitorSourceDiscovery,
+	}).Error
+	return repository, err
+}
+
+// UpdateRepositoryPushedAt records a push to a repository, by its GitHub ID
+// on a connection, keeping a later push already recorded.
+func (db *Database) UpdateRepositoryPushedAt(connection string, repoID int64, pushedAt time.Time) error {
+	return db.Conn.Model(&models.Repository{}).
+		Where("connection = ? AND repo_id = ? AND pushed_at < ?", connection, repoID, pushedAt).
+		UpdateColumn("pushed_at", pushedAt).Error
+}
+
+// GetRepositoryByGitHubID returns the stored repository with the given
+// GitHub ID on a connection.
+func (db *Database) GetRepositoryByGitHubID(connection string, repoID int64) (models.Repository, error) {
+	var repo models.Repository
+	err := db.Conn.Where("connection = ? AND repo_id = ?", connection, repoID).First(&repo).Error
+	return repo, err
+}
+
+// GetConnectionRepositories returns the stored repositories of a
+// connection, monitored or not.
+func (db *Database) GetConnectionRepositories(connection string) ([]models.Repository, error) {
+	var repos []models.Repository
+	err := db.Conn.Where("connection = ?", connection).Find(&repos).Error
+	return repos, err
+}
+
+// GetDiscoveredRepositories returns the repositories monitored by discovery
+// on every connection.
+func (db *Database) GetDiscoveredRepositories() ([]models.Repository, error) {
+	var repos []models.Repository
+	err := db.Conn.Where("monitor = ? AND monitor_source = ?", true, models.MonitorSourceDiscovery).
+		Find(&repos).Error
+	return repos, err
+}
+
+// UnmonitorDiscoveredRepositories stops monitoring the repositories of an
+// organization on a connection that were monitored by discovery, except
+// those in keep. It returns the number of repositories no longer monitored.
+func (db *Database) UnmonitorDiscoveredRepositories(connection, org string, keep []uint) (int64, error) {
+	query := db.Conn.Model(&models.Repository{}).
+		Where("connection = ? AND LOWER(owner_name) = LOWER(?)", connection, org).
+		Where("monitor = ? AND monitor_source = ?", true, models.MonitorSourceDiscovery)
+	if len(keep) > 0 {
+		query = query.Where("id NOT IN ?", keep)
+	}
+	result := query.Update("monitor", false)
+	return result.RowsAffected, result.Error
+}
+
 func (db *Database) DeleteRepository(id int) error {
 	return db.Conn.Delete(&models.Repository{}, id).Error
 }
@@ -82,8 +417,9 @@ func (db *Database) GetWorkflows() ([]models.Workflow, error) {
 	return workflows, err
 }
 
-func (db *Database) SaveWorkflow(workflow *github.Workflow) error {
+func (db *Database) SaveWorkflow(connection string, workflow *github.Workflow) error {
 	workflowModel := models.Workflow{
+		Connection: connection,
 		WorkflowID: workflow.GetID(),
 		NodeID:     workflow.GetNodeID(),
 		Name:       workflow.GetName(),
@@ -95,6 +431,101 @@ func (db *Database) SaveWorkflow(workflow *github.Workflow) error {
 	return db.Conn.Create(workflowModel).Error
 }
 
+// SaveRepositoryWorkflow creates or updates a workflow of a repository.
+func (db *Database) SaveRepositoryWorkflow(repository models.Repository, workflow *github.Workflow) error {
+	workflowModel := models.Workflow{
+		Connection:   repository.Connection,
+		WorkflowID:   workflow.GetID(),
+		NodeID:       workflow.GetNodeID(),
+		Name:         workflow.GetName(),
+		Path:         workflow.GetPath(),
+		State:        workflow.GetState(),
+		CreatedAt:    workflow.GetCreatedAt().Time,
+		UpdatedAt:    workflow.GetUpdatedAt().Time,
+		URL:          workflow.GetURL(),
+		HTMLURL:      workflow.GetHTMLURL(),
+		BadgeURL:     workflow.GetBadgeURL(),
+		RepositoryID: repository.ID,
+	}
+
+	var existing models.Workflow
+	err := db.Conn.Where("connection = ? AND workflow_id = ?", workflowModel.Connection, workflowModel.WorkflowID).
+		Limit(1).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	workflowModel.ID = existing.ID
+	return db.Conn.Omit("Repository").Save(&workflowModel).Error
+}
+
+// GetRepositoryWorkflows returns the stored workflows of a repository.
+func (db *Database) GetRepositoryWorkflows(repositoryID uint) ([]models.Workflow, error) {
+	var workflows []models.Workflow
+	err := db.Conn.Where("repository_id = ?", repositoryID).Find(&workflows).Error
+	return workflows, err
+}
+
+// GetWorkflowDefinitionCommits returns which of the commits already have a
+// stored version of the workflow's file.
+func (db *Database) GetWorkflowDefinitionCommits(connection string, workflowID int64, commitSHAs []string) (map[string]bool, error) {
+	var stored []string
+	err := db.Conn.Model(&models.WorkflowDefinitionCommit{}).
+		Where("connection = ? AND workflow_id = ? AND commit_sha IN ?", connection, workflowID, commitSHAs).
+		Pluck("commit_sha", &stored).Error
+	if err != nil {
+		return nil, err
+	}
+	commits := make(map[string]bool, len(stored))
+	for _, sha := range stored {
+		commits[sha] = true
+	}
+	return commits, nil
+}
+
+// SaveWorkflowDefinition stores a version of a workflow file seen at a
+// commit, given as the definition's CommitSHA and FirstSeenAt. A version
+// already stored is kept, moving its first commit earlier if the given one
+// was seen before, and the commit is recorded with it.
+func (db *Database) SaveWorkflowDefinition(definition *models.WorkflowDefinition) error {
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		var existing models.WorkflowDefinition
+		err := tx.Where("connection = ? AND workflow_id = ? AND content_sha = ?",
+			definition.Connection, definition.WorkflowID, definition.ContentSHA).
+			Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		commitSHA, seenAt := definition.CommitSHA, definition.FirstSeenAt
+
+		if existing.ID == 0 {
+			if err := tx.Create(definition).Error; err != nil {
+				return err
+			}
+		} else {
+			if definition.FirstSeenAt.Before(existing.FirstSeenAt) {
+				existing.CommitSHA = definition.CommitSHA
+				existing.FirstSeenAt = definition.FirstSeenAt
+				err := tx.Model(&existing).Updates(map[string]interface{}{
+					"commit_sha":    existing.CommitSHA,
+					"first_seen_at": existing.FirstSeenAt,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			*definition = existing
+		}
+
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WorkflowDefinitionCommit{
+			WorkflowID:   definition.WorkflowID,
+			Connection:   definition.Connection,
+			CommitSHA:    commitSHA,
+			DefinitionID: definition.ID,
+			SeenAt:       seenAt,
+		}).Error
+	})
+}
+
 func (db *Database) DeleteWorkflow(id int) error {
 	return db.Conn.Delete(&models.Workflow{}, id).Error
 }
@@ -111,23 +542,222 @@ func (db *Database) GetWorkflowRuns(repoID int) ([]models.WorkflowRun, error) {
 	return runs, err
 }
 
-func (db *Database) SaveWorkflowRun(run *github.WorkflowRun) error {
+// SaveWorkflowRun saves an attempt of a run reported by a connection, keyed
+// by connection, run ID and attempt. triggeringActor is the user who started
+// the attempt; when it is empty the stored one is kept, or for first
+// attempts the run's actor is used.
+func (db *Database) SaveWorkflowRun(connection string, run *github.WorkflowRun, triggeringActor string) error {
 	workflowRun := models.WorkflowRun{
+		Connection:   connection,
+		RunID:        run.GetID(),
 		WorkflowID:   run.GetWorkflowID(),
 		RepositoryID: run.GetRepository().GetID(),
+		Name:         run.GetName(),
+		HeadBranch:   run.GetHeadBranch(),
+		HeadSHA:      run.GetHeadSHA(),
 		Status:       run.GetStatus(),
 		Conclusion:   run.GetConclusion(),
 		RunNumber:    run.GetRunNumber(),
+		RunAttempt:   run.GetRunAttempt(),
 		Event:        run.GetEvent(),
+		URL:          run.GetURL(),
+		HTMLURL:      run.GetHTMLURL(),
+		Actor:        run.GetActor().GetLogin(),
 		CreatedAt:    run.GetCreatedAt().Time,
 		UpdatedAt:    run.GetUpdatedAt().Time,
 	}
+	if run.RunStartedAt != nil {
+		startedAt := run.GetRunStartedAt().Time
+		workflowRun.RunStartedAt = &startedAt
+	}
+	if workflowRun.RunAttempt == 0 {
+		workflowRun.RunAttempt = 1
+	}
+
+	// Upsert by GitHub run ID and attempt, adopting rows saved before
+	// attempts were recorded
+	var existing models.WorkflowRun
+	err := db.Conn.Where("connection = ? AND run_id = ? AND run_attempt IN ?",
+		connection, workflowRun.RunID, []int{workflowRun.RunAttempt, 0}).
+		Order("run_attempt DESC").Limit(1).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	workflowRun.ID = existing.ID
+	workflowRun.RerunFailedJobs = existing.RerunFailedJobs
+	workflowRun.TriggeringActor = triggeringActor
+	if workflowRun.TriggeringActor == "" {
+		workflowRun.TriggeringActor = existing.TriggeringActor
+	}
+	if workflowRun.TriggeringActor == "" && workflowRun.RunAttempt == 1 {
+		workflowRun.TriggeringActor = workflowRun.Actor
+	}
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("HeadRepository", "PullRequests").Save(&workflowRun).Error; err != nil {
+			return err
+		}
+		return linkPullRequests(tx, &workflowRun, run.PullRequests)
+	})
+}
+
+// linkPullRequests associates an attempt of a run with the pull requests of
+// its payload, creating those not stored yet. Details of stored pull
+// requests are left alone, since runs may be saved out of order.
+func linkPullRequests(tx *gorm.DB, workflowRun *models.WorkflowRun, pulls []*github.PullRequest) error {
+	if len(pulls) == 0 {
+		return nil
+	}
+
+	linked := make([]models.PullRequest, 0, len(pulls))
+	for _, pr := range pulls {
+		repoID := pr.GetBase().GetRepo().GetID()
+		if repoID == 0 {
+			repoID = workflowRun.RepositoryID
+		}
+		pullRequest := models.PullRequest{
+			Connection:   workflowRun.Connection,
+			RepositoryID: repoID,
+			Number:       pr.GetNumber(),
+			URL:          pr.GetURL(),
+			HeadBranch:   pr.GetHead().GetRef(),
+			HeadSHA:      pr.GetHead().GetSHA(),
+			BaseBranch:   pr.GetBase().GetRef(),
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "connection"}, {Name: "repository_id"}, {Name: "number"}},
+			DoNothing: true,
+		}).Omit("WorkflowRuns").Create(&pullRequest).Error
+		if err != nil {
+			return err
+		}
+		if pullRequest.ID == 0 {
+			err := tx.Where("connection = ? AND repository_id = ? AND number = ?", workflowRun.Connection, repoID, pr.GetNumber()).
+				First(&pullRequest).Error
+			if err != nil {
+				return err
+			}
+		}
+		linked = append(linked, pullRequest)
+	}
+	return tx.Model(workflowRun).Omit("PullRequests.*").Association("PullRequests").Append(linked)
+}
+
+// SavePullRequest saves the details of a pull request of a repository, by
+// its connection and GitHub ID.
+func (db *Database) SavePullRequest(connection string, repoID int64, pr *github.PullRequest) error {
+	pullRequest := models.PullRequest{
+		Connection:   connection,
+		RepositoryID: repoID,
+		Number:       pr.GetNumber(),
+		URL:          pr.GetURL(),
+		HTMLURL:      pr.GetHTMLURL(),
+		Title:        pr.GetTitle(),
+		Author:       pr.GetUser().GetLogin(),
+		State:        pr.GetState(),
+		Draft:        pr.GetDraft(),
+		HeadBranch:   pr.GetHead().GetRef(),
+		HeadSHA:      pr.GetHead().GetSHA(),
+		BaseBranch:   pr.GetBase().GetRef(),
+	}
+	if pr.CreatedAt != nil {
+		openedAt := pr.GetCreatedAt().Time
+		pullRequest.OpenedAt = &openedAt
+	}
+	if pr.ClosedAt != nil {
+		closedAt := pr.GetClosedAt().Time
+		pullRequest.ClosedAt = &closedAt
+	}
+	if pr.MergedAt != nil {
+		mergedAt := pr.GetMergedAt().Time
+		pullRequest.MergedAt = &mergedAt
+	}
 
-	// Upsert operation
 	return db.Conn.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "id"}},
-		UpdateAll: true,
-	}).Create(&workflowRun).Error
+		Columns: []clause.Column{{Name: "connection"}, {Name: "repository_id"}, {Name: "number"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"url", "html_url", "title", "author", "state", "draft", "head_branch", "head_sha",
+			"base_branch", "opened_at", "closed_at", "merged_at", "updated_at",
+		}),
+	}).Omit("WorkflowRuns").Create(&pullRequest).Error
+}
+
+// HasPullRequestDetails reports whether the details of a pull request, such
+// as when it was opened, are stored, as opposed to only its number.
+func (db *Database) HasPullRequestDetails(connection string, repoID int64, number int) (bool, error) {
+	var count int64
+	err := db.Conn.Model(&models.PullRequest{}).
+		Where("connection = ? AND repository_id = ? AND number = ? AND opened_at IS NOT NULL", connection, repoID, number).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// IsWorkflowRunCurrent reports whether the run's attempt is stored with the
+// same status and update time as the given one.
+func (db *Database) IsWorkflowRunCurrent(connection string, run *github.WorkflowRun) (bool, error) {
+	var count int64
+	err := db.Conn.Model(&models.WorkflowRun{}).
+		Where("connection = ? AND run_id = ? AND run_attempt = ? AND status = ? AND updated_at = ?",
+			connection, run.GetID(), run.GetRunAttempt(), run.GetStatus(), run.GetUpdatedAt().Time).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetWorkflowRunAttempts returns the attempt numbers stored for a run.
+func (db *Database) GetWorkflowRunAttempts(connection string, runID int64) ([]int, error) {
+	var attempts []int
+	err := db.Conn.Model(&models.WorkflowRun{}).Where("connection = ? AND run_id = ?", connection, runID).
+		Pluck("run_attempt", &attempts).Error
+	return attempts, err
+}
+
+// MarkRerunFailedJobs records whether an attempt of a run re-ran only the
+// failed jobs, judging by the stored jobs of every attempt up to it: a job
+// that succeeded in any earlier attempt is kept rather than run again.
+func (db *Database) MarkRerunFailedJobs(connection string, runID int64, attempt int) error {
+	if attempt <= 1 {
+		return nil
+	}
+	var jobs []models.Job
+	err := db.Conn.Select("name", "conclusion", "run_attempt").
+		Where("connection = ? AND run_id = ? AND run_attempt <= ?", connection, runID, attempt).Find(&jobs).Error
+	if err != nil {
+		return err
+	}
+
+	var previous, current []models.Job
+	for _, job := range jobs {
+		if job.RunAttempt == attempt {
+			current = append(current, job)
+		} else {
+			previous = append(previous, job)
+		}
+	}
+	if len(current) == 0 {
+		return nil
+	}
+	return db.Conn.Model(&models.WorkflowRun{}).
+		Where("connection = ? AND run_id = ? AND run_attempt = ?", connection, runID, attempt).
+		UpdateColumn("rerun_failed_jobs", models.FailedJobsOnly(previous, current)).Error
+}
+
+// GetRecentWorkflowRuns returns the latest attempt of the stored runs of a
+// repository, by its connection and GitHub ID, created since the given time.
+func (db *Database) GetRecentWorkflowRuns(connection string, repoID int64, since time.Time) ([]models.WorkflowRun, error) {
+	var runs []models.WorkflowRun
+	err := db.Conn.Scopes(models.LatestAttempts).
+		Where("connection = ? AND repository_id = ? AND created_at >= ?", connection, repoID, since).Find(&runs).Error
+	return runs, err
+}
+
+// GetUnfinishedWorkflowRuns returns the latest attempt of the stored runs of
+// a repository, by its connection and GitHub ID, that have one of the
+// statuses and were last updated before the given time.
+func (db *Database) GetUnfinishedWorkflowRuns(connection string, repoID int64, statuses []string, before time.Time) ([]models.WorkflowRun, error) {
+	var runs []models.WorkflowRun
+	err := db.Conn.Scopes(models.LatestAttempts).
+		Where("connection = ? AND repository_id = ? AND status IN ? AND updated_at < ?", connection, repoID, statuses, before).
+		Find(&runs).Error
+	return runs, err
 }
 
 func (db *Database) DeleteWorkflowRun(id int) error {
@@ -140,14 +770,19 @@ func (db *Database) GetWorkflowJob(workflowID int) (*models.Job, error) {
 	return &job, err
 }
 
-func (db *Database) GetWorkflowJobs(workflowID int) ([]models.Job, error) {
+// GetWorkflowJobs returns the stored jobs of a workflow, by its connection
+// and GitHub ID.
+func (db *Database) GetWorkflowJobs(connection string, workflowID int64) ([]models.Job, error) {
 	var jobs []models.Job
-	err := db.Conn.Where("workflow_id = ?", workflowID).Find(&jobs).Error
+	err := db.Conn.Where("connection = ? AND workflow_id = ?", connection, workflowID).Find(&jobs).Error
 	return jobs, err
 }
 
-func (db *Database) SaveWorkflowJob(job *github.WorkflowJob) error {
+// SaveWorkflowJob saves a job reported by a connection and its steps, keyed
+// by connection and job ID.
+func (db *Database) SaveWorkflowJob(connection string, job *github.WorkflowJob) error {
 	jobModel := models.Job{
+		Connection:      connection,
 		JobID:           job.GetID(),
 		RunID:           job.GetRunID(),
 		RunURL:          job.GetRunURL(),
@@ -158,9 +793,9 @@ func (db *Database) SaveWorkflowJob(job *github.WorkflowJob) error {
 		Status:          job.GetStatus(),
 		Conclusion:      job.GetConclusion(),
 		CreatedAt:       job.GetCreatedAt().Time,
+		StartedAt:       job.GetStartedAt().Time,
 		CompletedAt:     job.GetCompletedAt().Time,
 		Name:            job.GetName(),
-		Steps:           []models.TaskStep{},
 		CheckRunURL:     job.GetCheckRunURL(),
 		Labels:          job.Labels,
 		RunnerID:        job.GetRunnerID(),
@@ -170,7 +805,50 @@ func (db *Database) SaveWorkflowJob(job *github.WorkflowJob) error {
 		RunAttempt:      int(job.GetRunAttempt()),
 		WorkflowName:    job.GetWorkflowName(),
 	}
-	return db.Conn.Create(jobModel).Error
+
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		// Upsert by GitHub job ID, taking the workflow from the job's run
+		var existing models.Job
+		if err := tx.Where("connection = ? AND job_id = ?", connection, jobModel.JobID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		jobModel.ID = existing.ID
+		jobModel.WorkflowID = existing.WorkflowID
+
+		var run models.WorkflowRun
+		err := tx.Select("workflow_id").Where("connection = ? AND run_id = ?", connection, jobModel.RunID).
+			Limit(1).Find(&run).Error
+		if err != nil {
+			return err
+		}
+		if run.WorkflowID != 0 {
+			jobModel.WorkflowID = run.WorkflowID
+		}
+
+		if err := tx.Omit("Steps").Save(&jobModel).Error; err != nil {
+			return err
+		}
+		if len(job.Steps) == 0 {
+			return nil
+		}
+
+		steps := make([]models.TaskStep, 0, len(job.Steps))
+		for _, step := range job.Steps {
+			steps = append(steps, models.TaskStep{
+				JobID:       jobModel.ID,
+				Number:      step.GetNumber(),
+				Name:        step.GetName(),
+				Status:      step.GetStatus(),
+				Conclusion:  step.GetConclusion(),
+				StartedAt:   step.GetStartedAt().Time,
+				CompletedAt: step.GetCompletedAt().Time,
+			})
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "job_id"}, {Name: "number"}},
+			DoUpdates: clause.AssignmentColumns([]string{"name", "status", "conclusion", "started_at", "completed_at", "updated_at"}),
+		}).Create(&steps).Error
+	})
 }
 
 func (db *Database) DeleteWorkflowJob(id int) error {
@@ -210,3 +888,59 @@ func (db *Database) SaveWorkflowStatistics(stats *models.WorkflowStatistics) err
 func (db *Database) DeleteWorkflowStatistics(id int) error {
 	return db.Conn.Delete(&models.WorkflowStatistics{}, id).Error
 }
+
+// GetUsersByLogin returns the users with the given github.com logins,
+// ignoring case.
+func (db *Database) GetUsersByLogin(logins []string) ([]models.GitHubUser, error) {
+	if len(logins) == 0 {
+		return nil, nil
+	}
+	lowered := make([]string, 0, len(logins))
+	for _, login := range logins {
+		lowered = append(lowered, strings.ToLower(login))
+	}
+	var users []models.GitHubUser
+	err := db.Conn.Where("type = ? AND LOWER(login) IN ?", "User", lowered).Find(&users).Error
+	return users, err
+}
+
+// ReplaceConnectionAccess replaces the stored repository permissions and
+// organization memberships of users on a connection with the ones synced
+// from it. Those of the kept repositories and organizations, which couldn't
+// be synced, are left alone.
+func (db *Database) ReplaceConnectionAccess(connection string, access []models.RepositoryAccess, memberships []models.OrganizationMembership, keptRepositories []uint, keptOrganizations []string) error {
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		repos := tx.Session(&gorm.Session{NewDB: true}).Model(&models.Repository{}).Select("id").
+			Where("connection = ?", connection)
+		if len(keptRepositories) > 0 {
+			repos = repos.Where("id NOT IN ?", keptRepositories)
+		}
+		if err := tx.Unscoped().Where("repository_id IN (?)", repos).Delete(&models.RepositoryAccess{}).Error; err != nil {
+			return err
+		}
+
+		stale := tx.Unscoped().Where("connection = ?", connection)
+		if len(keptOrganizations) > 0 {
+			lowered := make([]string, 0, len(keptOrganizations))
+			for _, org := range keptOrganizations {
+				lowered = append(lowered, strings.ToLower(org))
+			}
+			stale = stale.Where("LOWER(organization) NOT IN ?", lowered)
+		}
+		if err := stale.Delete(&models.OrganizationMembership{}).Error; err != nil {
+			return err
+		}
+
+		if len(access) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&access).Error; err != nil {
+				return err
+			}
+		}
+		if len(memberships) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&memberships).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
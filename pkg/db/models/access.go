@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RepositoryAccess caches a user's permission on a repository as reported by
+// GitHub for the user's OAuth token.
+type RepositoryAccess struct {
+	gorm.Model
+	UserID       int64  `gorm:"uniqueIndex:idx_repository_access_user_repo;not null"`
+	RepositoryID uint   `gorm:"uniqueIndex:idx_repository_access_user_repo;not null"`
+	Permission   string `gorm:"type:varchar(50);not null"`
+	SyncedAt     time.Time
+}
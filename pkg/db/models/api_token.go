@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIToken is a bearer token for non-interactive API access. Personal tokens
+// act as the user that created them; service tokens act as an organization.
+// Only a hash of the token is stored.
+type APIToken struct {
+	gorm.Model
+	Name         string `gorm:"not null"`
+	Kind         string `gorm:"type:varchar(20);not null"`
+	TokenHash    string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Prefix       string `gorm:"type:varchar(16);not null"`
+	Scopes       string `gorm:"not null"`
+	UserID       int64  `gorm:"index;not null"`
+	Organization string
+	// Connection names the GitHub instance of a service token's
+	// organization.
+	Connection string `gorm:"type:varchar(100);not null;default:'github.com'"`
+	// RepositoryIDs are the organization repositories granted to a service
+	// token. Of the private repositories, it can only read these.
+	RepositoryIDs []uint `gorm:"serializer:json"`
+	CreatedByID   int64  `gorm:"index;not null"`
+	ExpiresAt     time.Time
+	RevokedAt     *time.Time
+	LastUsedAt    *time.Time
+	LastUsedIP    string `gorm:"type:varchar(45)"`
+}
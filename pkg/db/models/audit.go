@@ -0,0 +1,38 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditLogAppendOnly is returned when an audit event is updated or
+// deleted.
+var ErrAuditLogAppendOnly = errors.New("audit log is append-only")
+
+// AuditEvent records a mutating action: who did it, to what, from where and
+// what changed. Audit events are never updated or deleted.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	CreatedAt  time.Time `gorm:"index;not null"`
+	ActorID    int64     `gorm:"index"`
+	ActorLogin string    `gorm:"index"`
+	TokenID    *uint
+	Action     string `gorm:"index;not null"`
+	TargetType string `gorm:"index;not null"`
+	TargetID   string `gorm:"index"`
+	Before     string `gorm:"type:jsonb"`
+	After      string `gorm:"type:jsonb"`
+	Diff       string `gorm:"type:jsonb"`
+	IPAddress  string `gorm:"type:varchar(45)"`
+	UserAgent  string
+}
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
+
+func (AuditEvent) BeforeDelete(*gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
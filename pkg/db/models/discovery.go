@@ -0,0 +1,22 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// DiscoveryRule selects repositories of an organization that are monitored
+// automatically. Name patterns are globs, or regular expressions when
+// prefixed with "re:".
+type DiscoveryRule struct {
+	gorm.Model
+	Connection      string   `gorm:"type:varchar(100)"`
+	Organization    string   `gorm:"index;not null"`
+	Include         []string `gorm:"serializer:json"`
+	Exclude         []string `gorm:"serializer:json"`
+	Topics          []string `gorm:"serializer:json"`
+	ExcludeTopics   []string `gorm:"serializer:json"`
+	Visibility      string   `gorm:"type:varchar(20)"`
+	IncludeArchived bool
+	IncludeForks    bool
+	CreatedByID     int64
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// IdentityLink maps an identity from an external login provider, such as
+// the company SSO, to the GitHub user it belongs to.
+type IdentityLink struct {
+	gorm.Model
+	Provider string `gorm:"uniqueIndex:idx_identity_link_provider_subject;not null"`
+	Subject  string `gorm:"uniqueIndex:idx_identity_link_provider_subject;not null"`
+	Email    string
+	UserID   int64 `gorm:"index;not null"`
+}
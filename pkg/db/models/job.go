@@ -8,9 +8,11 @@ import (
 
 type Job struct {
 	gorm.Model
-	ID              int64
-	JobID           int64
-	RunID           int64
+	JobID int64 `gorm:"uniqueIndex:idx_jobs_connection_job_id"`
+	RunID int64 `gorm:"index"`
+	// Connection names the GitHub instance the job was reported by; job and
+	// run IDs are unique per connection.
+	Connection      string `gorm:"uniqueIndex:idx_jobs_connection_job_id;type:varchar(100);not null;default:'github.com'"`
 	RunURL          string
 	NodeID          string
 	HeadSHA         string
@@ -21,7 +23,7 @@ type Job struct {
 	RunnerID        int64
 	CreatedAt       time.Time
 	Name            string
-	Labels          []string
+	Labels          []string `gorm:"serializer:json"`
 	RunAttempt      int
 	RunnerName      string
 	RunnerGroupID   int64
@@ -30,6 +32,7 @@ type Job struct {
 	WorkflowName    string
 	Status          string
 	Conclusion      string
+	StartedAt       time.Time
 	CompletedAt     time.Time
 	Steps           []TaskStep
 }
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrganizationMembership records a user's active membership of a GitHub
+// organization. Organizations of github.com are stored as GitHubUser records
+// with type "Organization".
+type OrganizationMembership struct {
+	gorm.Model
+	UserID         int64 `gorm:"uniqueIndex:idx_organization_membership_connection_user_org;not null"`
+	OrganizationID int64 `gorm:"uniqueIndex:idx_organization_membership_connection_user_org;not null"`
+	// Connection names the GitHub instance of the organization. Memberships
+	// of other connections are synced for the users mapped to their logins
+	// there.
+	Connection   string `gorm:"uniqueIndex:idx_organization_membership_connection_user_org;type:varchar(100);not null;default:'github.com'"`
+	Organization string `gorm:"not null"`
+	Role         string `gorm:"type:varchar(20);not null"`
+	SyncedAt     time.Time
+}
+
+// TeamMembership records a user's membership of a team in a GitHub
+// organization.
+type TeamMembership struct {
+	gorm.Model
+	UserID         int64  `gorm:"uniqueIndex:idx_team_membership_user_team;not null"`
+	TeamID         int64  `gorm:"uniqueIndex:idx_team_membership_user_team;not null"`
+	OrganizationID int64  `gorm:"index;not null"`
+	Organization   string `gorm:"not null"`
+	Slug           string `gorm:"not null"`
+	Name           string
+	SyncedAt       time.Time
+}
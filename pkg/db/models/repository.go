@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,8 +10,9 @@ import (
 // Repository represents a GitHub repository
 type Repository struct {
 	gorm.Model
+	RepoID      int64  `gorm:"index"`
 	Name        string `gorm:"index;not null"`
-	FullName    string `gorm:"uniqueIndex;not null"`
+	FullName    string `gorm:"uniqueIndex:idx_repositories_connection_full_name;not null"`
 	Description string
 	Private     bool
 	Fork        bool
@@ -25,4 +27,60 @@ type Repository struct {
 	HasWiki     bool
 	OwnerID     uint
 	Owner       GitHubUser `gorm:"foreignKey:OwnerID"`
+	// OwnerName is the login of the user or organization owning the
+	// repository, as in its full name. It is stored for owner lookups.
+	OwnerName string `gorm:"index;type:varchar(100)"`
+	// Connection names the GitHub instance the repository lives on; full
+	// names are unique per connection.
+	Connection string `gorm:"uniqueIndex:idx_repositories_connection_full_name;type:varchar(100);not null;default:'github.com'"`
+	// Monitor marks repositories whose workflows are polled.
+	Monitor bool `gorm:"index"`
+	// MonitorSource records who last changed Monitor: a user or discovery.
+	MonitorSource string `gorm:"type:varchar(20)"`
+	// PollIntervalSeconds overrides the global polling interval when set.
+	PollIntervalSeconds int
+	// TrackedWorkflows limits polling to workflows with these paths or
+	// names; empty tracks every workflow.
+	TrackedWorkflows []string `gorm:"serializer:json"`
+	// IgnoredBranches lists branches whose runs are not stored.
+	IgnoredBranches []string `gorm:"serializer:json"`
+	// LastPolledAt and NextPollAt schedule polling; they are kept across
+	// restarts so repositories aren't all polled again on startup.
+	LastPolledAt *time.Time
+	NextPollAt   *time.Time `gorm:"index"`
+}
+
+// Values of Repository.MonitorSource.
+const (
+	MonitorSourceManual    = "manual"
+	MonitorSourceDiscovery = "discovery"
+)
+
+// OwnerLogin returns the owner part of the repository's full name.
+func (r Repository) OwnerLogin() string {
+	owner, _, _ := strings.Cut(r.FullName, "/")
+	return owner
+}
+
+// TracksWorkflow reports whether runs of the workflow should be polled.
+func (r Repository) TracksWorkflow(path, name string) bool {
+	if len(r.TrackedWorkflows) == 0 {
+		return true
+	}
+	for _, w := range r.TrackedWorkflows {
+		if w == path || w == name || ".github/workflows/"+w == path {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoresBranch reports whether runs on the branch should be skipped.
+func (r Repository) IgnoresBranch(branch string) bool {
+	for _, b := range r.IgnoredBranches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// RoleBinding grants a role to a user or to every member of a team, either
+// on a whole organization or, when RepositoryID is set, on one of its
+// repositories.
+type RoleBinding struct {
+	gorm.Model
+	Organization string `gorm:"index;not null"`
+	RepositoryID *uint  `gorm:"index"`
+	Role         string `gorm:"type:varchar(20);not null"`
+	SubjectType  string `gorm:"type:varchar(10);not null"`
+	UserID       int64  `gorm:"index"`
+	TeamSlug     string
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a server-side login session. Only a hash of the session ID is
+// stored, so the table cannot be used to forge session cookies.
+type Session struct {
+	gorm.Model
+	TokenHash  string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	UserID     int64     `gorm:"index;not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	RevokedAt  *time.Time
+	LastSeenAt time.Time
+	UserAgent  string
+	IPAddress  string `gorm:"type:varchar(45)"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 type WorkflowStatistics struct {
 	ID          uint `gorm:"primaryKey"`
@@ -14,3 +17,164 @@ type JobStatistics struct {
 	ID        uint `gorm:"primaryKey"`
 	UpdatedAt time.Time
 }
+
+// RunSummary holds conclusion counts and rates for a set of workflow runs.
+type RunSummary struct {
+	TotalRuns           int
+	SuccessCount        int
+	FailureCount        int
+	CancelledCount      int
+	TimedOutCount       int
+	ActionRequiredCount int
+	SuccessRate         float64
+	FailureRate         float64
+	CancelledRate       float64
+	TimedOutRate        float64
+	ActionRequiredRate  float64
+}
+
+// SummarizeRuns counts the conclusions of the given runs and calculates the
+// percentage of runs for each conclusion.
+func SummarizeRuns(runs []WorkflowRun) RunSummary {
+	summary := RunSummary{TotalRuns: len(runs)}
+
+	for _, run := range runs {
+		switch run.Conclusion {
+		case "success":
+			summary.SuccessCount++
+		case "failure":
+			summary.FailureCount++
+		case "cancelled":
+			summary.CancelledCount++
+		case "timed_out":
+			summary.TimedOutCount++
+		case "action_required":
+			summary.ActionRequiredCount++
+		}
+	}
+
+	if summary.TotalRuns > 0 {
+		total := float64(summary.TotalRuns)
+		summary.SuccessRate = float64(summary.SuccessCount) / total * 100
+		summary.FailureRate = float64(summary.FailureCount) / total * 100
+		summary.CancelledRate = float64(summary.CancelledCount) / total * 100
+		summary.TimedOutRate = float64(summary.TimedOutCount) / total * 100
+		summary.ActionRequiredRate = float64(summary.ActionRequiredCount) / total * 100
+	}
+
+	return summary
+}
+
+// RerunSummary describes how often the runs of a workflow were re-run.
+type RerunSummary struct {
+	TotalRuns int
+	// RerunRuns is the number of runs with more than one attempt.
+	RerunRuns int
+	RerunRate float64
+	// Reruns is the number of attempts after the first, split into those
+	// that re-ran only the failed jobs, those that re-ran every job and
+	// those that aren't stored, whose kind isn't known.
+	Reruns           int
+	FailedJobsReruns int
+	FullReruns       int
+	UnrecordedReruns int
+	// PassedOnRerun is the number of runs whose first attempt failed and
+	// whose latest attempt succeeded, which points at flaky jobs.
+	PassedOnRerun     int
+	PassedOnRerunRate float64
+	// SupersededMinutes is the wall time of attempts that were followed by
+	// another attempt.
+	SupersededMinutes float64
+	// RerunsByActor counts the re-runs each user triggered.
+	RerunsByActor map[string]int
+}
+
+// SummarizeReruns groups the attempts of the given runs by run and counts
+// their re-runs.
+func SummarizeReruns(attempts []WorkflowRun) RerunSummary {
+	summary := RerunSummary{RerunsByActor: make(map[string]int)}
+
+	// Run IDs are only unique per connection
+	type runKey struct {
+		connection string
+		runID      int64
+	}
+	byRun := make(map[runKey][]WorkflowRun)
+	for _, attempt := range attempts {
+		key := runKey{attempt.Connection, attempt.RunID}
+		byRun[key] = append(byRun[key], attempt)
+	}
+	summary.TotalRuns = len(byRun)
+
+	for _, runAttempts := range byRun {
+		sort.Slice(runAttempts, func(i, j int) bool { return runAttempts[i].RunAttempt < runAttempts[j].RunAttempt })
+		first, latest := runAttempts[0], runAttempts[len(runAttempts)-1]
+		if latest.RunAttempt <= 1 {
+			continue
+		}
+
+		summary.RerunRuns++
+		recorded := 0
+		for _, attempt := range runAttempts {
+			if attempt.RunAttempt <= 1 {
+				continue
+			}
+			recorded++
+			if attempt.RerunFailedJobs {
+				summary.FailedJobsReruns++
+			} else {
+				summary.FullReruns++
+			}
+			if attempt.TriggeringActor != "" {
+				summary.RerunsByActor[attempt.TriggeringActor]++
+			}
+		}
+		// Attempts made before the run was first stored may be missing
+		if unrecorded := latest.RunAttempt - 1 - recorded; unrecorded > 0 {
+			summary.UnrecordedReruns += unrecorded
+		}
+		for _, attempt := range runAttempts[:len(runAttempts)-1] {
+			start := attempt.CreatedAt
+			if attempt.RunStartedAt != nil {
+				start = *attempt.RunStartedAt
+			}
+			if attempt.UpdatedAt.After(start) {
+				summary.SupersededMinutes += attempt.UpdatedAt.Sub(start).Minutes()
+			}
+		}
+		if first.RunAttempt == 1 && first.Conclusion == "failure" && latest.Conclusion == "success" {
+			summary.PassedOnRerun++
+		}
+	}
+	summary.Reruns = summary.FailedJobsReruns + summary.FullReruns + summary.UnrecordedReruns
+
+	if summary.TotalRuns > 0 {
+		summary.RerunRate = float64(summary.RerunRuns) / float64(summary.TotalRuns) * 100
+	}
+	if summary.RerunRuns > 0 {
+		summary.PassedOnRerunRate = float64(summary.PassedOnRerun) / float64(summary.RerunRuns) * 100
+	}
+
+	return summary
+}
+
+// FailedJobsOnly reports whether an attempt re-ran only the failed jobs of
+// its run, given the jobs of every earlier attempt: GitHub keeps the jobs
+// that succeeded in an earlier attempt instead of running them again, so
+// they are missing from the new one. Comparing with every earlier attempt
+// catches a re-run of a job that failed again in a failed-jobs re-run.
+func FailedJobsOnly(previous, current []Job) bool {
+	if len(previous) == 0 || len(current) == 0 {
+		return false
+	}
+	rerun := make(map[string]bool, len(current))
+	for _, job := range current {
+		rerun[job.Name] = true
+	}
+	for _, job := range previous {
+		if job.Conclusion == "success" && !rerun[job.Name] {
+			return true
+		}
+	}
+	return false
+}
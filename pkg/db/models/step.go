@@ -8,6 +8,8 @@ import (
 
 type TaskStep struct {
 	gorm.Model
+	JobID       uint  `gorm:"uniqueIndex:idx_task_steps_job_number"`
+	Number      int64 `gorm:"uniqueIndex:idx_task_steps_job_number"`
 	Name        string
 	Status      string
 	Conclusion  string
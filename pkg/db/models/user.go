@@ -41,4 +41,8 @@ type GitHubUser struct {
 	Following         int
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
+	// Connection is only set on organizations of other connections that
+	// service tokens act as. They aren't stored; stored accounts are
+	// github.com's.
+	Connection string `gorm:"-" json:"-"`
 }
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// UserToken stores a user's GitHub OAuth token, encrypted at rest, so their
+// permissions can be re-checked after they log in.
+type UserToken struct {
+	gorm.Model
+	UserID         int64  `gorm:"uniqueIndex;not null"`
+	EncryptedToken []byte `gorm:"not null"`
+}
@@ -9,7 +9,7 @@ import (
 // Workflow represents a GitHub workflow
 type Workflow struct {
 	gorm.Model
-	WorkflowID   int64     `gorm:"index"`
+	WorkflowID   int64     `gorm:"index:idx_workflows_connection_workflow_id"`
 	NodeID       string    `gorm:"index"`
 	Name         string    `gorm:"type:varchar(255);not null"`
 	Path         string    `gorm:"type:varchar(255);not null"`
@@ -20,6 +20,9 @@ type Workflow struct {
 	HTMLURL      string    `gorm:"column:html_url;type:varchar(255)"`
 	BadgeURL     string    `gorm:"column:badge_url;type:varchar(255)"`
 	RepositoryID uint      `gorm:"not null"`
+	// Connection names the GitHub instance of the workflow's repository;
+	// workflow IDs are unique per connection.
+	Connection string `gorm:"index:idx_workflows_connection_workflow_id;type:varchar(100);not null;default:'github.com'"`
 	// You might want to add a foreign key relationship to a Repository model if you have one
 	Repository Repository `gorm:"foreignKey:RepositoryID"`
 }
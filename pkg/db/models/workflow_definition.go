@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+	"gorm.io/gorm"
+)
+
+// WorkflowDefinition is one version of a workflow file. A version is stored
+// once per distinct content, with the commit it was first seen at; the
+// commits it was seen at are WorkflowDefinitionCommits.
+type WorkflowDefinition struct {
+	gorm.Model
+	WorkflowID   int64 `gorm:"uniqueIndex:idx_workflow_definitions_connection_workflow_content"`
+	RepositoryID uint  `gorm:"index"`
+	// Connection names the GitHub instance of the repository, as workflow
+	// IDs are unique per connection.
+	Connection string `gorm:"uniqueIndex:idx_workflow_definitions_connection_workflow_content;type:varchar(100);not null;default:'github.com'"`
+	// ContentSHA is the Git blob SHA of the file.
+	ContentSHA string `gorm:"uniqueIndex:idx_workflow_definitions_connection_workflow_content;type:varchar(64)"`
+	Path       string
+	// CommitSHA and FirstSeenAt are the head commit and creation time of the
+	// earliest run seen with this version.
+	CommitSHA   string
+	FirstSeenAt time.Time              `gorm:"index"`
+	Content     string                 `gorm:"type:text"`
+	Definition  definitions.Definition `gorm:"serializer:json"`
+	// ParseError is set when the file is not a valid workflow.
+	ParseError string
+}
+
+// WorkflowDefinitionCommit records which version of a workflow file a commit
+// has, so each commit's file is only fetched once. Ordered by SeenAt, the
+// commits give the history of the file, including reverts to an earlier
+// version.
+type WorkflowDefinitionCommit struct {
+	gorm.Model
+	WorkflowID   int64  `gorm:"uniqueIndex:idx_workflow_definition_commits_connection_workflow_commit"`
+	Connection   string `gorm:"uniqueIndex:idx_workflow_definition_commits_connection_workflow_commit;type:varchar(100);not null;default:'github.com'"`
+	CommitSHA    string `gorm:"uniqueIndex:idx_workflow_definition_commits_connection_workflow_commit;type:varchar(64)"`
+	DefinitionID uint   `gorm:"index"`
+	// SeenAt is the creation time of the earliest run seen at the commit.
+	SeenAt time.Time `gorm:"index"`
+}
+
+// LatestWorkflowDefinitions selects the ID of the version of each workflow's
+// file at its most recently run commit.
+func LatestWorkflowDefinitions(db *gorm.DB) *gorm.DB {
+	return db.Model(&WorkflowDefinitionCommit{}).
+		Select("DISTINCT ON (connection, workflow_id) definition_id").
+		Order("connection, workflow_id, seen_at DESC, id DESC")
+}
+
+// DefinitionChanges returns the commits, in the order given, at which a
+// workflow's file changed to another version, so a revert to an earlier
+// version appears again.
+func DefinitionChanges(commits []WorkflowDefinitionCommit) []WorkflowDefinitionCommit {
+	var changes []WorkflowDefinitionCommit
+	for _, commit := range commits {
+		if len(changes) == 0 || changes[len(changes)-1].DefinitionID != commit.DefinitionID {
+			changes = append(changes, commit)
+		}
+	}
+	return changes
+}
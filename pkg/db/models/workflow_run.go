@@ -6,9 +6,23 @@ import (
 	"gorm.io/gorm"
 )
 
+// LatestRunAttempt matches the workflow_runs rows that are the latest
+// attempt of their run.
+const LatestRunAttempt = `NOT EXISTS (SELECT 1 FROM workflow_runs AS later
+	WHERE later.connection = workflow_runs.connection AND later.run_id = workflow_runs.run_id
+	AND later.run_attempt > workflow_runs.run_attempt
+	AND later.deleted_at IS NULL)`
+
+// LatestAttempts limits a query of workflow runs to the latest attempt of
+// each run, so re-runs don't count as separate runs.
+func LatestAttempts(db *gorm.DB) *gorm.DB {
+	return db.Where(LatestRunAttempt)
+}
+
 // WorkflowRun represents a workflow run from GitHub API
 type WorkflowRun struct {
 	gorm.Model
+	RunID            int64 `gorm:"uniqueIndex:idx_workflow_runs_connection_run_attempt"`
 	WorkflowID       int64 `gorm:"index"`
 	Name             string
 	NodeID           string
@@ -28,7 +42,7 @@ type WorkflowRun struct {
 	RerunURL         string
 	WorkflowURL      string
 	RunNumber        int
-	RunAttempt       int
+	RunAttempt       int `gorm:"uniqueIndex:idx_workflow_runs_connection_run_attempt"`
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 	RunStartedAt     *time.Time
@@ -37,11 +51,40 @@ type WorkflowRun struct {
 	RepositoryID     int64         `gorm:"index"`
 	HeadRepository   Repository    `gorm:"foreignKey:HeadRepositoryID"`
 	HeadRepositoryID int64
+	// Connection names the GitHub instance the run was reported by; run IDs
+	// and repository IDs are unique per connection.
+	Connection string `gorm:"uniqueIndex:idx_workflow_runs_connection_run_attempt;type:varchar(100);not null;default:'github.com'"`
+	// Actor started the run; TriggeringActor started this attempt, which
+	// differs for re-runs.
+	Actor           string
+	TriggeringActor string
+	// RerunFailedJobs is set for attempts that re-ran only the failed jobs
+	// of their run.
+	RerunFailedJobs bool
 }
 
-// PullRequest represents a pull request associated with a workflow run
+// PullRequest represents a pull request associated with workflow runs. Run
+// payloads only carry its number, head and base; title, author, state and
+// times come from pull_request webhooks or are fetched when missing.
 type PullRequest struct {
 	gorm.Model
-	URL    string
-	Number int
+	// RepositoryID is the GitHub ID of the base repository, as in
+	// WorkflowRun.RepositoryID.
+	RepositoryID int64 `gorm:"uniqueIndex:idx_pull_requests_connection_repository_number"`
+	Number       int   `gorm:"uniqueIndex:idx_pull_requests_connection_repository_number"`
+	// Connection names the GitHub instance of the repository.
+	Connection   string `gorm:"uniqueIndex:idx_pull_requests_connection_repository_number;type:varchar(100);not null;default:'github.com'"`
+	URL          string
+	HTMLURL      string
+	Title        string
+	Author       string
+	State        string
+	Draft        bool
+	HeadBranch   string
+	HeadSHA      string
+	BaseBranch   string
+	OpenedAt     *time.Time
+	ClosedAt     *time.Time
+	MergedAt     *time.Time
+	WorkflowRuns []WorkflowRun `gorm:"many2many:workflow_run_pull_requests;"`
 }
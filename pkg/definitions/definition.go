@@ -0,0 +1,223 @@
+package definitions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is the metadata of a workflow file that matters for comparing
+// versions of it.
+type Definition struct {
+	Name string `json:"name,omitempty"`
+	// Triggers are the events that start the workflow, sorted.
+	Triggers []string `json:"triggers"`
+	// Permissions of the GITHUB_TOKEN. A shorthand such as read-all is
+	// stored under the "*" key.
+	Permissions map[string]string `json:"permissions,omitempty"`
+	Jobs        []Job             `json:"jobs"`
+}
+
+// Job is the metadata of one job of a workflow.
+type Job struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name,omitempty"`
+	RunsOn      []string          `json:"runs_on,omitempty"`
+	Needs       []string          `json:"needs,omitempty"`
+	Permissions map[string]string `json:"permissions,omitempty"`
+	// Matrix maps each matrix dimension to its values. A dimension set by
+	// an expression has the expression as its only value; include and
+	// exclude are not expanded.
+	Matrix map[string][]string `json:"matrix,omitempty"`
+	// Dimensions are the keys of Matrix in the order they are written,
+	// which is the order GitHub lists their values in matrix job names.
+	Dimensions []string `json:"dimensions,omitempty"`
+	// Uses lists the actions of the job's steps, or the reusable workflow
+	// the job calls, as written, for example actions/checkout@v4.
+	Uses []string `json:"uses,omitempty"`
+}
+
+// Actions returns every action and reusable workflow used by the workflow,
+// sorted and without duplicates.
+func (d Definition) Actions() []string {
+	seen := make(map[string]bool)
+	var actions []string
+	for _, job := range d.Jobs {
+		for _, uses := range job.Uses {
+			if !seen[uses] {
+				seen[uses] = true
+				actions = append(actions, uses)
+			}
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+type workflowFile struct {
+	Name        string                 `yaml:"name"`
+	On          yaml.Node              `yaml:"on"`
+	Permissions yaml.Node              `yaml:"permissions"`
+	Jobs        map[string]workflowJob `yaml:"jobs"`
+}
+
+type workflowJob struct {
+	Name        string    `yaml:"name"`
+	RunsOn      yaml.Node `yaml:"runs-on"`
+	Needs       yaml.Node `yaml:"needs"`
+	Permissions yaml.Node `yaml:"permissions"`
+	Uses        string    `yaml:"uses"`
+	Strategy    struct {
+		Matrix yaml.Node `yaml:"matrix"`
+	} `yaml:"strategy"`
+	Steps []struct {
+		Uses string `yaml:"uses"`
+	} `yaml:"steps"`
+}
+
+// Parse extracts the metadata of a workflow file.
+func Parse(content []byte) (Definition, error) {
+	var file workflowFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return Definition{}, fmt.Errorf("invalid workflow file: %w", err)
+	}
+
+	definition := Definition{
+		Name:        file.Name,
+		Triggers:    triggers(&file.On),
+		Permissions: permissions(&file.Permissions),
+		Jobs:        make([]Job, 0, len(file.Jobs)),
+	}
+	for id, job := range file.Jobs {
+		parsed := Job{
+			ID:          id,
+			Name:        job.Name,
+			RunsOn:      runsOn(&job.RunsOn),
+			Needs:       stringList(&job.Needs),
+			Permissions: permissions(&job.Permissions),
+		}
+		parsed.Matrix, parsed.Dimensions = matrix(&job.Strategy.Matrix)
+		if job.Uses != "" {
+			parsed.Uses = append(parsed.Uses, job.Uses)
+		}
+		for _, step := range job.Steps {
+			if step.Uses != "" {
+				parsed.Uses = append(parsed.Uses, step.Uses)
+			}
+		}
+		definition.Jobs = append(definition.Jobs, parsed)
+	}
+	sort.Slice(definition.Jobs, func(i, j int) bool { return definition.Jobs[i].ID < definition.Jobs[j].ID })
+	return definition, nil
+}
+
+// triggers reads the on key, which is an event, a list of events or a map
+// from events to their filters.
+func triggers(node *yaml.Node) []string {
+	var events []string
+	switch node.Kind {
+	case yaml.ScalarNode:
+		events = []string{node.Value}
+	case yaml.SequenceNode:
+		events = stringList(node)
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			events = append(events, node.Content[i].Value)
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// permissions reads a permissions key, which is a shorthand such as
+// read-all or a map from scopes to access levels.
+func permissions(node *yaml.Node) map[string]string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return map[string]string{"*": node.Value}
+	case yaml.MappingNode:
+		perms := make(map[string]string, len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			perms[node.Content[i].Value] = node.Content[i+1].Value
+		}
+		return perms
+	}
+	return nil
+}
+
+// runsOn reads the runs-on key, which is a label, a list of labels or a
+// map with a runner group and labels.
+func runsOn(node *yaml.Node) []string {
+	if node.Kind != yaml.MappingNode {
+		return stringList(node)
+	}
+	var runners []string
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch key {
+		case "group":
+			runners = append(runners, "group:"+value.Value)
+		case "labels":
+			runners = append(runners, stringList(value)...)
+		}
+	}
+	return runners
+}
+
+// matrix reads the dimensions of a strategy matrix and their order,
+// skipping include and exclude. A matrix given as an expression is stored
+// under the "*" key.
+func matrix(node *yaml.Node) (map[string][]string, []string) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return map[string][]string{"*": {node.Value}}, nil
+	case yaml.MappingNode:
+		values := make(map[string][]string)
+		var keys []string
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "include" || key == "exclude" {
+				continue
+			}
+			values[key] = stringList(node.Content[i+1])
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			return nil, nil
+		}
+		return values, keys
+	}
+	return nil, nil
+}
+
+// stringList reads a scalar or a list of scalars. Values that are not
+// scalars, such as maps in a matrix dimension, are kept as flow YAML.
+func stringList(node *yaml.Node) []string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				values = append(values, item.Value)
+				continue
+			}
+			values = append(values, flow(item))
+		}
+		return values
+	}
+	return nil
+}
+
+func flow(node *yaml.Node) string {
+	copied := *node
+	copied.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&copied)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,65 @@
+package definitions
+
+import "reflect"
+
+// Change is one difference between two versions of a workflow. Field names
+// what changed, such as triggers or jobs.build.runs_on; Before is nil for
+// additions and After is nil for removals.
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff returns the changes from before to after, ordered by field.
+func Diff(before, after Definition) []Change {
+	var changes []Change
+	add := func(field string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, Change{Field: field, Before: a, After: b})
+		}
+	}
+
+	add("name", nonEmpty(before.Name), nonEmpty(after.Name))
+	add("triggers", nonEmpty(before.Triggers), nonEmpty(after.Triggers))
+	add("permissions", nonEmpty(before.Permissions), nonEmpty(after.Permissions))
+
+	// Jobs are sorted by ID, so merging finds added and removed jobs and
+	// keeps the changes ordered
+	i, j := 0, 0
+	for i < len(before.Jobs) || j < len(after.Jobs) {
+		switch {
+		case j == len(after.Jobs) || (i < len(before.Jobs) && before.Jobs[i].ID < after.Jobs[j].ID):
+			changes = append(changes, Change{Field: "jobs." + before.Jobs[i].ID, Before: before.Jobs[i]})
+			i++
+		case i == len(before.Jobs) || after.Jobs[j].ID < before.Jobs[i].ID:
+			changes = append(changes, Change{Field: "jobs." + after.Jobs[j].ID, After: after.Jobs[j]})
+			j++
+		default:
+			a, b := before.Jobs[i], after.Jobs[j]
+			prefix := "jobs." + a.ID + "."
+			add(prefix+"name", nonEmpty(a.Name), nonEmpty(b.Name))
+			add(prefix+"runs_on", nonEmpty(a.RunsOn), nonEmpty(b.RunsOn))
+			add(prefix+"needs", nonEmpty(a.Needs), nonEmpty(b.Needs))
+			add(prefix+"permissions", nonEmpty(a.Permissions), nonEmpty(b.Permissions))
+			add(prefix+"matrix", nonEmpty(a.Matrix), nonEmpty(b.Matrix))
+			add(prefix+"uses", nonEmpty(a.Uses), nonEmpty(b.Uses))
+			i++
+			j++
+		}
+	}
+	return changes
+}
+
+// nonEmpty returns nil for empty values, so a missing value and an empty
+// one compare equal and are left out of changes.
+func nonEmpty(v interface{}) interface{} {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return nil
+		}
+	}
+	return v
+}
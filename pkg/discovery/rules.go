@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+)
+
+// regexPrefix marks a name pattern as a regular expression instead of a glob.
+// Like globs, regular expressions match whole names, ignoring case.
+const regexPrefix = "re:"
+
+// Visibilities a rule can be limited to. An empty visibility matches all.
+var visibilities = map[string]bool{"": true, "all": true, "public": true, "private": true, "internal": true}
+
+// Rule selects repositories of an organization to monitor.
+type Rule struct {
+	// Connection names the GitHub connection of the organization; empty is
+	// the default connection.
+	Connection   string
+	Organization string
+	// Include and Exclude match repository names. An empty Include matches
+	// every name; Exclude wins over Include.
+	Include []string
+	Exclude []string
+	// Topics requires at least one of the topics; ExcludeTopics rejects
+	// repositories with any of them.
+	Topics          []string
+	ExcludeTopics   []string
+	Visibility      string
+	IncludeArchived bool
+	IncludeForks    bool
+}
+
+// FromModel converts a stored discovery rule.
+func FromModel(rule models.DiscoveryRule) Rule {
+	return Rule{
+		Connection:      rule.Connection,
+		Organization:    rule.Organization,
+		Include:         rule.Include,
+		Exclude:         rule.Exclude,
+		Topics:          rule.Topics,
+		ExcludeTopics:   rule.ExcludeTopics,
+		Visibility:      rule.Visibility,
+		IncludeArchived: rule.IncludeArchived,
+		IncludeForks:    rule.IncludeForks,
+	}
+}
+
+// FromConfig converts a discovery rule from the configuration file.
+func FromConfig(rule config.DiscoveryRuleConfig) Rule {
+	return Rule{
+		Connection:      rule.Connection,
+		Organization:    rule.Organization,
+		Include:         rule.Include,
+		Exclude:         rule.Exclude,
+		Topics:          rule.Topics,
+		ExcludeTopics:   rule.ExcludeTopics,
+		Visibility:      rule.Visibility,
+		IncludeArchived: rule.IncludeArchived,
+		IncludeForks:    rule.IncludeForks,
+	}
+}
+
+// Validate checks that the rule names an organization, a known visibility
+// and only well-formed patterns.
+func (r Rule) Validate() error {
+	if r.Organization == "" {
+		return fmt.Errorf("organization is required")
+	}
+	if !visibilities[r.Visibility] {
+		return fmt.Errorf("visibility must be all, public, private or internal")
+	}
+	for _, pattern := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := matchName(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the repository is selected by the rule.
+func (r Rule) Matches(repo *gh.Repository) bool {
+	if !strings.EqualFold(repo.GetOwner().GetLogin(), r.Organization) {
+		return false
+	}
+	if repo.GetArchived() && !r.IncludeArchived {
+		return false
+	}
+	if repo.GetFork() && !r.IncludeForks {
+		return false
+	}
+	if r.Visibility != "" && r.Visibility != "all" && !strings.EqualFold(visibility(repo), r.Visibility) {
+		return false
+	}
+
+	if len(r.Include) > 0 && !matchAny(r.Include, repo.GetName()) {
+		return false
+	}
+	if matchAny(r.Exclude, repo.GetName()) {
+		return false
+	}
+
+	if len(r.Topics) > 0 && !hasAnyTopic(repo, r.Topics) {
+		return false
+	}
+	return !hasAnyTopic(repo, r.ExcludeTopics)
+}
+
+// MatchesAny reports whether any of the rules selects the repository.
+func MatchesAny(rules []Rule, repo *gh.Repository) bool {
+	for _, rule := range rules {
+		if rule.Matches(repo) {
+			return true
+		}
+	}
+	return false
+}
+
+func visibility(repo *gh.Repository) string {
+	if v := repo.GetVisibility(); v != "" {
+		return v
+	}
+	if repo.GetPrivate() {
+		return "private"
+	}
+	return "public"
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// Patterns are validated when rules are created, so errors are
+		// treated as no match.
+		if ok, _ := matchName(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchName(pattern, name string) (bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, regexPrefix); ok {
+		re, err := regexp.Compile("(?i)^(?:" + expr + ")$")
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+		}
+		return re.MatchString(name), nil
+	}
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	if err != nil {
+		return false, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+	return ok, nil
+}
+
+func hasAnyTopic(repo *gh.Repository, topics []string) bool {
+	for _, topic := range repo.Topics {
+		for _, t := range topics {
+			if strings.EqualFold(topic, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
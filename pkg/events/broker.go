@@ -0,0 +1,170 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TypeWorkflowRun = "workflow_run"
+	TypeWorkflowJob = "workflow_job"
+
+	// subscriberBuffer is the number of events buffered per subscriber before
+	// new events are dropped for it.
+	subscriberBuffer = 64
+)
+
+// Event describes a state change of a workflow run or job.
+type Event struct {
+	Type       string    `json:"type"`
+	Action     string    `json:"action"`
+	Connection string    `json:"connection"`
+	Repository string    `json:"repository"`
+	Workflow   string    `json:"workflow"`
+	Branch     string    `json:"branch"`
+	RunID      int64     `json:"run_id"`
+	JobID      int64     `json:"job_id,omitempty"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Conclusion string    `json:"conclusion,omitempty"`
+	HTMLURL    string    `json:"html_url"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Filter selects the events a subscriber receives. Empty fields match
+// everything; multiple values within a field are OR-ed together.
+type Filter struct {
+	Repositories []string
+	Workflows    []string
+	Branches     []string
+	// AllowedRepositories, when non-nil, restricts events to repositories
+	// the subscriber is authorized to see.
+	AllowedRepositories *Allowlist
+}
+
+// Matches reports whether the event passes the filter.
+func (f Filter) Matches(e Event) bool {
+	if f.AllowedRepositories != nil && !f.AllowedRepositories.Allows(e) {
+		return false
+	}
+	return matchesAny(f.Repositories, e.Repository) &&
+		matchesAny(f.Workflows, e.Workflow) &&
+		matchesAny(f.Branches, e.Branch)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RepositoryKey identifies a repository. Full names are only unique within
+// a connection.
+type RepositoryKey struct {
+	Connection string
+	FullName   string
+}
+
+// Allowlist is a set of repositories that can be replaced while the
+// subscription using it receives events, for example when access changes.
+type Allowlist struct {
+	mu           sync.RWMutex
+	repositories map[RepositoryKey]bool
+}
+
+// NewAllowlist creates an Allowlist of the given repositories.
+func NewAllowlist(repositories []RepositoryKey) *Allowlist {
+	a := &Allowlist{}
+	a.Replace(repositories)
+	return a
+}
+
+// Replace replaces the repositories in the allowlist.
+func (a *Allowlist) Replace(repositories []RepositoryKey) {
+	set := make(map[RepositoryKey]bool, len(repositories))
+	for _, key := range repositories {
+		set[key] = true
+	}
+
+	a.mu.Lock()
+	a.repositories = set
+	a.mu.Unlock()
+}
+
+// Allows reports whether the event's repository is in the allowlist.
+func (a *Allowlist) Allows(e Event) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.repositories[RepositoryKey{Connection: e.Connection, FullName: e.Repository}]
+}
+
+// Subscription is a stream of events matching a filter.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+}
+
+// Broker fans out published events to subscribers. Publishing never blocks:
+// subscribers that fall behind miss events instead of stalling ingestion.
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a new Broker.
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscription for events matching filter.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe removes a subscription and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish delivers an event to every matching subscriber.
+func (b *Broker) Publish(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// Subscriber is not keeping up; drop the event for it.
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroupSize bounds how many rows are buffered in memory before a
+// row group is flushed to the output.
+const parquetRowGroupSize = 10000
+
+// Encoder writes records of type T to an output stream.
+type Encoder[T any] interface {
+	Encode(record T) error
+	Close() error
+}
+
+// NewEncoder creates an Encoder that writes records of type T to w in the
+// given format. Records must be flat structs like RunRecord.
+func NewEncoder[T any](w io.Writer, format Format) (Encoder[T], error) {
+	switch format {
+	case FormatCSV:
+		return newCSVEncoder[T](w)
+	case FormatNDJSON:
+		return &ndjsonEncoder[T]{enc: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return &parquetEncoder[T]{w: parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize))}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %q", format)
+	}
+}
+
+type ndjsonEncoder[T any] struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder[T]) Encode(record T) error {
+	return e.enc.Encode(record)
+}
+
+func (e *ndjsonEncoder[T]) Close() error {
+	return nil
+}
+
+type parquetEncoder[T any] struct {
+	w *parquet.GenericWriter[T]
+}
+
+func (e *parquetEncoder[T]) Encode(record T) error {
+	_, err := e.w.Write([]T{record})
+	return err
+}
+
+func (e *parquetEncoder[T]) Close() error {
+	return e.w.Close()
+}
+
+// csvEncoder writes records as CSV, using the json tags of the record's
+// fields as the header.
+type csvEncoder[T any] struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder[T any](w io.Writer) (*csvEncoder[T], error) {
+	e := &csvEncoder[T]{w: csv.NewWriter(w)}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	header := make([]string, t.NumField())
+	for i := range header {
+		header[i] = strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+	}
+	if err := e.w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvEncoder[T]) Encode(record T) error {
+	v := reflect.ValueOf(record)
+	row := make([]string, v.NumField())
+	for i := range row {
+		row[i] = formatValue(v.Field(i).Interface())
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder[T]) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
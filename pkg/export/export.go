@@ -0,0 +1,175 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+// Dataset is the kind of records to export.
+type Dataset string
+
+const (
+	DatasetRuns  Dataset = "runs"
+	DatasetJobs  Dataset = "jobs"
+	DatasetSteps Dataset = "steps"
+)
+
+// Format is the output encoding of an export.
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+)
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// Options selects the records to export and how to encode them.
+type Options struct {
+	Dataset Dataset
+	Format  Format
+	Since   time.Time
+	Until   time.Time
+	// Repositories limits the export to the given repository full names.
+	// An empty list exports every repository.
+	Repositories []string
+	// Viewer limits the export to repositories visible to a user or
+	// token. Nil exports every repository and is meant for operator tooling
+	// only.
+	Viewer *auth.Viewer
+}
+
+// Validate checks that the dataset, format and time range are supported.
+func (o Options) Validate() error {
+	switch o.Dataset {
+	case DatasetRuns, DatasetJobs, DatasetSteps:
+	default:
+		return fmt.Errorf("unsupported dataset: %q", o.Dataset)
+	}
+	switch o.Format {
+	case FormatCSV, FormatNDJSON, FormatParquet:
+	default:
+		return fmt.Errorf("unsupported format: %q", o.Format)
+	}
+	if !o.Since.Before(o.Until) {
+		return fmt.Errorf("since must be before until")
+	}
+	return nil
+}
+
+// Export streams the records selected by opts to w. Rows are read from the
+// database with a cursor and encoded one at a time, so memory use does not
+// grow with the size of the export.
+func Export(ctx context.Context, db *gorm.DB, w io.Writer, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	db = db.WithContext(ctx)
+	switch opts.Dataset {
+	case DatasetRuns:
+		return exportRecords[RunRecord](runsQuery(db, opts), w, opts.Format)
+	case DatasetJobs:
+		return exportRecords[JobRecord](jobsQuery(db, opts), w, opts.Format)
+	default:
+		return exportRecords[StepRecord](stepsQuery(db, opts), w, opts.Format)
+	}
+}
+
+func exportRecords[T any](query *gorm.DB, w io.Writer, format Format) error {
+	enc, err := NewEncoder[T](w, format)
+	if err != nil {
+		return err
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return fmt.Errorf("failed to query records: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record T
+		if err := query.ScanRows(rows, &record); err != nil {
+			return fmt.Errorf("failed to scan record: %w", err)
+		}
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read records: %w", err)
+	}
+
+	return enc.Close()
+}
+
+func runsQuery(db *gorm.DB, opts Options) *gorm.DB {
+	query := db.Table("workflow_runs").
+		Select(`workflow_runs.run_id, COALESCE(repositories.full_name, '') AS repository, workflow_runs.connection,
+			workflow_runs.workflow_id, workflow_runs.name, workflow_runs.head_branch, workflow_runs.head_sha,
+			workflow_runs.event, workflow_runs.status, workflow_runs.conclusion, workflow_runs.run_number, workflow_runs.run_attempt,
+			workflow_runs.created_at, workflow_runs.updated_at`).
+		Joins("LEFT JOIN repositories ON repositories.connection = workflow_runs.connection AND repositories.repo_id = workflow_runs.repository_id").
+		Where("workflow_runs.deleted_at IS NULL").
+		Where("workflow_runs.created_at BETWEEN ? AND ?", opts.Since, opts.Until).
+		Order("workflow_runs.id")
+	return filterRepositories(query, opts)
+}
+
+func jobsQuery(db *gorm.DB, opts Options) *gorm.DB {
+	query := db.Table("jobs").
+		Select(`jobs.job_id, jobs.run_id, COALESCE(repositories.full_name, '') AS repository, jobs.connection, jobs.workflow_name,
+			jobs.name, jobs.status, jobs.conclusion, jobs.head_sha, jobs.runner_name, jobs.runner_group_name,
+			jobs.run_attempt, jobs.created_at, jobs.completed_at`).
+		Joins("LEFT JOIN workflow_runs ON workflow_runs.connection = jobs.connection AND workflow_runs.run_id = jobs.run_id"+
+			" AND workflow_runs.deleted_at IS NULL AND "+models.LatestRunAttempt).
+		Joins("LEFT JOIN repositories ON repositories.connection = workflow_runs.connection AND repositories.repo_id = workflow_runs.repository_id").
+		Where("jobs.deleted_at IS NULL").
+		Where("jobs.created_at BETWEEN ? AND ?", opts.Since, opts.Until).
+		Order("jobs.id")
+	return filterRepositories(query, opts)
+}
+
+func stepsQuery(db *gorm.DB, opts Options) *gorm.DB {
+	query := db.Table("task_steps").
+		Select(`jobs.job_id, jobs.run_id, COALESCE(repositories.full_name, '') AS repository, jobs.connection, jobs.name AS job_name,
+			task_steps.name, task_steps.status, task_steps.conclusion, task_steps.started_at, task_steps.completed_at`).
+		Joins("JOIN jobs ON jobs.id = task_steps.job_id").
+		Joins("LEFT JOIN workflow_runs ON workflow_runs.connection = jobs.connection AND workflow_runs.run_id = jobs.run_id"+
+			" AND workflow_runs.deleted_at IS NULL AND "+models.LatestRunAttempt).
+		Joins("LEFT JOIN repositories ON repositories.connection = workflow_runs.connection AND repositories.repo_id = workflow_runs.repository_id").
+		Where("task_steps.deleted_at IS NULL").
+		Where("task_steps.started_at BETWEEN ? AND ?", opts.Since, opts.Until).
+		Order("task_steps.id")
+	return filterRepositories(query, opts)
+}
+
+// filterRepositories applies the repository filters of opts to a query that
+// joins the repositories table.
+func filterRepositories(query *gorm.DB, opts Options) *gorm.DB {
+	if len(opts.Repositories) > 0 {
+		query = query.Where("repositories.full_name IN ?", opts.Repositories)
+	}
+	if opts.Viewer != nil {
+		query = query.Where("repositories.id IN (?)", auth.VisibleRepositoryIDs(query, *opts.Viewer))
+	}
+	return query
+}
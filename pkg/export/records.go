@@ -0,0 +1,53 @@
+package export
+
+import "time"
+
+// RunRecord is the exported representation of a workflow run.
+type RunRecord struct {
+	RunID      int64     `json:"run_id" parquet:"run_id"`
+	Repository string    `json:"repository" parquet:"repository,dict"`
+	Connection string    `json:"connection" parquet:"connection,dict"`
+	WorkflowID int64     `json:"workflow_id" parquet:"workflow_id"`
+	Name       string    `json:"name" parquet:"name,dict"`
+	HeadBranch string    `json:"head_branch" parquet:"head_branch"`
+	HeadSHA    string    `json:"head_sha" parquet:"head_sha"`
+	Event      string    `json:"event" parquet:"event,dict"`
+	Status     string    `json:"status" parquet:"status,dict"`
+	Conclusion string    `json:"conclusion" parquet:"conclusion,dict"`
+	RunNumber  int64     `json:"run_number" parquet:"run_number"`
+	RunAttempt int64     `json:"run_attempt" parquet:"run_attempt"`
+	CreatedAt  time.Time `json:"created_at" parquet:"created_at,timestamp(millisecond)"`
+	UpdatedAt  time.Time `json:"updated_at" parquet:"updated_at,timestamp(millisecond)"`
+}
+
+// JobRecord is the exported representation of a workflow job.
+type JobRecord struct {
+	JobID           int64     `json:"job_id" parquet:"job_id"`
+	RunID           int64     `json:"run_id" parquet:"run_id"`
+	Repository      string    `json:"repository" parquet:"repository,dict"`
+	Connection      string    `json:"connection" parquet:"connection,dict"`
+	WorkflowName    string    `json:"workflow_name" parquet:"workflow_name,dict"`
+	Name            string    `json:"name" parquet:"name,dict"`
+	Status          string    `json:"status" parquet:"status,dict"`
+	Conclusion      string    `json:"conclusion" parquet:"conclusion,dict"`
+	HeadSHA         string    `json:"head_sha" parquet:"head_sha"`
+	RunnerName      string    `json:"runner_name" parquet:"runner_name"`
+	RunnerGroupName string    `json:"runner_group_name" parquet:"runner_group_name,dict"`
+	RunAttempt      int64     `json:"run_attempt" parquet:"run_attempt"`
+	CreatedAt       time.Time `json:"created_at" parquet:"created_at,timestamp(millisecond)"`
+	CompletedAt     time.Time `json:"completed_at" parquet:"completed_at,timestamp(millisecond)"`
+}
+
+// StepRecord is the exported representation of a job step.
+type StepRecord struct {
+	JobID       int64     `json:"job_id" parquet:"job_id"`
+	RunID       int64     `json:"run_id" parquet:"run_id"`
+	Repository  string    `json:"repository" parquet:"repository,dict"`
+	Connection  string    `json:"connection" parquet:"connection,dict"`
+	JobName     string    `json:"job_name" parquet:"job_name,dict"`
+	Name        string    `json:"name" parquet:"name,dict"`
+	Status      string    `json:"status" parquet:"status,dict"`
+	Conclusion  string    `json:"conclusion" parquet:"conclusion,dict"`
+	StartedAt   time.Time `json:"started_at" parquet:"started_at,timestamp(millisecond)"`
+	CompletedAt time.Time `json:"completed_at" parquet:"completed_at,timestamp(millisecond)"`
+}
@@ -0,0 +1,234 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+)
+
+// defaultAccessSyncInterval is used when no refresh interval is configured.
+const defaultAccessSyncInterval = 15 * time.Minute
+
+// AccessSyncer periodically syncs the repository permissions and
+// organization roles of users on connections other than github.com. Users
+// log in with github.com, so their permissions there are read with the
+// connection's token for the logins they are mapped to.
+type AccessSyncer struct {
+	db          *db.Database
+	connections *Connections
+	interval    time.Duration
+	stopChan    chan struct{}
+}
+
+// NewAccessSyncer creates an AccessSyncer that syncs every interval.
+func NewAccessSyncer(db *db.Database, connections *Connections, interval time.Duration) *AccessSyncer {
+	if interval <= 0 {
+		interval = defaultAccessSyncInterval
+	}
+	return &AccessSyncer{
+		db:          db,
+		connections: connections,
+		interval:    interval,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start syncs now and then every interval in the background.
+func (s *AccessSyncer) Start() {
+	ticker := time.NewTicker(s.interval)
+	go func() {
+		s.Sync(context.Background())
+		for {
+			select {
+			case <-ticker.C:
+				s.Sync(context.Background())
+			case <-s.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the syncer.
+func (s *AccessSyncer) Stop() {
+	close(s.stopChan)
+}
+
+// Sync syncs every connection other than github.com, skipping connections
+// whose host is failing.
+func (s *AccessSyncer) Sync(ctx context.Context) {
+	for _, connection := range s.connections.All() {
+		if connection.Name == config.DefaultConnection || !connection.Available() {
+			continue
+		}
+		if err := s.SyncConnection(ctx, connection); err != nil {
+			log.Printf("Error syncing access on %s: %v", connection.Name, err)
+		}
+	}
+}
+
+// SyncConnection replaces the stored permissions on the connection's
+// repositories and the memberships of its organizations with the ones the
+// connection reports for the mapped users. Repositories and organizations
+// that can't be listed keep what was stored for them.
+func (s *AccessSyncer) SyncConnection(ctx context.Context, connection *Connection) error {
+	userIDs, err := s.mappedUsers(connection)
+	if err != nil {
+		return err
+	}
+	repos, err := s.db.GetConnectionRepositories(connection.Name)
+	if err != nil {
+		return fmt.Errorf("failed to load repositories: %w", err)
+	}
+
+	now := time.Now()
+	client := connection.Client.ghClient
+	var access []models.RepositoryAccess
+	var keptRepositories []uint
+	orgs := make(map[string]string)
+	for _, repo := range repos {
+		orgs[strings.ToLower(repo.OwnerLogin())] = repo.OwnerLogin()
+		if len(userIDs) == 0 {
+			continue
+		}
+		collaborators, err := listCollaborators(ctx, client, repo.OwnerLogin(), repo.Name)
+		if err != nil {
+			log.Printf("Error listing collaborators of %s on %s: %v", repo.FullName, connection.Name, err)
+			keptRepositories = append(keptRepositories, repo.ID)
+			continue
+		}
+		for _, collaborator := range collaborators {
+			userID, ok := userIDs[strings.ToLower(collaborator.GetLogin())]
+			permission := auth.HighestPermission(collaborator.Permissions)
+			if !ok || permission == "" {
+				continue
+			}
+			access = append(access, models.RepositoryAccess{
+				UserID:       userID,
+				RepositoryID: repo.ID,
+				Permission:   permission,
+				SyncedAt:     now,
+			})
+		}
+	}
+
+	var memberships []models.OrganizationMembership
+	var keptOrganizations []string
+	for _, org := range orgs {
+		if len(userIDs) == 0 {
+			break
+		}
+		synced, err := organizationMemberships(ctx, client, connection.Name, org, userIDs, now)
+		if err != nil {
+			log.Printf("Error listing members of %s on %s: %v", org, connection.Name, err)
+			keptOrganizations = append(keptOrganizations, org)
+			continue
+		}
+		memberships = append(memberships, synced...)
+	}
+
+	if err := s.db.ReplaceConnectionAccess(connection.Name, access, memberships, keptRepositories, keptOrganizations); err != nil {
+		return fmt.Errorf("failed to save access: %w", err)
+	}
+	return nil
+}
+
+// mappedUsers returns the IDs of the users mapped to logins on the
+// connection, by their lowercased login there. Users who never logged in
+// are left out.
+func (s *AccessSyncer) mappedUsers(connection *Connection) (map[string]int64, error) {
+	logins := make([]string, 0, len(connection.Users))
+	for login := range connection.Users {
+		logins = append(logins, login)
+	}
+	users, err := s.db.GetUsersByLogin(logins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+
+	userIDs := make(map[string]int64, len(users))
+	for _, user := range users {
+		if login, ok := connection.Users[strings.ToLower(user.Login)]; ok {
+			userIDs[strings.ToLower(login)] = user.ID
+		}
+	}
+	return userIDs, nil
+}
+
+func listCollaborators(ctx context.Context, client *gh.Client, owner, repo string) ([]*gh.User, error) {
+	opts := &gh.ListCollaboratorsOptions{
+		Affiliation: "all",
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+	var all []*gh.User
+	for {
+		users, resp, err := client.Repositories.ListCollaborators(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, users...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// organizationMemberships returns the memberships of the mapped users in an
+// organization. Repositories owned by users have no organization, so none
+// are returned for them.
+func organizationMemberships(ctx context.Context, client *gh.Client, connection, org string, userIDs map[string]int64, now time.Time) ([]models.OrganizationMembership, error) {
+	organization, _, err := client.Organizations.Get(ctx, org)
+	if err != nil {
+		var ghErr *gh.ErrorResponse
+		if errors.As(err, &ghErr) && ghErr.Response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var memberships []models.OrganizationMembership
+	// Members with the member role are those who aren't admins
+	for _, role := range []string{"admin", "member"} {
+		opts := &gh.ListMembersOptions{
+			Role:        role,
+			ListOptions: gh.ListOptions{PerPage: 100},
+		}
+		for {
+			members, resp, err := client.Organizations.ListMembers(ctx, org, opts)
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range members {
+				userID, ok := userIDs[strings.ToLower(member.GetLogin())]
+				if !ok {
+					continue
+				}
+				memberships = append(memberships, models.OrganizationMembership{
+					UserID:         userID,
+					OrganizationID: organization.GetID(),
+					Connection:     connection,
+					Organization:   organization.GetLogin(),
+					Role:           role,
+					SyncedAt:       now,
+				})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+	return memberships, nil
+}
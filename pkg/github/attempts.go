@@ -0,0 +1,124 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+)
+
+// saveWorkflowRun saves an attempt of a run and, when it changed since it
+// was last saved, its jobs, the attempts before it and its pull requests.
+// The jobs are synced first: the run is only saved once they are, so a run
+// whose jobs could not be fetched is still out of date on the next poll and
+// is synced again.
+func saveWorkflowRun(ctx context.Context, database *db.Database, client *gh.Client, repo models.Repository, run *gh.WorkflowRun, changed bool) error {
+	actor := ""
+	if changed {
+		actor = triggeringActor(ctx, client, repo, run.GetID(), run.GetRunAttempt())
+		if err := syncWorkflowJobs(ctx, database, client, repo, run.GetID()); err != nil {
+			return err
+		}
+	}
+	if err := database.SaveWorkflowRun(repo.Connection, run, actor); err != nil {
+		return err
+	}
+	if changed {
+		syncRunAttempts(ctx, database, client, repo, run)
+		syncPullRequests(ctx, database, client, repo, run)
+	}
+	return nil
+}
+
+// syncPullRequests fetches the details of the run's pull requests that are
+// only known by number, for repositories whose pull_request webhooks don't
+// reach the aggregator.
+func syncPullRequests(ctx context.Context, database *db.Database, client *gh.Client, repo models.Repository, run *gh.WorkflowRun) {
+	for _, pr := range run.PullRequests {
+		// Pull requests of other repositories, such as forks' upstreams,
+		// are not fetched
+		if id := pr.GetBase().GetRepo().GetID(); id != 0 && id != repo.RepoID {
+			continue
+		}
+		known, err := database.HasPullRequestDetails(repo.Connection, repo.RepoID, pr.GetNumber())
+		if err != nil || known {
+			continue
+		}
+		details, _, err := client.PullRequests.Get(ctx, repo.OwnerLogin(), repo.Name, pr.GetNumber())
+		if err != nil {
+			log.Printf("Error fetching pull request #%d of %s: %v", pr.GetNumber(), repo.FullName, err)
+			continue
+		}
+		if err := database.SavePullRequest(repo.Connection, repo.RepoID, details); err != nil {
+			log.Printf("Error saving pull request #%d of %s: %v", pr.GetNumber(), repo.FullName, err)
+		}
+	}
+}
+
+// syncRunAttempts saves the earlier attempts of a run that are missing,
+// such as those re-run before the run was first polled, and records which
+// re-runs only re-ran the failed jobs.
+func syncRunAttempts(ctx context.Context, database *db.Database, client *gh.Client, repo models.Repository, run *gh.WorkflowRun) {
+	latest := run.GetRunAttempt()
+	if latest <= 1 {
+		return
+	}
+
+	stored, err := database.GetWorkflowRunAttempts(repo.Connection, run.GetID())
+	if err != nil {
+		log.Printf("Error loading attempts of workflow run ID %d: %v", run.GetID(), err)
+		return
+	}
+	have := make(map[int]bool, len(stored))
+	for _, attempt := range stored {
+		have[attempt] = true
+	}
+
+	owner, repoName := repo.OwnerLogin(), repo.Name
+	for attempt := 1; attempt < latest; attempt++ {
+		if have[attempt] {
+			continue
+		}
+		earlier, _, err := client.Actions.GetWorkflowRunAttempt(ctx, owner, repoName, run.GetID(), attempt, nil)
+		if err != nil {
+			log.Printf("Error fetching attempt %d of workflow run ID %d: %v", attempt, run.GetID(), err)
+			continue
+		}
+		if err := database.SaveWorkflowRun(repo.Connection, earlier, triggeringActor(ctx, client, repo, run.GetID(), attempt)); err != nil {
+			log.Printf("Error saving attempt %d of workflow run ID %d: %v", attempt, run.GetID(), err)
+		}
+	}
+
+	for attempt := 2; attempt <= latest; attempt++ {
+		if err := database.MarkRerunFailedJobs(repo.Connection, run.GetID(), attempt); err != nil {
+			log.Printf("Error checking attempt %d of workflow run ID %d: %v", attempt, run.GetID(), err)
+		}
+	}
+}
+
+// triggeringActor returns the login of the user who started a re-run. The
+// field is not exposed by the go-github WorkflowRun type, so the attempt is
+// fetched directly. First attempts return an empty string, as their run's
+// actor started them.
+func triggeringActor(ctx context.Context, client *gh.Client, repo models.Repository, runID int64, attempt int) string {
+	if attempt <= 1 {
+		return ""
+	}
+
+	u := fmt.Sprintf("repos/%v/%v/actions/runs/%v/attempts/%v", repo.OwnerLogin(), repo.Name, runID, attempt)
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return ""
+	}
+	var attemptRun struct {
+		TriggeringActor *gh.User `json:"triggering_actor"`
+	}
+	if _, err := client.Do(ctx, req, &attemptRun); err != nil {
+		log.Printf("Error fetching the actor of attempt %d of workflow run ID %d: %v", attempt, runID, err)
+		return ""
+	}
+	return attemptRun.TriggeringActor.GetLogin()
+}
@@ -2,6 +2,7 @@ package github
 
 import (
 	"context"
+	"net/http"
 
 	gh "github.com/google/go-github/v50/github"
 	"golang.org/x/oauth2"
@@ -13,25 +14,59 @@ type Client struct {
 }
 
 func NewClient(token string) *Client {
-	ctx := context.Background()
+	client, _ := newClient(context.Background(), token, "", "")
+	return client
+}
+
+// NewEnterpriseClient creates a client for a GitHub Enterprise Server
+// instance with the given API base and upload URLs.
+func NewEnterpriseClient(token, baseURL, uploadURL string) (*Client, error) {
+	return newClient(context.Background(), token, baseURL, uploadURL)
+}
+
+// newClient creates a client authenticated with token. Requests are sent
+// through the resilient transport, on top of the HTTP client in ctx, if any.
+func newClient(ctx context.Context, token, baseURL, uploadURL string) (*Client, error) {
+	var base http.RoundTripper
+	if hc, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		base = hc.Transport
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: newDefaultResilientTransport(base)})
+
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
 	tc := oauth2.NewClient(ctx, ts)
+
 	client := gh.NewClient(tc)
+	if baseURL != "" {
+		if uploadURL == "" {
+			uploadURL = baseURL
+		}
+		var err error
+		client, err = gh.NewEnterpriseClient(baseURL, uploadURL, tc)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &Client{
 		ghClient: client,
-		ctx:      ctx,
+		ctx:      context.Background(),
+	}, nil
+}
+
+// WithPriority returns a client whose requests use the given rate-limit
+// priority.
+func (c *Client) WithPriority(priority Priority) *Client {
+	return &Client{
+		ghClient: c.ghClient,
+		ctx:      WithPriority(c.ctx, priority),
 	}
 }
 
 func (c *Client) ListWorkflows(owner, repo string) ([]*gh.Workflow, error) {
-	workflows, _, err := c.ghClient.Actions.ListWorkflows(c.ctx, owner, repo, nil)
-	if err != nil {
-		return nil, err
-	}
-	return workflows.Workflows, nil
+	return listWorkflows(c.ctx, c.ghClient, owner, repo)
 }
 
 func (c *Client) ListWorkflowRuns(owner, repo string, workflowID int64) ([]*gh.WorkflowRun, error) {
@@ -49,3 +84,31 @@ func (c *Client) GetWorkflowRun(owner, repo string, runID int64) (*gh.WorkflowRu
 	}
 	return run, nil
 }
+
+func (c *Client) ListWorkflowJobs(owner, repo string, runID int64) ([]*gh.WorkflowJob, error) {
+	opts := &gh.ListWorkflowJobsOptions{
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+
+	var allJobs []*gh.WorkflowJob
+	for {
+		jobs, resp, err := c.ghClient.Actions.ListWorkflowJobs(c.ctx, owner, repo, runID, opts)
+		if err != nil {
+			return nil, err
+		}
+		allJobs = append(allJobs, jobs.Jobs...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return allJobs, nil
+}
+
+func (c *Client) GetRepository(owner, repo string) (*gh.Repository, error) {
+	repository, _, err := c.ghClient.Repositories.Get(c.ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
@@ -0,0 +1,99 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"golang.org/x/oauth2"
+)
+
+// Connection is a GitHub instance the aggregator collects data from.
+type Connection struct {
+	Name          string
+	Client        *Client
+	WebhookSecret string
+	// Users maps the lowercased github.com logins of users to their logins
+	// on the connection.
+	Users map[string]string
+}
+
+// Host returns the API host of the connection.
+func (c *Connection) Host() string {
+	return c.Client.ghClient.BaseURL.Host
+}
+
+// Available reports whether the circuit breaker of the connection's host
+// lets requests through.
+func (c *Connection) Available() bool {
+	return breakerFor(c.Host()).State() != BreakerOpen
+}
+
+// Connections holds the configured GitHub connections by name.
+type Connections struct {
+	byName map[string]*Connection
+}
+
+// NewConnections creates a client for every connection. Names must be
+// unique and non-empty. Requests of each connection draw from its own
+// budget in governor.
+func NewConnections(cfgs []config.ConnectionConfig, governor *Governor) (*Connections, error) {
+	connections := &Connections{byName: make(map[string]*Connection)}
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("connection name is required")
+		}
+		if _, ok := connections.byName[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate connection %q", cfg.Name)
+		}
+
+		httpClient := &http.Client{Transport: governor.Transport(cfg.Name, http.DefaultTransport)}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+		client, err := newClient(ctx, cfg.AccessToken, cfg.BaseURL, cfg.UploadURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL for connection %q: %w", cfg.Name, err)
+		}
+
+		connections.byName[cfg.Name] = &Connection{
+			Name:          cfg.Name,
+			Client:        client,
+			WebhookSecret: cfg.WebhookSecret,
+			Users:         lowercaseKeys(cfg.Users),
+		}
+	}
+	return connections, nil
+}
+
+// Get returns the named connection. An empty name is the default
+// connection.
+func (c *Connections) Get(name string) (*Connection, error) {
+	if name == "" {
+		name = config.DefaultConnection
+	}
+	connection, ok := c.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown GitHub connection %q", name)
+	}
+	return connection, nil
+}
+
+// All returns every connection, ordered by name.
+func (c *Connections) All() []*Connection {
+	all := make([]*Connection, 0, len(c.byName))
+	for _, connection := range c.byName {
+		all = append(all, connection)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+func lowercaseKeys(m map[string]string) map[string]string {
+	lowered := make(map[string]string, len(m))
+	for k, v := range m {
+		lowered[strings.ToLower(k)] = v
+	}
+	return lowered
+}
@@ -0,0 +1,93 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+)
+
+// dynamicWorkflowPrefix marks workflows GitHub generates, such as default
+// code scanning, which have no file in the repository.
+const dynamicWorkflowPrefix = "dynamic/"
+
+// snapshotWorkflowDefinitions stores the version of a workflow's file at the
+// head commit of each run, fetching it once per commit.
+func snapshotWorkflowDefinitions(ctx context.Context, database *db.Database, client *gh.Client, repo models.Repository, workflow *gh.Workflow, runs []*gh.WorkflowRun) {
+	if strings.HasPrefix(workflow.GetPath(), dynamicWorkflowPrefix) || len(runs) == 0 {
+		return
+	}
+
+	// The earliest run of each commit is the one a version is first seen at
+	first := make(map[string]*gh.WorkflowRun)
+	var commits []string
+	for _, run := range runs {
+		sha := run.GetHeadSHA()
+		if sha == "" {
+			continue
+		}
+		if seen, ok := first[sha]; !ok {
+			commits = append(commits, sha)
+			first[sha] = run
+		} else if run.GetCreatedAt().Before(seen.GetCreatedAt().Time) {
+			first[sha] = run
+		}
+	}
+
+	stored, err := database.GetWorkflowDefinitionCommits(repo.Connection, workflow.GetID(), commits)
+	if err != nil {
+		log.Printf("Error loading definitions of workflow %s: %v", workflow.GetPath(), err)
+		return
+	}
+	for _, sha := range commits {
+		if stored[sha] {
+			continue
+		}
+		if err := snapshotWorkflowDefinition(ctx, database, client, repo, workflow, first[sha]); err != nil {
+			log.Printf("Error saving definition of workflow %s at %s: %v", workflow.GetPath(), sha, err)
+		}
+	}
+}
+
+func snapshotWorkflowDefinition(ctx context.Context, database *db.Database, client *gh.Client, repo models.Repository, workflow *gh.Workflow, run *gh.WorkflowRun) error {
+	opts := &gh.RepositoryContentGetOptions{Ref: run.GetHeadSHA()}
+	file, _, _, err := client.Repositories.GetContents(ctx, repo.OwnerLogin(), repo.Name, workflow.GetPath(), opts)
+	if err != nil {
+		var ghErr *gh.ErrorResponse
+		if errors.As(err, &ghErr) && ghErr.Response.StatusCode == http.StatusNotFound {
+			// The commit is gone or the file was renamed since
+			return nil
+		}
+		return fmt.Errorf("failed to fetch workflow file: %w", err)
+	}
+	if file == nil {
+		return fmt.Errorf("%s is a directory", workflow.GetPath())
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return fmt.Errorf("failed to decode workflow file: %w", err)
+	}
+
+	definition := &models.WorkflowDefinition{
+		WorkflowID:   workflow.GetID(),
+		RepositoryID: repo.ID,
+		Connection:   repo.Connection,
+		ContentSHA:   file.GetSHA(),
+		Path:         workflow.GetPath(),
+		CommitSHA:    run.GetHeadSHA(),
+		FirstSeenAt:  run.GetCreatedAt().Time,
+		Content:      content,
+	}
+	definition.Definition, err = definitions.Parse([]byte(content))
+	if err != nil {
+		definition.ParseError = err.Error()
+	}
+	return database.SaveWorkflowDefinition(definition)
+}
@@ -0,0 +1,184 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/discovery"
+)
+
+// defaultDiscoveryInterval is used when no discovery interval is configured.
+const defaultDiscoveryInterval = time.Hour
+
+// Discoverer periodically lists the repositories of organizations with
+// discovery rules, monitors the ones that match and stops monitoring the
+// ones it discovered earlier that no longer match.
+type Discoverer struct {
+	db          *db.Database
+	connections *Connections
+	interval    time.Duration
+	rules       []discovery.Rule
+	stopChan    chan struct{}
+}
+
+// organizationKey identifies an organization on a connection.
+type organizationKey struct {
+	connection string
+	org        string
+}
+
+// NewDiscoverer creates a Discoverer using the given connections and the
+// rules of the configuration, in addition to the rules stored in the
+// database.
+func NewDiscoverer(db *db.Database, connections *Connections, cfg config.DiscoveryConfig) *Discoverer {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+
+	var rules []discovery.Rule
+	for _, ruleConfig := range cfg.Rules {
+		rule := discovery.FromConfig(ruleConfig)
+		if err := rule.Validate(); err != nil {
+			log.Printf("Ignoring discovery rule for %q: %v", rule.Organization, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	return &Discoverer{
+		db:          db,
+		connections: connections,
+		interval:    interval,
+		rules:       rules,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start runs discovery now and then every interval in the background.
+func (d *Discoverer) Start() {
+	ticker := time.NewTicker(d.interval)
+	go func() {
+		d.Discover(context.Background())
+		for {
+			select {
+			case <-ticker.C:
+				d.Discover(context.Background())
+			case <-d.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the discoverer.
+func (d *Discoverer) Stop() {
+	close(d.stopChan)
+}
+
+// Discover applies the discovery rules to every organization that has any,
+// and stops monitoring the discovered repositories of organizations that
+// no longer have any.
+func (d *Discoverer) Discover(ctx context.Context) {
+	var stored []models.DiscoveryRule
+	if err := d.db.Conn.WithContext(ctx).Find(&stored).Error; err != nil {
+		log.Printf("Error loading discovery rules: %v", err)
+		return
+	}
+
+	rules := append([]discovery.Rule{}, d.rules...)
+	for _, model := range stored {
+		rules = append(rules, discovery.FromModel(model))
+	}
+
+	// Group rules by connection and organization, ignoring the case of the
+	// organization's login
+	orgs := make(map[organizationKey][]discovery.Rule)
+	for _, rule := range rules {
+		key := organizationKey{connection: rule.Connection, org: strings.ToLower(rule.Organization)}
+		if key.connection == "" {
+			key.connection = config.DefaultConnection
+		}
+		orgs[key] = append(orgs[key], rule)
+	}
+
+	for key, rules := range orgs {
+		if err := d.discoverOrganization(ctx, key.connection, key.org, rules); err != nil {
+			log.Printf("Error discovering repositories of %s on %s: %v", key.org, key.connection, err)
+		}
+	}
+
+	// Organizations whose last rule was deleted keep their discovered
+	// repositories until they are unmonitored here
+	discovered, err := d.db.GetDiscoveredRepositories()
+	if err != nil {
+		log.Printf("Error loading discovered repositories: %v", err)
+		return
+	}
+	orphaned := make(map[organizationKey]bool)
+	for _, repo := range discovered {
+		key := organizationKey{connection: repo.Connection, org: strings.ToLower(repo.OwnerLogin())}
+		if _, ok := orgs[key]; !ok {
+			orphaned[key] = true
+		}
+	}
+	for key := range orphaned {
+		removed, err := d.db.UnmonitorDiscoveredRepositories(key.connection, key.org, nil)
+		if err != nil {
+			log.Printf("Error unmonitoring repositories of %s on %s: %v", key.org, key.connection, err)
+			continue
+		}
+		log.Printf("Stopped monitoring %d repositories of %s on %s, which has no discovery rules", removed, key.org, key.connection)
+	}
+}
+
+func (d *Discoverer) discoverOrganization(ctx context.Context, connectionName, org string, rules []discovery.Rule) error {
+	connection, err := d.connections.Get(connectionName)
+	if err != nil {
+		return err
+	}
+
+	opt := &gh.RepositoryListByOrgOptions{
+		Type:        "all",
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+
+	var matched []uint
+	for {
+		repos, resp, err := connection.Client.ghClient.Repositories.ListByOrg(ctx, org, opt)
+		if err != nil {
+			// Without the full list, removed repositories can't be told
+			// apart, so nothing is unmonitored.
+			return fmt.Errorf("failed to list repositories: %w", err)
+		}
+		for _, repo := range repos {
+			if !discovery.MatchesAny(rules, repo) {
+				continue
+			}
+			repository, err := d.db.MonitorDiscoveredRepository(connectionName, repo)
+			if err != nil {
+				return fmt.Errorf("failed to save repository %s: %w", repo.GetFullName(), err)
+			}
+			matched = append(matched, repository.ID)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	removed, err := d.db.UnmonitorDiscoveredRepositories(connectionName, org, matched)
+	if err != nil {
+		return fmt.Errorf("failed to unmonitor repositories: %w", err)
+	}
+	log.Printf("Discovered %d repositories of %s on %s, stopped monitoring %d", len(matched), org, connectionName, removed)
+	return nil
+}
@@ -2,6 +2,7 @@ package github
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -9,137 +10,260 @@ import (
 	gh "github.com/google/go-github/v50/github"
 	"github.com/moosh3/github-actions-aggregator/pkg/db"
 	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
-	"golang.org/x/oauth2"
 )
 
 const maxConcurrentPolls = 10
 
-// Poller represents a GitHub poller that periodically fetches workflow information.
+// defaultPollInterval is used when no polling interval is configured.
+const defaultPollInterval = 5 * time.Minute
+
+// schedulerTick is how often the poller looks for repositories that are due.
+const schedulerTick = 10 * time.Second
+
+// Poller represents a GitHub poller that periodically fetches workflow
+// information. Each repository is polled on its own schedule, which adapts
+// to how active the repository is.
 type Poller struct {
-	db       *db.Database
-	ghClient *gh.Client
-	interval time.Duration
+	db              *db.Database
+	connections     *Connections
+	interval        time.Duration
+	dormantInterval time.Duration
+	sem             chan struct{}
+
+	mu      sync.Mutex
+	syncing map[uint]bool
 }
 
-// NewPoller creates a new Poller instance with the given database, OAuth token, and polling interval.
-func NewPoller(db *db.Database, token *oauth2.Token, interval time.Duration) *Poller {
-	ts := oauth2.StaticTokenSource(token)
-	tc := oauth2.NewClient(context.Background(), ts)
-	client := gh.NewClient(tc)
+// NewPoller creates a new Poller instance with the given database, GitHub
+// connections, and the polling intervals of active and dormant repositories.
+func NewPoller(db *db.Database, connections *Connections, interval, dormantInterval time.Duration) *Poller {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	if dormantInterval <= 0 {
+		dormantInterval = defaultDormantPollInterval
+	}
 
 	return &Poller{
-		db:       db,
-		ghClient: client,
-		interval: interval,
+		db:              db,
+		connections:     connections,
+		interval:        interval,
+		dormantInterval: dormantInterval,
+		sem:             make(chan struct{}, maxConcurrentPolls),
+		syncing:         make(map[uint]bool),
 	}
 }
 
-// Start begins the polling process, periodically calling pollRepositories based on the set interval.
+// Start begins the polling process, syncing monitored repositories as their
+// next poll becomes due.
 func (p *Poller) Start() {
-	ticker := time.NewTicker(p.interval)
+	ticker := time.NewTicker(schedulerTick)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			p.pollRepositories()
+			p.pollDueRepositories()
 		}
 	}
 }
 
-// pollRepositories fetches all repositories accessible to the authenticated user and polls their workflows concurrently.
-func (p *Poller) pollRepositories() {
-	opt := &gh.RepositoryListOptions{
-		ListOptions: gh.ListOptions{PerPage: 10}, // Adjust per page as needed
+// pollDueRepositories starts syncing the monitored repositories whose next
+// poll is due and that are not being synced already.
+func (p *Poller) pollDueRepositories() {
+	repos, err := p.db.GetDueRepositories(time.Now())
+	if err != nil {
+		log.Printf("Error loading monitored repositories: %v", err)
+		return
 	}
 
-	var allRepos []*gh.Repository
-	for {
-		repos, resp, err := p.ghClient.Repositories.List(context.Background(), "", opt)
-		if err != nil {
-			log.Printf("Error fetching repositories: %v", err)
-			return
+	// Pause polling of connections whose host is failing
+	paused := make(map[string]bool)
+	for _, connection := range p.connections.All() {
+		if !connection.Available() {
+			log.Printf("Pausing polling of %s: GitHub at %s is failing", connection.Name, connection.Host())
+			paused[connection.Name] = true
 		}
-		allRepos = append(allRepos, repos...)
-		if resp.NextPage == 0 {
-			break
+	}
+
+	for _, repo := range repos {
+		if paused[repo.Connection] {
+			continue
 		}
-		opt.Page = resp.NextPage
-	}
-
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, maxConcurrentPolls)
-
-	for _, repo := range allRepos {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(repo *gh.Repository) {
-			defer wg.Done()
-			dbRepo := models.Repository{
-				Owner: models.GitHubUser{
-					Email: func(email *string) string {
-						if email != nil {
-							return *email
-						}
-						return ""
-					}(repo.Owner.Email),
-				},
-				Name: *repo.Name, // Dereference the pointer
-			}
-			err := p.db.SaveRepository(&dbRepo)
-			if err != nil {
-				log.Printf("Error saving repository %s: %v", repo.Name, err)
-			}
-			<-sem
-		}(repo)
+		p.startSync(context.Background(), repo)
+	}
+}
+
+// SyncNow starts syncing a repository in the background ahead of its
+// schedule, with high priority. It returns false if the repository is
+// already being synced.
+func (p *Poller) SyncNow(repo models.Repository) bool {
+	return p.startSync(WithPriority(context.Background(), PriorityHigh), repo)
+}
+
+func (p *Poller) startSync(ctx context.Context, repo models.Repository) bool {
+	p.mu.Lock()
+	if p.syncing[repo.ID] {
+		p.mu.Unlock()
+		return false
 	}
+	p.syncing[repo.ID] = true
+	p.mu.Unlock()
 
-	wg.Wait()
+	go func() {
+		p.sem <- struct{}{}
+		defer func() {
+			<-p.sem
+			p.mu.Lock()
+			delete(p.syncing, repo.ID)
+			p.mu.Unlock()
+		}()
+		if err := p.SyncRepository(ctx, repo); err != nil {
+			log.Printf("Error syncing repository %s: %v", repo.FullName, err)
+		}
+	}()
+	return true
 }
 
-// pollWorkflows fetches and processes all workflows for a given repository.
-func (p *Poller) pollWorkflows(repo models.Repository) {
-	owner := string(repo.Owner.Email)
-	repoName := repo.Name
+// schedule returns the polling schedule of a repository. Its polling
+// interval, if set, replaces the global one for active repositories.
+func (p *Poller) schedule(repo models.Repository) Schedule {
+	schedule := Schedule{Active: p.interval, Dormant: p.dormantInterval}
+	if repo.PollIntervalSeconds > 0 {
+		schedule.Active = time.Duration(repo.PollIntervalSeconds) * time.Second
+	}
+	return schedule
+}
 
-	// List workflows
-	workflows, _, err := p.ghClient.Actions.ListWorkflows(context.Background(), owner, repoName, nil)
+// scheduleNext records the poll of a repository and schedules the next one
+// from its activity.
+func (p *Poller) scheduleNext(repo models.Repository, polledAt time.Time) {
+	now := time.Now()
+	lastRun, unfinished, err := p.db.GetRepositoryActivity(repo.Connection, repo.RepoID, unfinishedStatuses, now.Add(-stalledRunAge))
 	if err != nil {
-		log.Printf("Error listing workflows for %s/%s: %v", owner, repoName, err)
-		return
+		log.Printf("Error loading activity of repository %s: %v", repo.FullName, err)
 	}
+	lastActivity := later(lastRun, repo.PushedAt)
 
-	for _, workflow := range workflows.Workflows {
-		p.pollWorkflowRuns(owner, repoName, workflow)
+	next := p.schedule(repo).Next(lastActivity, unfinished, now)
+	if err := p.db.ScheduleRepositoryPoll(repo.ID, polledAt, next); err != nil {
+		log.Printf("Error scheduling repository %s: %v", repo.FullName, err)
+	}
+}
+
+// SyncRepository fetches the workflows of a repository and the runs of its
+// tracked workflows, skipping runs on ignored branches, and schedules its
+// next poll. The repository's last push is refreshed for the schedule.
+func (p *Poller) SyncRepository(ctx context.Context, repo models.Repository) error {
+	polledAt := time.Now()
+	defer func() { p.scheduleNext(repo, polledAt) }()
+
+	connection, err := p.connections.Get(repo.Connection)
+	if err != nil {
+		return err
+	}
+	client := connection.Client.ghClient
+	owner, repoName := repo.OwnerLogin(), repo.Name
+
+	latest, _, err := client.Repositories.Get(ctx, owner, repoName)
+	if err != nil {
+		log.Printf("Error fetching repository %s: %v", repo.FullName, err)
+	} else if pushedAt := latest.GetPushedAt().Time; pushedAt.After(repo.PushedAt) {
+		if err := p.db.UpdateRepositoryPushedAt(repo.Connection, repo.RepoID, pushedAt); err != nil {
+			log.Printf("Error saving last push of repository %s: %v", repo.FullName, err)
+		}
+		repo.PushedAt = pushedAt
+	}
+
+	workflows, err := listWorkflows(ctx, client, owner, repoName)
+	if err != nil {
+		return fmt.Errorf("failed to list workflows for %s: %w", repo.FullName, err)
+	}
+
+	for _, workflow := range workflows {
+		if err := p.db.SaveRepositoryWorkflow(repo, workflow); err != nil {
+			log.Printf("Error saving workflow %s of %s: %v", workflow.GetPath(), repo.FullName, err)
+		}
+		if repo.TracksWorkflow(workflow.GetPath(), workflow.GetName()) {
+			p.pollWorkflowRuns(ctx, client, repo, workflow)
+		}
+	}
+	return nil
+}
+
+// listWorkflows fetches every page of a repository's workflows.
+func listWorkflows(ctx context.Context, client *gh.Client, owner, repo string) ([]*gh.Workflow, error) {
+	opts := &gh.ListOptions{PerPage: 100}
+	var all []*gh.Workflow
+	for {
+		workflows, resp, err := client.Actions.ListWorkflows(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, workflows.Workflows...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
 	}
 }
 
 // pollWorkflowRuns fetches and saves the runs for a specific workflow.
-func (p *Poller) pollWorkflowRuns(owner string, repoName string, workflow *gh.Workflow) {
+func (p *Poller) pollWorkflowRuns(ctx context.Context, client *gh.Client, repo models.Repository, workflow *gh.Workflow) {
+	owner, repoName := repo.OwnerLogin(), repo.Name
 	opts := &gh.ListWorkflowRunsOptions{
 		ListOptions: gh.ListOptions{PerPage: 50},
 	}
 
-	runs, _, err := p.ghClient.Actions.ListWorkflowRunsByID(context.Background(), owner, repoName, *workflow.ID, opts)
+	runs, _, err := client.Actions.ListWorkflowRunsByID(ctx, owner, repoName, *workflow.ID, opts)
 	if err != nil {
 		log.Printf("Error listing workflow runs for %s/%s (Workflow ID: %d): %v", owner, repoName, *workflow.ID, err)
 		return
 	}
 
+	var saved []*gh.WorkflowRun
 	for _, run := range runs.WorkflowRuns {
-		// Save or update workflow run in the database
-		err := p.db.SaveWorkflowRun(run)
+		if repo.IgnoresBranch(run.GetHeadBranch()) {
+			continue
+		}
+		current, err := p.db.IsWorkflowRunCurrent(repo.Connection, run)
 		if err != nil {
+			log.Printf("Error checking workflow run ID %d: %v", *run.ID, err)
+		}
+		// Save or update workflow run in the database. Jobs only change
+		// while the run does, so they are skipped for unchanged runs
+		if err := saveWorkflowRun(ctx, p.db, client, repo, run, !current); err != nil {
 			log.Printf("Error saving workflow run ID %d: %v", *run.ID, err)
+			continue
 		}
+		saved = append(saved, run)
 	}
+
+	snapshotWorkflowDefinitions(ctx, p.db, client, repo, workflow, saved)
 }
 
-// handleRateLimit checks the rate limit from the GitHub API response and waits if the limit is exceeded.
-func (p *Poller) handleRateLimit(resp *gh.Response) {
-	if resp.Rate.Remaining == 0 {
-		resetTime := time.Until(resp.Rate.Reset.Time)
-		log.Printf("Rate limit exceeded. Waiting for %v", resetTime)
-		time.Sleep(resetTime)
+// syncWorkflowJobs fetches and saves the jobs and steps of every attempt of
+// a run.
+func syncWorkflowJobs(ctx context.Context, database *db.Database, client *gh.Client, repo models.Repository, runID int64) error {
+	owner, repoName := repo.OwnerLogin(), repo.Name
+	opts := &gh.ListWorkflowJobsOptions{
+		Filter:      "all",
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+
+	for {
+		jobs, resp, err := client.Actions.ListWorkflowJobs(ctx, owner, repoName, runID, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list jobs: %w", err)
+		}
+		for _, job := range jobs.Jobs {
+			if err := database.SaveWorkflowJob(repo.Connection, job); err != nil {
+				return fmt.Errorf("failed to save workflow job ID %d: %w", job.GetID(), err)
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
 	}
 }
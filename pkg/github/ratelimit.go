@@ -0,0 +1,234 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultBackgroundFraction is used when no valid fraction is configured.
+const defaultBackgroundFraction = 0.5
+
+// Priority orders requests competing for a rate-limit budget.
+type Priority int
+
+const (
+	// PriorityBackground is used for polling and discovery. These requests
+	// are limited to a fraction of the hourly budget and spread over the
+	// rate-limit window.
+	PriorityBackground Priority = iota
+	// PriorityHigh is used for fetches triggered by webhooks and users. They
+	// may use the whole budget and are never paced.
+	PriorityHigh
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose GitHub requests use the priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	return priority
+}
+
+// Budget is a snapshot of the rate limit of one token.
+type Budget struct {
+	Key       string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	// BlockedUntil is set when GitHub asked to back off with Retry-After.
+	BlockedUntil time.Time
+}
+
+// Governor tracks the GitHub rate limit of every token from the
+// X-RateLimit-* response headers and delays requests so background work
+// stays within its share of the budget.
+type Governor struct {
+	backgroundFraction float64
+
+	mu      sync.Mutex
+	budgets map[string]*budget
+}
+
+// NewGovernor creates a Governor that lets background requests use at most
+// backgroundFraction of each hourly budget.
+func NewGovernor(backgroundFraction float64) *Governor {
+	if backgroundFraction <= 0 || backgroundFraction > 1 {
+		backgroundFraction = defaultBackgroundFraction
+	}
+	return &Governor{
+		backgroundFraction: backgroundFraction,
+		budgets:            make(map[string]*budget),
+	}
+}
+
+// Transport wraps base so requests made through it draw from the budget of
+// key, which identifies the token they are authenticated with.
+func (g *Governor) Transport(key string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &governedTransport{base: base, budget: g.budget(key), fraction: g.backgroundFraction}
+}
+
+// Budget returns the last known rate limit of key.
+func (g *Governor) Budget(key string) Budget {
+	b := g.budget(key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Budget{Key: key, Limit: b.limit, Remaining: b.remaining, Reset: b.reset, BlockedUntil: b.blockedUntil}
+}
+
+// Budgets returns the last known rate limit of every token.
+func (g *Governor) Budgets() []Budget {
+	g.mu.Lock()
+	keys := make([]string, 0, len(g.budgets))
+	for key := range g.budgets {
+		keys = append(keys, key)
+	}
+	g.mu.Unlock()
+
+	budgets := make([]Budget, 0, len(keys))
+	for _, key := range keys {
+		budgets = append(budgets, g.Budget(key))
+	}
+	return budgets
+}
+
+func (g *Governor) budget(key string) *budget {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.budgets[key]
+	if !ok {
+		b = &budget{}
+		g.budgets[key] = b
+	}
+	return b
+}
+
+type budget struct {
+	mu             sync.Mutex
+	limit          int
+	remaining      int
+	reset          time.Time
+	blockedUntil   time.Time
+	nextBackground time.Time
+}
+
+// reserve takes one request from the budget and returns how long to wait
+// before sending it.
+func (b *budget) reserve(now time.Time, priority Priority, fraction float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := now
+	if b.blockedUntil.After(start) {
+		start = b.blockedUntil
+	}
+	if b.limit == 0 {
+		// Nothing is known until the first response
+		return start.Sub(now)
+	}
+	if !now.Before(b.reset) {
+		// The window has been reset since the last response
+		b.remaining = b.limit
+		b.reset = now.Add(time.Hour)
+	}
+	if b.remaining <= 0 {
+		return later(start, b.reset).Sub(now)
+	}
+
+	if priority == PriorityHigh {
+		b.remaining--
+		return start.Sub(now)
+	}
+
+	// Background requests leave the rest of the budget to high priority
+	// requests and are spread evenly over what is left of the window.
+	reserved := int(float64(b.limit) * (1 - fraction))
+	available := b.remaining - reserved
+	if available <= 0 {
+		return later(start, b.reset).Sub(now)
+	}
+	pace := b.reset.Sub(now) / time.Duration(available)
+	start = later(start, b.nextBackground)
+	b.nextBackground = start.Add(pace)
+	b.remaining--
+	return start.Sub(now)
+}
+
+// update records the rate limit reported by a response.
+func (b *budget) update(resp *http.Response, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" &&
+		(resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) {
+		// Secondary rate limit
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			b.blockedUntil = later(b.blockedUntil, now.Add(time.Duration(seconds)*time.Second))
+		}
+	}
+
+	// Only the core API limit is tracked; search and GraphQL have their own.
+	if resource := resp.Header.Get("X-RateLimit-Resource"); resource != "" && resource != "core" {
+		return
+	}
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	b.limit = limit
+	b.remaining = remaining
+	b.reset = time.Unix(reset, 0)
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+type governedTransport struct {
+	base     http.RoundTripper
+	budget   *budget
+	fraction float64
+}
+
+func (t *governedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	priority := priorityFromContext(req.Context())
+	if wait := t.budget.reserve(time.Now(), priority, t.fraction); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	// The request timeout only covers sending the request, not the wait
+	startAttemptTimeout(req.Context())
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.budget.update(resp, time.Now())
+	return resp, nil
+}
@@ -0,0 +1,265 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/metrics"
+)
+
+// Defaults used when the reconciliation configuration leaves a setting unset.
+const (
+	defaultReconcileInterval   = time.Hour
+	defaultReconcileWindow     = 24 * time.Hour
+	defaultReconcileStuckAfter = 2 * time.Hour
+	defaultReconcileGrace      = 10 * time.Minute
+)
+
+// unfinishedStatuses are the statuses of runs that have not completed.
+var unfinishedStatuses = []string{"requested", "queued", "pending", "waiting", "in_progress"}
+
+// Drift is how a stored run differs from GitHub.
+type Drift string
+
+const (
+	// DriftNone means the stored run matches GitHub.
+	DriftNone Drift = ""
+	// DriftMissing means GitHub has a run that was never stored.
+	DriftMissing Drift = "missing"
+	// DriftStale means the stored run has an outdated status or conclusion.
+	DriftStale Drift = "stale"
+	// DriftStuck means the stored run has been queued or in progress for
+	// longer than StuckAfter while GitHub has moved on.
+	DriftStuck Drift = "stuck"
+)
+
+// Reconciler periodically compares the recent runs of monitored
+// repositories with GitHub and repairs the ones whose webhooks were missed.
+type Reconciler struct {
+	db          *db.Database
+	connections *Connections
+	cfg         config.ReconciliationConfig
+	stopChan    chan struct{}
+}
+
+// NewReconciler creates a Reconciler using the given connections.
+func NewReconciler(db *db.Database, connections *Connections, cfg config.ReconciliationConfig) *Reconciler {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultReconcileInterval
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultReconcileWindow
+	}
+	if cfg.StuckAfter <= 0 {
+		cfg.StuckAfter = defaultReconcileStuckAfter
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = defaultReconcileGrace
+	}
+
+	return &Reconciler{
+		db:          db,
+		connections: connections,
+		cfg:         cfg,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start runs reconciliation every interval in the background.
+func (r *Reconciler) Start() {
+	ticker := time.NewTicker(r.cfg.Interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.Reconcile(context.Background())
+			case <-r.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the reconciler.
+func (r *Reconciler) Stop() {
+	close(r.stopChan)
+}
+
+// Reconcile compares the runs of every monitored repository with GitHub,
+// skipping connections whose host is failing.
+func (r *Reconciler) Reconcile(ctx context.Context) {
+	repos, err := r.db.GetRepositories()
+	if err != nil {
+		log.Printf("Error loading monitored repositories: %v", err)
+		return
+	}
+
+	for _, repo := range repos {
+		connection, err := r.connections.Get(repo.Connection)
+		if err != nil {
+			log.Printf("Error reconciling repository %s: %v", repo.FullName, err)
+			continue
+		}
+		if !connection.Available() {
+			continue
+		}
+
+		drift, err := r.reconcileRepository(ctx, connection, repo)
+		if err != nil {
+			log.Printf("Error reconciling repository %s: %v", repo.FullName, err)
+		}
+		for kind, count := range drift {
+			metrics.ReconciliationDrift.WithLabelValues(connection.Name, string(kind)).Add(float64(count))
+		}
+		if len(drift) > 0 {
+			log.Printf("Repaired runs of %s on %s: %d missing, %d stale, %d stuck", repo.FullName, connection.Name,
+				drift[DriftMissing], drift[DriftStale], drift[DriftStuck])
+		}
+	}
+}
+
+// Compare reports how the stored latest attempt of a run differs from the
+// run on GitHub: its status, its conclusion, or a newer attempt on GitHub.
+// stored is nil when the run was never stored. Runs updated within the grace
+// period are not compared, as their webhooks may still arrive.
+func (r *Reconciler) Compare(stored *models.WorkflowRun, run *gh.WorkflowRun, now time.Time) Drift {
+	if now.Sub(run.GetUpdatedAt().Time) < r.cfg.Grace {
+		return DriftNone
+	}
+	if stored == nil {
+		return DriftMissing
+	}
+	if stored.RunAttempt >= run.GetRunAttempt() &&
+		stored.Status == run.GetStatus() && stored.Conclusion == run.GetConclusion() {
+		return DriftNone
+	}
+	if isUnfinished(stored.Status) && now.Sub(stored.UpdatedAt) >= r.cfg.StuckAfter {
+		return DriftStuck
+	}
+	return DriftStale
+}
+
+// reconcileRepository repairs the runs of a repository that differ from
+// GitHub and returns how many of each kind it found.
+func (r *Reconciler) reconcileRepository(ctx context.Context, connection *Connection, repo models.Repository) (map[Drift]int, error) {
+	client := connection.Client.ghClient
+	owner, repoName := repo.OwnerLogin(), repo.Name
+	now := time.Now()
+	since := now.Add(-r.cfg.Window)
+
+	tracked, err := r.trackedWorkflows(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := r.db.GetRecentWorkflowRuns(repo.Connection, repo.RepoID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load runs: %w", err)
+	}
+	storedRuns := make(map[int64]*models.WorkflowRun, len(stored))
+	for i := range stored {
+		storedRuns[stored[i].RunID] = &stored[i]
+	}
+
+	drift := make(map[Drift]int)
+	checked := make(map[int64]bool)
+	opts := &gh.ListWorkflowRunsOptions{
+		Created:     ">=" + since.UTC().Format(time.RFC3339),
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+	for {
+		runs, resp, err := client.Actions.ListRepositoryWorkflowRuns(ctx, owner, repoName, opts)
+		if err != nil {
+			return drift, fmt.Errorf("failed to list workflow runs: %w", err)
+		}
+		for _, run := range runs.WorkflowRuns {
+			checked[run.GetID()] = true
+			if !tracked(run.GetWorkflowID()) || repo.IgnoresBranch(run.GetHeadBranch()) {
+				continue
+			}
+			kind := r.Compare(storedRuns[run.GetID()], run, now)
+			if kind == DriftNone {
+				continue
+			}
+			if err := r.Repair(ctx, client, repo, run); err != nil {
+				log.Printf("Error repairing workflow run ID %d: %v", run.GetID(), err)
+				continue
+			}
+			drift[kind]++
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	// Runs created before the window are not listed, so unfinished ones
+	// that stopped receiving updates are fetched one by one.
+	stuck, err := r.db.GetUnfinishedWorkflowRuns(repo.Connection, repo.RepoID, unfinishedStatuses, now.Add(-r.cfg.StuckAfter))
+	if err != nil {
+		return drift, fmt.Errorf("failed to load unfinished runs: %w", err)
+	}
+	for i := range stuck {
+		if checked[stuck[i].RunID] {
+			continue
+		}
+		run, _, err := client.Actions.GetWorkflowRunByID(ctx, owner, repoName, stuck[i].RunID)
+		if err != nil {
+			log.Printf("Error fetching workflow run ID %d: %v", stuck[i].RunID, err)
+			continue
+		}
+		if r.Compare(&stuck[i], run, now) == DriftNone {
+			continue
+		}
+		if err := r.Repair(ctx, client, repo, run); err != nil {
+			log.Printf("Error repairing workflow run ID %d: %v", run.GetID(), err)
+			continue
+		}
+		drift[DriftStuck]++
+	}
+
+	return drift, nil
+}
+
+// trackedWorkflows returns a function reporting whether the repository
+// tracks a workflow by its GitHub ID. Workflows that have not been stored
+// yet are tracked only when the repository tracks every workflow.
+func (r *Reconciler) trackedWorkflows(repo models.Repository) (func(int64) bool, error) {
+	workflows, err := r.db.GetRepositoryWorkflows(repo.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workflows: %w", err)
+	}
+	tracked := make(map[int64]bool, len(workflows))
+	for _, workflow := range workflows {
+		tracked[workflow.WorkflowID] = repo.TracksWorkflow(workflow.Path, workflow.Name)
+	}
+	return func(workflowID int64) bool {
+		if ok, known := tracked[workflowID]; known {
+			return ok
+		}
+		return len(repo.TrackedWorkflows) == 0
+	}, nil
+}
+
+// Repair saves the authoritative state of a run, its jobs and attempts. The
+// run is left as stored when its jobs can't be fetched, so it is repaired
+// again on the next pass.
+func (r *Reconciler) Repair(ctx context.Context, client *gh.Client, repo models.Repository, run *gh.WorkflowRun) error {
+	return saveWorkflowRun(ctx, r.db, client, repo, run, true)
+}
+
+func isUnfinished(status string) bool {
+	for _, s := range unfinishedStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
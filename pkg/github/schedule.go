@@ -0,0 +1,65 @@
+package github
+
+import (
+	"math/rand"
+	"time"
+)
+
+// defaultDormantPollInterval is used when no dormant polling interval is
+// configured.
+const defaultDormantPollInterval = time.Hour
+
+const (
+	// idleFactor slows polling down as a repository stays idle: the interval
+	// is the idle time divided by idleFactor, so a repository idle for six
+	// hours is polled every 30 minutes.
+	idleFactor = 12
+	// pollJitter spreads polls by up to this fraction of the interval in
+	// either direction, so repositories monitored together aren't polled
+	// together.
+	pollJitter = 0.1
+	// stalledRunAge is how long an unfinished run may go without updates
+	// before it stops counting as activity.
+	stalledRunAge = 2 * time.Hour
+)
+
+// Schedule computes when a repository is polled next from its activity.
+// Repositories with unfinished runs or recent activity are polled every
+// Active; the interval then grows with the time since the last activity
+// until it reaches Dormant.
+type Schedule struct {
+	Active  time.Duration
+	Dormant time.Duration
+}
+
+// Interval returns the polling interval of a repository last active at
+// lastActivity, without jitter.
+func (s Schedule) Interval(lastActivity time.Time, unfinished bool, now time.Time) time.Duration {
+	dormant := s.Dormant
+	if dormant < s.Active {
+		dormant = s.Active
+	}
+	if unfinished {
+		return s.Active
+	}
+	if lastActivity.IsZero() {
+		return dormant
+	}
+
+	interval := now.Sub(lastActivity) / idleFactor
+	if interval < s.Active {
+		return s.Active
+	}
+	if interval > dormant {
+		return dormant
+	}
+	return interval
+}
+
+// Next returns when a repository last active at lastActivity is polled
+// next, with jitter.
+func (s Schedule) Next(lastActivity time.Time, unfinished bool, now time.Time) time.Time {
+	interval := s.Interval(lastActivity, unfinished, now)
+	jitter := time.Duration((rand.Float64()*2 - 1) * pollJitter * float64(interval))
+	return now.Add(interval + jitter)
+}
@@ -0,0 +1,386 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/metrics"
+)
+
+// Defaults used when the HTTP configuration leaves a setting unset.
+const (
+	defaultRequestTimeout   = 30 * time.Second
+	defaultMaxRetries       = 3
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+
+	baseRetryBackoff = 200 * time.Millisecond
+	maxRetryBackoff  = 5 * time.Second
+)
+
+// ErrCircuitOpen is returned for requests to a GitHub host whose circuit
+// breaker is open because the host has been failing.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// errAttemptTimeout cancels a request attempt that took longer than the
+// per-request timeout.
+var errAttemptTimeout = errors.New("GitHub request timed out")
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerHalfOpen = "half-open"
+	BreakerOpen     = "open"
+)
+
+var (
+	transportMu     sync.Mutex
+	transportConfig = config.GitHubHTTPConfig{}
+	breakers        = make(map[string]*Breaker)
+)
+
+// ConfigureTransport sets the timeout, retry and circuit breaker settings
+// of clients created afterwards.
+func ConfigureTransport(cfg config.GitHubHTTPConfig) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	transportConfig = cfg
+}
+
+// BreakerStates returns the circuit breaker state of every GitHub host that
+// has been requested.
+func BreakerStates() map[string]string {
+	transportMu.Lock()
+	hosts := make(map[string]*Breaker, len(breakers))
+	for host, breaker := range breakers {
+		hosts[host] = breaker
+	}
+	transportMu.Unlock()
+
+	states := make(map[string]string, len(hosts))
+	for host, breaker := range hosts {
+		states[host] = breaker.State()
+	}
+	return states
+}
+
+// breakerFor returns the circuit breaker of a host, shared by every client.
+func breakerFor(host string) *Breaker {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	breaker, ok := breakers[host]
+	if !ok {
+		threshold := transportConfig.BreakerThreshold
+		if threshold <= 0 {
+			threshold = defaultBreakerThreshold
+		}
+		cooldown := transportConfig.BreakerCooldown
+		if cooldown <= 0 {
+			cooldown = defaultBreakerCooldown
+		}
+		breaker = NewBreaker(host, threshold, cooldown)
+		breakers[host] = breaker
+	}
+	return breaker
+}
+
+// Breaker is a circuit breaker for one host. It opens after threshold
+// consecutive failures, rejects requests for cooldown and then lets a
+// single trial request through, closing again if it succeeds.
+type Breaker struct {
+	host      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	state    string
+	trial    bool
+}
+
+// NewBreaker creates a closed circuit breaker.
+func NewBreaker(host string, threshold int, cooldown time.Duration) *Breaker {
+	b := &Breaker{host: host, threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+	metrics.GitHubCircuitState.WithLabelValues(host).Set(metrics.CircuitClosed)
+	return b
+}
+
+// State returns the breaker's state, moving an open breaker whose cooldown
+// has passed to half-open.
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkCooldown(time.Now())
+	return b.state
+}
+
+// Allow reports whether a request may be sent. In the half-open state only
+// one trial request is allowed at a time.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkCooldown(time.Now())
+
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// Success records a successful request and closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+	b.setState(BreakerClosed)
+}
+
+// Abandon records a request that ended without telling whether the host
+// works, such as one cancelled by the caller. A half-open breaker lets
+// another trial request through.
+func (b *Breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Failure records a failed request, opening the breaker after too many.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+func (b *Breaker) checkCooldown(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.cooldown {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(state string) {
+	b.state = state
+	value := metrics.CircuitClosed
+	switch state {
+	case BreakerHalfOpen:
+		value = metrics.CircuitHalfOpen
+	case BreakerOpen:
+		value = metrics.CircuitOpen
+	}
+	metrics.GitHubCircuitState.WithLabelValues(b.host).Set(float64(value))
+}
+
+// NewResilientTransport wraps base with a per-request timeout, retries of
+// idempotent requests on network errors and 5xx responses with jittered
+// exponential backoff, and a circuit breaker per host.
+func NewResilientTransport(base http.RoundTripper, cfg config.GitHubHTTPConfig) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRequestTimeout
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	return &resilientTransport{base: base, cfg: cfg}
+}
+
+func newDefaultResilientTransport(base http.RoundTripper) http.RoundTripper {
+	transportMu.Lock()
+	cfg := transportConfig
+	transportMu.Unlock()
+	return NewResilientTransport(base, cfg)
+}
+
+type resilientTransport struct {
+	base http.RoundTripper
+	cfg  config.GitHubHTTPConfig
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	breaker := breakerFor(host)
+
+	retries := 0
+	if isIdempotent(req) {
+		retries = t.cfg.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !breaker.Allow() {
+			metrics.GitHubRequests.WithLabelValues(host, "circuit_open").Inc()
+			return nil, ErrCircuitOpen
+		}
+
+		resp, err := t.attempt(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			breaker.Success()
+			metrics.GitHubRequests.WithLabelValues(host, strconv.Itoa(resp.StatusCode)).Inc()
+			return resp, nil
+		}
+		if err != nil && req.Context().Err() != nil {
+			// The caller gave up, possibly while the request was held
+			// back by the rate limit governor; that's not the host's fault
+			breaker.Abandon()
+			metrics.GitHubRequests.WithLabelValues(host, "cancelled").Inc()
+			return nil, err
+		}
+
+		breaker.Failure()
+		if err != nil {
+			metrics.GitHubRequests.WithLabelValues(host, "error").Inc()
+		} else {
+			metrics.GitHubRequests.WithLabelValues(host, strconv.Itoa(resp.StatusCode)).Inc()
+		}
+
+		if attempt >= retries || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), backoff(attempt)); err != nil {
+			return nil, err
+		}
+		metrics.GitHubRetries.WithLabelValues(host).Inc()
+	}
+}
+
+// attempt sends one try of the request with the per-request timeout. The
+// timeout covers reading the body, so it is only released when the body is
+// closed. When the base transport is the rate limit governor, the timeout
+// starts once the governor lets the request through, so time spent waiting
+// for the rate limit doesn't count.
+func (t *resilientTransport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timeout := &attemptTimeout{timeout: t.cfg.Timeout, cancel: cancel}
+	try := req.Clone(context.WithValue(ctx, attemptTimeoutKey{}, timeout))
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			timeout.stop()
+			return nil, err
+		}
+		try.Body = body
+	}
+
+	if _, governed := t.base.(*governedTransport); !governed {
+		timeout.start()
+	}
+	resp, err := t.base.RoundTrip(try)
+	if err != nil {
+		timedOut := errors.Is(context.Cause(ctx), errAttemptTimeout)
+		timeout.stop()
+		if timedOut {
+			return nil, fmt.Errorf("%w: %v", errAttemptTimeout, err)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: timeout.stop}
+	return resp, nil
+}
+
+type attemptTimeoutKey struct{}
+
+// attemptTimeout cancels a request attempt once it has been sent for longer
+// than timeout. The timer only runs from start until stop.
+type attemptTimeout struct {
+	timeout time.Duration
+	cancel  context.CancelCauseFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func (a *attemptTimeout) start() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.timer == nil && !a.stopped {
+		a.timer = time.AfterFunc(a.timeout, func() { a.cancel(errAttemptTimeout) })
+	}
+}
+
+func (a *attemptTimeout) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = true
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.cancel(nil)
+}
+
+// startAttemptTimeout starts the timeout of the request attempt in ctx, if
+// it has one. Transports that hold requests back call it when they send
+// the request.
+func startAttemptTimeout(ctx context.Context) {
+	if timeout, ok := ctx.Value(attemptTimeoutKey{}).(*attemptTimeout); ok {
+		timeout.start()
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// isIdempotent reports whether a request can safely be sent again.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+// backoff returns a random delay of up to baseRetryBackoff doubled per
+// attempt, capped at maxRetryBackoff.
+func backoff(attempt int) time.Duration {
+	max := baseRetryBackoff << attempt
+	if max > maxRetryBackoff || max <= 0 {
+		max = maxRetryBackoff
+	}
+	return time.Duration(rand.Int63n(int64(max))) + time.Millisecond
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,42 +1,58 @@
 package github
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-github/v50/github"
 	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/events"
+	"github.com/moosh3/github-actions-aggregator/pkg/tracing"
 	"github.com/moosh3/github-actions-aggregator/pkg/worker"
+	"gorm.io/gorm"
 )
 
 // WebhookHandler handles GitHub webhook events.
 type WebhookHandler struct {
-	db       *db.Database
-	client   *Client
-	whSecret []byte
-	worker   *worker.WorkerPool
+	db         *db.Database
+	client     *Client
+	connection string
+	whSecret   []byte
+	worker     *worker.WorkerPool
+	tracer     *tracing.Exporter
+	broker     *events.Broker
 }
 
 // NewWebhookHandler creates a new WebhookHandler instance.
 //
 // Parameters:
 //   - db: A pointer to the database instance.
-//   - client: A pointer to the GitHub client.
-//   - secret: The webhook secret used for signature verification.
+//   - connection: The GitHub connection the webhook is configured on; its
+//     secret is used for signature verification.
 //   - worker: A pointer to the worker pool.
+//   - tracer: An optional trace exporter for completed runs; may be nil.
+//   - broker: The broker that run and job state changes are published to.
 //
 // Returns:
 //   - A pointer to the new WebhookHandler instance.
-func NewWebhookHandler(db *db.Database, client *Client, secret string, worker *worker.WorkerPool) *WebhookHandler {
+func NewWebhookHandler(db *db.Database, connection *Connection, worker *worker.WorkerPool, tracer *tracing.Exporter, broker *events.Broker) *WebhookHandler {
 	return &WebhookHandler{
-		db:       db,
-		client:   client,
-		whSecret: []byte(secret),
-		worker:   worker,
+		db:         db,
+		client:     connection.Client.WithPriority(PriorityHigh),
+		connection: connection.Name,
+		whSecret:   []byte(connection.WebhookSecret),
+		worker:     worker,
+		tracer:     tracer,
+		broker:     broker,
 	}
 }
 
@@ -71,9 +87,13 @@ func (wh *WebhookHandler) HandleWebhook(c *gin.Context) {
 	// Handle different event types
 	switch e := event.(type) {
 	case *github.WorkflowRunEvent: // WorkflowRunEvent is triggered when a GitHub Actions workflow run is requested or completed.
-		wh.handleWorkflowRunEvent(e)
+		wh.handleWorkflowRunEvent(e, runTriggeringActor(payload))
 	case *github.WorkflowJobEvent: // WorkflowJobEvent is triggered when a job is queued, started or completed.
-		wh.handleWorkflowJobEvent(e)
+		wh.handleWorkflowJobEvent(e, jobHeadBranch(payload))
+	case *github.PullRequestEvent: // PullRequestEvent is triggered when a pull request is opened, updated, closed or merged.
+		wh.handlePullRequestEvent(e)
+	case *github.PushEvent: // PushEvent is triggered when commits are pushed to a branch or tag.
+		wh.handlePushEvent(e)
 
 	default:
 		// Unsupported event type
@@ -107,37 +127,222 @@ func (wh *WebhookHandler) verifySignature(signature string, payload []byte) bool
 //
 // Parameters:
 //   - event: A pointer to the GitHub WorkflowRunEvent.
-func (wh *WebhookHandler) handleWorkflowRunEvent(event *github.WorkflowRunEvent) {
+//   - triggeringActor: The login of the user who started the run's attempt.
+func (wh *WebhookHandler) handleWorkflowRunEvent(event *github.WorkflowRunEvent, triggeringActor string) {
 	action := event.GetAction()
 	workflow := event.GetWorkflow()
 	run := event.GetWorkflowRun()
+	if wh.ignores(event.GetRepo().GetID(), workflow.GetPath(), workflow.GetName(), run.GetHeadBranch()) {
+		return
+	}
 
 	switch action {
 	case "completed":
-		err := wh.db.SaveWorkflow(workflow)
+		err := wh.db.SaveWorkflow(wh.connection, workflow)
 		if err != nil {
 			// Log error
 		}
 		// Save or update the workflow run in the database
-		err = wh.db.SaveWorkflowRun(run)
+		err = wh.db.SaveWorkflowRun(wh.connection, run, triggeringActor)
 		if err != nil {
 			// Log error
 		}
+		// The jobs of the attempt have completed by now
+		if err := wh.db.MarkRerunFailedJobs(wh.connection, run.GetID(), run.GetRunAttempt()); err != nil {
+			log.Printf("Error checking attempt %d of workflow run ID %d: %v", run.GetRunAttempt(), run.GetID(), err)
+		}
 
 		// Enqueue a job to aggregate data after a new run is saved
 		wh.worker.JobQueue <- worker.Job{
 			Type: "aggregate_data",
 		}
 
+		// Export the run as a trace without holding up the webhook response
+		if wh.tracer != nil {
+			go wh.exportRunTrace(run)
+		}
+
+		// Webhooks reach the aggregator before the next poll does
+		go wh.snapshotDefinition(event.GetRepo().GetID(), workflow, run)
+
 	case "requested":
 		// Handle other actions if needed
 	}
+
+	wh.broker.Publish(events.Event{
+		Type:       events.TypeWorkflowRun,
+		Action:     action,
+		Connection: wh.connection,
+		Repository: event.GetRepo().GetFullName(),
+		Workflow:   workflow.GetName(),
+		Branch:     run.GetHeadBranch(),
+		RunID:      run.GetID(),
+		Name:       run.GetName(),
+		Status:     run.GetStatus(),
+		Conclusion: run.GetConclusion(),
+		HTMLURL:    run.GetHTMLURL(),
+	})
+}
+
+// exportRunTrace fetches the jobs of a completed workflow run and exports
+// the run as a trace.
+//
+// Parameters:
+//   - run: A pointer to the completed GitHub WorkflowRun.
+func (wh *WebhookHandler) exportRunTrace(run *github.WorkflowRun) {
+	owner := run.GetRepository().GetOwner().GetLogin()
+	repo := run.GetRepository().GetName()
+
+	jobs, err := wh.client.ListWorkflowJobs(owner, repo, run.GetID())
+	if err != nil {
+		log.Printf("Error listing jobs for workflow run %d: %v", run.GetID(), err)
+		return
+	}
+
+	if err := wh.tracer.ExportRun(context.Background(), run, jobs); err != nil {
+		log.Printf("Error exporting trace for workflow run %d: %v", run.GetID(), err)
+	}
+}
+
+// ignores reports whether the monitoring settings of a stored repository
+// exclude a workflow or branch, as polling does. Repositories that aren't
+// stored have no settings.
+//
+// Parameters:
+//   - repoID: The GitHub ID of the repository.
+//   - workflowPath: The path of the workflow's file, if known.
+//   - workflowName: The name of the workflow.
+//   - branch: The head branch of the run.
+//
+// Returns:
+//   - A boolean indicating whether the event should be skipped.
+func (wh *WebhookHandler) ignores(repoID int64, workflowPath, workflowName, branch string) bool {
+	repo, err := wh.db.GetRepositoryByGitHubID(wh.connection, repoID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading repository ID %d: %v", repoID, err)
+		}
+		return false
+	}
+	return !repo.TracksWorkflow(workflowPath, workflowName) || repo.IgnoresBranch(branch)
+}
+
+// snapshotDefinition stores the version of a workflow's file at the head
+// commit of a run, as polling does, for repositories the aggregator stores.
+//
+// Parameters:
+//   - repoID: The GitHub ID of the run's repository.
+//   - workflow: A pointer to the run's GitHub Workflow.
+//   - run: A pointer to the completed GitHub WorkflowRun.
+func (wh *WebhookHandler) snapshotDefinition(repoID int64, workflow *github.Workflow, run *github.WorkflowRun) {
+	repo, err := wh.db.GetRepositoryByGitHubID(wh.connection, repoID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error loading repository ID %d: %v", repoID, err)
+		}
+		return
+	}
+	snapshotWorkflowDefinitions(wh.client.ctx, wh.db, wh.client.ghClient, repo, workflow, []*github.WorkflowRun{run})
 }
 
-func (wh *WebhookHandler) handleWorkflowJobEvent(event *github.WorkflowJobEvent) {
+// handleWorkflowJobEvent processes GitHub workflow job events.
+//
+// Parameters:
+//   - event: A pointer to the GitHub WorkflowJobEvent.
+//   - branch: The head branch of the job's run.
+func (wh *WebhookHandler) handleWorkflowJobEvent(event *github.WorkflowJobEvent, branch string) {
 	job := event.GetWorkflowJob()
-	err := wh.db.SaveWorkflowJob(job)
+	if wh.ignores(event.GetRepo().GetID(), "", job.GetWorkflowName(), branch) {
+		return
+	}
+	err := wh.db.SaveWorkflowJob(wh.connection, job)
 	if err != nil {
 		// Log error
 	}
+
+	wh.broker.Publish(events.Event{
+		Type:       events.TypeWorkflowJob,
+		Action:     event.GetAction(),
+		Connection: wh.connection,
+		Repository: event.GetRepo().GetFullName(),
+		Workflow:   job.GetWorkflowName(),
+		Branch:     branch,
+		RunID:      job.GetRunID(),
+		JobID:      job.GetID(),
+		Name:       job.GetName(),
+		Status:     job.GetStatus(),
+		Conclusion: job.GetConclusion(),
+		HTMLURL:    job.GetHTMLURL(),
+	})
+}
+
+// handlePullRequestEvent saves the details of a pull request, such as when
+// it was opened and merged, for the CI history of its runs.
+//
+// Parameters:
+//   - event: A pointer to the GitHub PullRequestEvent.
+func (wh *WebhookHandler) handlePullRequestEvent(event *github.PullRequestEvent) {
+	pr := event.GetPullRequest()
+	if err := wh.db.SavePullRequest(wh.connection, event.GetRepo().GetID(), pr); err != nil {
+		log.Printf("Error saving pull request #%d of %s: %v", pr.GetNumber(), event.GetRepo().GetFullName(), err)
+	}
+}
+
+// handlePushEvent records the time of a push to a repository, which keeps
+// its polling schedule active between polls.
+//
+// Parameters:
+//   - event: A pointer to the GitHub PushEvent.
+func (wh *WebhookHandler) handlePushEvent(event *github.PushEvent) {
+	repo := event.GetRepo()
+	pushedAt := repo.GetPushedAt().Time
+	if pushedAt.IsZero() {
+		pushedAt = time.Now()
+	}
+	if err := wh.db.UpdateRepositoryPushedAt(wh.connection, repo.GetID(), pushedAt); err != nil {
+		log.Printf("Error saving push to %s: %v", repo.GetFullName(), err)
+	}
+}
+
+// jobHeadBranch extracts the head branch from a workflow_job payload. The
+// field is not exposed by the go-github WorkflowJob type.
+//
+// Parameters:
+//   - payload: The raw payload of the webhook.
+//
+// Returns:
+//   - The head branch, or an empty string if it is missing.
+func jobHeadBranch(payload []byte) string {
+	var p struct {
+		WorkflowJob struct {
+			HeadBranch string `json:"head_branch"`
+		} `json:"workflow_job"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	return p.WorkflowJob.HeadBranch
+}
+
+// runTriggeringActor extracts the login of the user who started the attempt
+// from a workflow_run payload. The field is not exposed by the go-github
+// WorkflowRun type.
+//
+// Parameters:
+//   - payload: The raw payload of the webhook.
+//
+// Returns:
+//   - The login, or an empty string if it is missing.
+func runTriggeringActor(payload []byte) string {
+	var p struct {
+		WorkflowRun struct {
+			TriggeringActor struct {
+				Login string `json:"login"`
+			} `json:"triggering_actor"`
+		} `json:"workflow_run"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	return p.WorkflowRun.TriggeringActor.Login
 }
@@ -0,0 +1,68 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/introspection"
+	"github.com/graph-gophers/graphql-go/trace/noop"
+)
+
+const (
+	// maxQueryDepth is the deepest selection a query may make. It allows
+	// repositories down to the steps of their jobs, but not much more
+	// cycling between repositories, workflows and runs.
+	maxQueryDepth = 15
+
+	// maxQueryComplexity is the number of records a query may load. Each
+	// database lookup costs one and each loaded record one more, so nested
+	// connections cost the product of their page sizes.
+	maxQueryComplexity = 10000
+)
+
+// schemaOptions are the limits applied to every query.
+func schemaOptions() []graphqlgo.SchemaOpt {
+	return []graphqlgo.SchemaOpt{
+		graphqlgo.MaxDepth(maxQueryDepth),
+		graphqlgo.Tracer(complexityTracer{}),
+	}
+}
+
+// complexityTracer gives each query its own complexity budget.
+type complexityTracer struct {
+	noop.Tracer
+}
+
+func (complexityTracer) TraceQuery(ctx context.Context, queryString string, operationName string, variables map[string]interface{}, varTypes map[string]*introspection.Type) (context.Context, func([]*errors.QueryError)) {
+	ctx = context.WithValue(ctx, budgetContextKey{}, &budget{remaining: maxQueryComplexity})
+	return ctx, func([]*errors.QueryError) {}
+}
+
+type budgetContextKey struct{}
+
+// budget is the complexity a query has left. Fields resolve concurrently,
+// so it is guarded by a mutex.
+type budget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// charge spends cost from the budget of the query in ctx and fails once the
+// budget is exhausted.
+func charge(ctx context.Context, cost int) error {
+	b, ok := ctx.Value(budgetContextKey{}).(*budget)
+	if !ok {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining -= cost
+	if b.remaining < 0 {
+		return fmt.Errorf("query is too complex: it loads more than %d records", maxQueryComplexity)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package graphql
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	cursorPrefix    = "cursor:"
+)
+
+// connectionArgs are the pagination arguments accepted by every connection.
+type connectionArgs struct {
+	First *int32
+	After *string
+}
+
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (r *pageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *pageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
+
+type edgeResolver[T any] struct {
+	cursor string
+	node   T
+}
+
+func (r *edgeResolver[T]) Cursor() string {
+	return r.cursor
+}
+
+func (r *edgeResolver[T]) Node() T {
+	return r.node
+}
+
+type connectionResolver[T any] struct {
+	edges      []*edgeResolver[T]
+	pageInfo   *pageInfoResolver
+	totalCount int32
+}
+
+func (r *connectionResolver[T]) Edges() []*edgeResolver[T] {
+	return r.edges
+}
+
+func (r *connectionResolver[T]) PageInfo() *pageInfoResolver {
+	return r.pageInfo
+}
+
+func (r *connectionResolver[T]) TotalCount() int32 {
+	return r.totalCount
+}
+
+// paginate runs query with keyset pagination on the primary key and wraps
+// each row with newResolver. Rows are returned in ascending ID order and
+// charged to the budget of the query in ctx.
+func paginate[M any, T any](ctx context.Context, query *gorm.DB, args connectionArgs, id func(M) uint, newResolver func(M) T) (*connectionResolver[T], error) {
+	limit := defaultPageSize
+	if args.First != nil {
+		if *args.First < 0 {
+			return nil, fmt.Errorf("first must not be negative")
+		}
+		limit = int(*args.First)
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	page := query.Session(&gorm.Session{})
+	if args.After != nil {
+		afterID, err := decodeCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		page = page.Where("id > ?", afterID)
+	}
+
+	var rows []M
+	if err := page.Order("id").Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	hasNextPage := len(rows) > limit
+	if hasNextPage {
+		rows = rows[:limit]
+	}
+	if err := charge(ctx, len(rows)); err != nil {
+		return nil, err
+	}
+
+	conn := &connectionResolver[T]{
+		edges:      make([]*edgeResolver[T], 0, len(rows)),
+		pageInfo:   &pageInfoResolver{hasNextPage: hasNextPage},
+		totalCount: int32(total),
+	}
+	for _, row := range rows {
+		conn.edges = append(conn.edges, &edgeResolver[T]{
+			cursor: encodeCursor(id(row)),
+			node:   newResolver(row),
+		})
+	}
+	if len(conn.edges) > 0 {
+		endCursor := conn.edges[len(conn.edges)-1].cursor
+		conn.pageInfo.endCursor = &endCursor
+	}
+
+	return conn, nil
+}
+
+// encodeCursor returns an opaque cursor for the given primary key.
+func encodeCursor(id uint) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatUint(uint64(id), 10)))
+}
+
+// decodeCursor returns the primary key encoded in a cursor.
+func decodeCursor(cursor string) (uint, error) {
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), cursorPrefix) {
+		return 0, fmt.Errorf("invalid cursor: %q", cursor)
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(string(b), cursorPrefix), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor: %q", cursor)
+	}
+	return uint(id), nil
+}
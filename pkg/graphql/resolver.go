@@ -0,0 +1,202 @@
+package graphql
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// Resolver is the root resolver of the GraphQL schema.
+type Resolver struct {
+	db *gorm.DB
+}
+
+// NewSchema parses the GraphQL schema and binds it to resolvers backed by db.
+// Queries are limited in depth and in the number of records they load.
+func NewSchema(db *gorm.DB) (*graphqlgo.Schema, error) {
+	return graphqlgo.ParseSchema(schema, &Resolver{db: db}, schemaOptions()...)
+}
+
+// NewHandler returns an HTTP handler that serves GraphQL queries over db.
+// It panics if the schema does not match the resolvers.
+func NewHandler(db *gorm.DB) http.Handler {
+	return &relay.Handler{Schema: graphqlgo.MustParseSchema(schema, &Resolver{db: db}, schemaOptions()...)}
+}
+
+type repositoriesArgs struct {
+	connectionArgs
+	Name     *string
+	Language *string
+	Private  *bool
+}
+
+// Repositories lists repositories, optionally filtered by name, language and
+// visibility.
+func (r *Resolver) Repositories(ctx context.Context, args repositoriesArgs) (*connectionResolver[*repositoryResolver], error) {
+	query, err := scoped(ctx, r.db.Model(&models.Repository{}), auth.ScopeRepositories)
+	if err != nil {
+		return nil, err
+	}
+	if args.Name != nil {
+		query = query.Where("name = ?", *args.Name)
+	}
+	if args.Language != nil {
+		query = query.Where("language = ?", *args.Language)
+	}
+	if args.Private != nil {
+		query = query.Where("private = ?", *args.Private)
+	}
+
+	return paginate(ctx, query, args.connectionArgs,
+		func(repo models.Repository) uint { return repo.ID },
+		func(repo models.Repository) *repositoryResolver { return &repositoryResolver{db: r.db, repo: repo} },
+	)
+}
+
+type repositoryArgs struct {
+	ID         *graphqlgo.ID
+	FullName   *string
+	Connection *string
+}
+
+// Repository looks up a single repository by ID or by connection and full
+// name.
+func (r *Resolver) Repository(ctx context.Context, args repositoryArgs) (*repositoryResolver, error) {
+	if args.ID == nil && args.FullName == nil {
+		return nil, errors.New("either id or fullName is required")
+	}
+
+	query, err := scoped(ctx, r.db, auth.ScopeRepositories)
+	if err != nil {
+		return nil, err
+	}
+	if args.ID != nil {
+		id, err := parseID(*args.ID)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id = ?", id)
+	} else {
+		connection := config.DefaultConnection
+		if args.Connection != nil {
+			connection = *args.Connection
+		}
+		query = query.Where("connection = ? AND full_name = ?", connection, *args.FullName)
+	}
+
+	var repo models.Repository
+	if err := first(query, &repo); err != nil || repo.ID == 0 {
+		return nil, err
+	}
+	return &repositoryResolver{db: r.db, repo: repo}, nil
+}
+
+type idArgs struct {
+	ID graphqlgo.ID
+}
+
+// Workflow looks up a single workflow by ID.
+func (r *Resolver) Workflow(ctx context.Context, args idArgs) (*workflowResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := scoped(ctx, r.db, auth.ScopeWorkflows)
+	if err != nil {
+		return nil, err
+	}
+
+	var workflow models.Workflow
+	if err := first(query.Where("id = ?", id), &workflow); err != nil || workflow.ID == 0 {
+		return nil, err
+	}
+	return &workflowResolver{db: r.db, workflow: workflow}, nil
+}
+
+// WorkflowRun looks up a single workflow run by ID.
+func (r *Resolver) WorkflowRun(ctx context.Context, args idArgs) (*workflowRunResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := scoped(ctx, r.db, auth.ScopeWorkflowRuns)
+	if err != nil {
+		return nil, err
+	}
+
+	var run models.WorkflowRun
+	if err := first(query.Where("id = ?", id), &run); err != nil || run.ID == 0 {
+		return nil, err
+	}
+	return &workflowRunResolver{db: r.db, run: run}, nil
+}
+
+// Job looks up a single job by ID.
+func (r *Resolver) Job(ctx context.Context, args idArgs) (*jobResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := scoped(ctx, r.db, auth.ScopeJobs)
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.Job
+	if err := first(query.Where("id = ?", id), &job); err != nil || job.ID == 0 {
+		return nil, err
+	}
+	return &jobResolver{db: r.db, job: job}, nil
+}
+
+// scoped binds query to ctx and limits it to records visible to the
+// authenticated user or token in ctx. The lookup is charged to the query's
+// budget.
+func scoped(ctx context.Context, query *gorm.DB, scope func(auth.Viewer) func(*gorm.DB) *gorm.DB) (*gorm.DB, error) {
+	viewer, ok := auth.ViewerFromContext(ctx)
+	if !ok {
+		return nil, errors.New("unauthorized")
+	}
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	return query.WithContext(ctx).Scopes(scope(viewer)), nil
+}
+
+// first loads the first record matching query into dest. A missing record is
+// not an error; the GraphQL field simply resolves to null.
+func first(query *gorm.DB, dest interface{}) error {
+	err := query.First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+func parseID(id graphqlgo.ID) (uint, error) {
+	v, err := strconv.ParseUint(string(id), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID: %q", id)
+	}
+	return uint(v), nil
+}
+
+func formatID[T ~int64 | ~uint](id T) graphqlgo.ID {
+	return graphqlgo.ID(fmt.Sprint(id))
+}
@@ -0,0 +1,339 @@
+package graphql
+
+import (
+	"context"
+	"time"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"gorm.io/gorm"
+)
+
+// defaultStatsWindow is the time range used for stats when no since argument
+// is given. It matches the REST stats endpoint.
+const defaultStatsWindow = 30 * 24 * time.Hour
+
+type repositoryResolver struct {
+	db   *gorm.DB
+	repo models.Repository
+}
+
+func (r *repositoryResolver) ID() graphqlgo.ID         { return formatID(r.repo.ID) }
+func (r *repositoryResolver) Name() string             { return r.repo.Name }
+func (r *repositoryResolver) FullName() string         { return r.repo.FullName }
+func (r *repositoryResolver) Connection() string       { return r.repo.Connection }
+func (r *repositoryResolver) Description() string      { return r.repo.Description }
+func (r *repositoryResolver) Private() bool            { return r.repo.Private }
+func (r *repositoryResolver) Fork() bool               { return r.repo.Fork }
+func (r *repositoryResolver) Language() string         { return r.repo.Language }
+func (r *repositoryResolver) StarCount() int32         { return int32(r.repo.StarCount) }
+func (r *repositoryResolver) PushedAt() graphqlgo.Time { return graphqlgo.Time{Time: r.repo.PushedAt} }
+
+type workflowsArgs struct {
+	connectionArgs
+	State *string
+}
+
+func (r *repositoryResolver) Workflows(ctx context.Context, args workflowsArgs) (*connectionResolver[*workflowResolver], error) {
+	query, err := scoped(ctx, r.db.Model(&models.Workflow{}), auth.ScopeWorkflows)
+	if err != nil {
+		return nil, err
+	}
+	query = query.Where("repository_id = ?", r.repo.ID)
+	if args.State != nil {
+		query = query.Where("state = ?", *args.State)
+	}
+
+	return paginate(ctx, query, args.connectionArgs,
+		func(workflow models.Workflow) uint { return workflow.ID },
+		func(workflow models.Workflow) *workflowResolver {
+			return &workflowResolver{db: r.db, workflow: workflow}
+		},
+	)
+}
+
+type workflowResolver struct {
+	db       *gorm.DB
+	workflow models.Workflow
+}
+
+func (r *workflowResolver) ID() graphqlgo.ID         { return formatID(r.workflow.ID) }
+func (r *workflowResolver) WorkflowID() graphqlgo.ID { return formatID(r.workflow.WorkflowID) }
+func (r *workflowResolver) Name() string             { return r.workflow.Name }
+func (r *workflowResolver) Path() string             { return r.workflow.Path }
+func (r *workflowResolver) State() string            { return r.workflow.State }
+func (r *workflowResolver) HTMLURL() string          { return r.workflow.HTMLURL }
+func (r *workflowResolver) BadgeURL() string         { return r.workflow.BadgeURL }
+func (r *workflowResolver) CreatedAt() graphqlgo.Time {
+	return graphqlgo.Time{Time: r.workflow.CreatedAt}
+}
+func (r *workflowResolver) UpdatedAt() graphqlgo.Time {
+	return graphqlgo.Time{Time: r.workflow.UpdatedAt}
+}
+
+func (r *workflowResolver) Repository(ctx context.Context) (*repositoryResolver, error) {
+	query, err := scoped(ctx, r.db, auth.ScopeRepositories)
+	if err != nil {
+		return nil, err
+	}
+
+	var repo models.Repository
+	if err := first(query.Where("id = ?", r.workflow.RepositoryID), &repo); err != nil || repo.ID == 0 {
+		return nil, err
+	}
+	return &repositoryResolver{db: r.db, repo: repo}, nil
+}
+
+type runsArgs struct {
+	connectionArgs
+	Status     *string
+	Conclusion *string
+	Branch     *string
+	Event      *string
+	Since      *graphqlgo.Time
+	Until      *graphqlgo.Time
+}
+
+func (r *workflowResolver) Runs(ctx context.Context, args runsArgs) (*connectionResolver[*workflowRunResolver], error) {
+	query, err := scoped(ctx, r.db.Model(&models.WorkflowRun{}), auth.ScopeWorkflowRuns)
+	if err != nil {
+		return nil, err
+	}
+	query = query.Where("connection = ? AND workflow_id = ?", r.workflow.Connection, r.workflow.WorkflowID)
+	if args.Status != nil {
+		query = query.Where("status = ?", *args.Status)
+	}
+	if args.Conclusion != nil {
+		query = query.Where("conclusion = ?", *args.Conclusion)
+	}
+	if args.Branch != nil {
+		query = query.Where("head_branch = ?", *args.Branch)
+	}
+	if args.Event != nil {
+		query = query.Where("event = ?", *args.Event)
+	}
+	if args.Since != nil {
+		query = query.Where("created_at >= ?", args.Since.Time)
+	}
+	if args.Until != nil {
+		query = query.Where("created_at <= ?", args.Until.Time)
+	}
+
+	return paginate(ctx, query, args.connectionArgs,
+		func(run models.WorkflowRun) uint { return run.ID },
+		func(run models.WorkflowRun) *workflowRunResolver { return &workflowRunResolver{db: r.db, run: run} },
+	)
+}
+
+type statsArgs struct {
+	Since *graphqlgo.Time
+	Until *graphqlgo.Time
+}
+
+func (r *workflowResolver) Stats(ctx context.Context, args statsArgs) (*workflowStatsResolver, error) {
+	until := time.Now()
+	if args.Until != nil {
+		until = args.Until.Time
+	}
+	since := until.Add(-defaultStatsWindow)
+	if args.Since != nil {
+		since = args.Since.Time
+	}
+
+	query, err := scoped(ctx, r.db, auth.ScopeWorkflowRuns)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count each run once, as the REST stats do
+	var runs []models.WorkflowRun
+	err = query.Scopes(models.LatestAttempts).
+		Where("connection = ? AND workflow_id = ?", r.workflow.Connection, r.workflow.WorkflowID).
+		Where("created_at BETWEEN ? AND ?", since, until).
+		Find(&runs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &workflowStatsResolver{
+		since:   since,
+		until:   until,
+		summary: models.SummarizeRuns(runs),
+	}, nil
+}
+
+type workflowStatsResolver struct {
+	since   time.Time
+	until   time.Time
+	summary models.RunSummary
+}
+
+func (r *workflowStatsResolver) Since() graphqlgo.Time { return graphqlgo.Time{Time: r.since} }
+func (r *workflowStatsResolver) Until() graphqlgo.Time { return graphqlgo.Time{Time: r.until} }
+func (r *workflowStatsResolver) TotalRuns() int32      { return int32(r.summary.TotalRuns) }
+func (r *workflowStatsResolver) SuccessCount() int32   { return int32(r.summary.SuccessCount) }
+func (r *workflowStatsResolver) FailureCount() int32   { return int32(r.summary.FailureCount) }
+func (r *workflowStatsResolver) CancelledCount() int32 { return int32(r.summary.CancelledCount) }
+func (r *workflowStatsResolver) TimedOutCount() int32  { return int32(r.summary.TimedOutCount) }
+func (r *workflowStatsResolver) ActionRequiredCount() int32 {
+	return int32(r.summary.ActionRequiredCount)
+}
+func (r *workflowStatsResolver) SuccessRate() float64        { return r.summary.SuccessRate }
+func (r *workflowStatsResolver) FailureRate() float64        { return r.summary.FailureRate }
+func (r *workflowStatsResolver) CancelledRate() float64      { return r.summary.CancelledRate }
+func (r *workflowStatsResolver) TimedOutRate() float64       { return r.summary.TimedOutRate }
+func (r *workflowStatsResolver) ActionRequiredRate() float64 { return r.summary.ActionRequiredRate }
+
+type workflowRunResolver struct {
+	db  *gorm.DB
+	run models.WorkflowRun
+}
+
+func (r *workflowRunResolver) ID() graphqlgo.ID    { return formatID(r.run.ID) }
+func (r *workflowRunResolver) RunID() graphqlgo.ID { return formatID(r.run.RunID) }
+func (r *workflowRunResolver) Name() string        { return r.run.Name }
+func (r *workflowRunResolver) HeadBranch() string  { return r.run.HeadBranch }
+func (r *workflowRunResolver) HeadSha() string     { return r.run.HeadSHA }
+func (r *workflowRunResolver) Status() string      { return r.run.Status }
+func (r *workflowRunResolver) Conclusion() string  { return r.run.Conclusion }
+func (r *workflowRunResolver) Event() string       { return r.run.Event }
+func (r *workflowRunResolver) RunNumber() int32    { return int32(r.run.RunNumber) }
+func (r *workflowRunResolver) RunAttempt() int32   { return int32(r.run.RunAttempt) }
+func (r *workflowRunResolver) HTMLURL() string     { return r.run.HTMLURL }
+func (r *workflowRunResolver) CreatedAt() graphqlgo.Time {
+	return graphqlgo.Time{Time: r.run.CreatedAt}
+}
+func (r *workflowRunResolver) UpdatedAt() graphqlgo.Time {
+	return graphqlgo.Time{Time: r.run.UpdatedAt}
+}
+
+// DurationSeconds is the wall time of a completed run.
+func (r *workflowRunResolver) DurationSeconds() *float64 {
+	if r.run.Status != "completed" {
+		return nil
+	}
+	start := r.run.CreatedAt
+	if r.run.RunStartedAt != nil {
+		start = *r.run.RunStartedAt
+	}
+	seconds := r.run.UpdatedAt.Sub(start).Seconds()
+	return &seconds
+}
+
+func (r *workflowRunResolver) Workflow(ctx context.Context) (*workflowResolver, error) {
+	query, err := scoped(ctx, r.db, auth.ScopeWorkflows)
+	if err != nil {
+		return nil, err
+	}
+
+	var workflow models.Workflow
+	if err := first(query.Where("connection = ? AND workflow_id = ?", r.run.Connection, r.run.WorkflowID), &workflow); err != nil || workflow.ID == 0 {
+		return nil, err
+	}
+	return &workflowResolver{db: r.db, workflow: workflow}, nil
+}
+
+type jobsArgs struct {
+	connectionArgs
+	Status     *string
+	Conclusion *string
+}
+
+func (r *workflowRunResolver) Jobs(ctx context.Context, args jobsArgs) (*connectionResolver[*jobResolver], error) {
+	query, err := scoped(ctx, r.db.Model(&models.Job{}), auth.ScopeJobs)
+	if err != nil {
+		return nil, err
+	}
+	query = query.Where("connection = ? AND run_id = ?", r.run.Connection, r.run.RunID)
+	// Runs saved before attempts were recorded have no attempt number
+	if r.run.RunAttempt > 0 {
+		query = query.Where("run_attempt = ?", r.run.RunAttempt)
+	}
+	if args.Status != nil {
+		query = query.Where("status = ?", *args.Status)
+	}
+	if args.Conclusion != nil {
+		query = query.Where("conclusion = ?", *args.Conclusion)
+	}
+
+	return paginate(ctx, query, args.connectionArgs,
+		func(job models.Job) uint { return uint(job.ID) },
+		func(job models.Job) *jobResolver { return &jobResolver{db: r.db, job: job} },
+	)
+}
+
+type jobResolver struct {
+	db  *gorm.DB
+	job models.Job
+}
+
+func (r *jobResolver) ID() graphqlgo.ID        { return formatID(r.job.ID) }
+func (r *jobResolver) JobID() graphqlgo.ID     { return formatID(r.job.JobID) }
+func (r *jobResolver) RunID() graphqlgo.ID     { return formatID(r.job.RunID) }
+func (r *jobResolver) Name() string            { return r.job.Name }
+func (r *jobResolver) Status() string          { return r.job.Status }
+func (r *jobResolver) Conclusion() string      { return r.job.Conclusion }
+func (r *jobResolver) HeadSha() string         { return r.job.HeadSHA }
+func (r *jobResolver) HTMLURL() string         { return r.job.HTMLURL }
+func (r *jobResolver) RunnerName() string      { return r.job.RunnerName }
+func (r *jobResolver) RunnerGroupName() string { return r.job.RunnerGroupName }
+func (r *jobResolver) RunAttempt() int32       { return int32(r.job.RunAttempt) }
+func (r *jobResolver) CreatedAt() graphqlgo.Time {
+	return graphqlgo.Time{Time: r.job.CreatedAt}
+}
+func (r *jobResolver) CompletedAt() *graphqlgo.Time {
+	return optionalTime(r.job.CompletedAt)
+}
+
+// DurationSeconds is the time between the job being created and completed.
+func (r *jobResolver) DurationSeconds() *float64 {
+	if r.job.CompletedAt.IsZero() {
+		return nil
+	}
+	seconds := r.job.CompletedAt.Sub(r.job.CreatedAt).Seconds()
+	return &seconds
+}
+
+func (r *jobResolver) Steps(ctx context.Context) ([]*taskStepResolver, error) {
+	query, err := scoped(ctx, r.db, auth.ScopeSteps)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []models.TaskStep
+	if err := query.Where("job_id = ?", r.job.ID).Order("id").Find(&steps).Error; err != nil {
+		return nil, err
+	}
+	if err := charge(ctx, len(steps)); err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*taskStepResolver, 0, len(steps))
+	for _, step := range steps {
+		resolvers = append(resolvers, &taskStepResolver{step: step})
+	}
+	return resolvers, nil
+}
+
+type taskStepResolver struct {
+	step models.TaskStep
+}
+
+func (r *taskStepResolver) ID() graphqlgo.ID   { return formatID(r.step.ID) }
+func (r *taskStepResolver) Name() string       { return r.step.Name }
+func (r *taskStepResolver) Status() string     { return r.step.Status }
+func (r *taskStepResolver) Conclusion() string { return r.step.Conclusion }
+func (r *taskStepResolver) StartedAt() *graphqlgo.Time {
+	return optionalTime(r.step.StartedAt)
+}
+func (r *taskStepResolver) CompletedAt() *graphqlgo.Time {
+	return optionalTime(r.step.CompletedAt)
+}
+
+func optionalTime(t time.Time) *graphqlgo.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &graphqlgo.Time{Time: t}
+}
@@ -0,0 +1,222 @@
+package inventory
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of uses references.
+const (
+	KindAction   = "action"
+	KindWorkflow = "reusable_workflow"
+	KindDocker   = "docker"
+	KindLocal    = "local"
+)
+
+// shaPattern matches a full commit SHA, the only ref that can't be moved.
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// defaultDeprecated lists actions and major versions that run on retired
+// Node.js versions or have been archived. Entries without a version match
+// every version.
+var defaultDeprecated = []string{
+	"actions/checkout@v1", "actions/checkout@v2", "actions/checkout@v3",
+	"actions/setup-node@v1", "actions/setup-node@v2", "actions/setup-node@v3",
+	"actions/setup-python@v1", "actions/setup-python@v2", "actions/setup-python@v3", "actions/setup-python@v4",
+	"actions/setup-go@v1", "actions/setup-go@v2", "actions/setup-go@v3", "actions/setup-go@v4",
+	"actions/setup-java@v1", "actions/setup-java@v2", "actions/setup-java@v3",
+	"actions/cache@v1", "actions/cache@v2", "actions/cache@v3",
+	"actions/upload-artifact@v1", "actions/upload-artifact@v2", "actions/upload-artifact@v3",
+	"actions/download-artifact@v1", "actions/download-artifact@v2", "actions/download-artifact@v3",
+	"actions/github-script@v1", "actions/github-script@v2", "actions/github-script@v3",
+	"actions/github-script@v4", "actions/github-script@v5", "actions/github-script@v6",
+	"actions/create-release", "actions/upload-release-asset",
+}
+
+// Reference is a parsed uses value.
+type Reference struct {
+	// Action is the repository of an action or reusable workflow, with the
+	// path within it if any, the image of a Docker action, or the path of a
+	// local action.
+	Action string
+	Ref    string
+	Kind   string
+	// Pinned is set for references that can't change: full commit SHAs,
+	// image digests and local actions.
+	Pinned bool
+}
+
+// ParseReference parses the value of a uses key.
+func ParseReference(uses string) Reference {
+	switch {
+	case strings.HasPrefix(uses, "./"):
+		return Reference{Action: uses, Kind: KindLocal, Pinned: true}
+	case strings.HasPrefix(uses, "docker://"):
+		image := strings.TrimPrefix(uses, "docker://")
+		if name, digest, ok := strings.Cut(image, "@"); ok {
+			return Reference{Action: name, Ref: digest, Kind: KindDocker, Pinned: strings.HasPrefix(digest, "sha256:")}
+		}
+		name, tag := image, ""
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			name, tag = image[:i], image[i+1:]
+		}
+		return Reference{Action: name, Ref: tag, Kind: KindDocker}
+	}
+
+	action, ref, _ := strings.Cut(uses, "@")
+	kind := KindAction
+	if strings.Contains(action, "/.github/workflows/") {
+		kind = KindWorkflow
+	}
+	return Reference{Action: action, Ref: ref, Kind: kind, Pinned: shaPattern.MatchString(ref)}
+}
+
+// Checker flags deprecated action versions.
+type Checker struct {
+	// deprecated maps actions to their deprecated versions; an empty
+	// version deprecates every version.
+	deprecated map[string][]string
+}
+
+// NewChecker creates a Checker using the built-in list of deprecated
+// versions and the extra ones, written as owner/repo@version or owner/repo.
+func NewChecker(extra []string) *Checker {
+	c := &Checker{deprecated: make(map[string][]string)}
+	for _, entry := range append(append([]string{}, defaultDeprecated...), extra...) {
+		action, version, _ := strings.Cut(entry, "@")
+		action = strings.ToLower(action)
+		c.deprecated[action] = append(c.deprecated[action], version)
+	}
+	return c
+}
+
+// Deprecated reports whether the reference uses a deprecated version. A
+// version matches its exact ref and refs within it, so v2 matches v2.1.0;
+// SHAs are not resolved and never match a version.
+func (c *Checker) Deprecated(ref Reference) bool {
+	if ref.Kind != KindAction && ref.Kind != KindWorkflow {
+		return false
+	}
+	// Actions in a subdirectory are deprecated with their repository
+	action := strings.ToLower(ref.Action)
+	parts := strings.SplitN(action, "/", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	for _, name := range []string{action, parts[0] + "/" + parts[1]} {
+		for _, version := range c.deprecated[name] {
+			if version == "" || ref.Ref == version || strings.HasPrefix(ref.Ref, version+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Usage is a workflow that uses an action.
+type Usage struct {
+	Connection string   `json:"connection"`
+	Repository string   `json:"repository"`
+	Workflow   string   `json:"workflow"`
+	Jobs       []string `json:"jobs"`
+}
+
+// Entry is one version of an action and the workflows that use it.
+type Entry struct {
+	Action     string  `json:"action"`
+	Ref        string  `json:"ref"`
+	Kind       string  `json:"kind"`
+	Pinned     bool    `json:"pinned"`
+	Deprecated bool    `json:"deprecated"`
+	Usages     []Usage `json:"usages"`
+}
+
+// Flagged reports whether the entry needs attention: it isn't pinned, so
+// its code can change under the workflows using it, or it is deprecated.
+func (e Entry) Flagged() bool {
+	return !e.Pinned || e.Deprecated
+}
+
+// Builder collects the uses references of workflows into an inventory.
+type Builder struct {
+	checker *Checker
+	entries map[Reference]*Entry
+	// usages indexes the usages of each entry by workflow
+	usages map[Reference]map[usageKey]int
+}
+
+type usageKey struct {
+	connection, repository, workflow string
+}
+
+// NewBuilder creates an empty inventory.
+func NewBuilder(checker *Checker) *Builder {
+	return &Builder{
+		checker: checker,
+		entries: make(map[Reference]*Entry),
+		usages:  make(map[Reference]map[usageKey]int),
+	}
+}
+
+// Add records that a job of a workflow uses an action.
+func (b *Builder) Add(connection, repository, workflow, job, uses string) {
+	ref := ParseReference(uses)
+	entry, ok := b.entries[ref]
+	if !ok {
+		entry = &Entry{
+			Action:     ref.Action,
+			Ref:        ref.Ref,
+			Kind:       ref.Kind,
+			Pinned:     ref.Pinned,
+			Deprecated: b.checker.Deprecated(ref),
+		}
+		b.entries[ref] = entry
+		b.usages[ref] = make(map[usageKey]int)
+	}
+
+	key := usageKey{connection: connection, repository: repository, workflow: workflow}
+	i, ok := b.usages[ref][key]
+	if !ok {
+		i = len(entry.Usages)
+		b.usages[ref][key] = i
+		entry.Usages = append(entry.Usages, Usage{
+			Connection: connection,
+			Repository: repository,
+			Workflow:   workflow,
+			Jobs:       []string{},
+		})
+	}
+	usage := &entry.Usages[i]
+	if len(usage.Jobs) == 0 || usage.Jobs[len(usage.Jobs)-1] != job {
+		usage.Jobs = append(usage.Jobs, job)
+	}
+}
+
+// Entries returns the inventory sorted by action and ref, with usages
+// sorted by repository and workflow.
+func (b *Builder) Entries() []Entry {
+	entries := make([]Entry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entry := *e
+		entry.Usages = append([]Usage{}, e.Usages...)
+		sort.Slice(entry.Usages, func(i, j int) bool {
+			a, b := entry.Usages[i], entry.Usages[j]
+			if a.Connection != b.Connection {
+				return a.Connection < b.Connection
+			}
+			if a.Repository != b.Repository {
+				return a.Repository < b.Repository
+			}
+			return a.Workflow < b.Workflow
+		})
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Action != entries[j].Action {
+			return entries[i].Action < entries[j].Action
+		}
+		return entries[i].Ref < entries[j].Ref
+	})
+	return entries
+}
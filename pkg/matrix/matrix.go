@@ -0,0 +1,238 @@
+package matrix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+)
+
+// ParseJobName splits the name GitHub gives a job of a matrix, such as
+// "test (ubuntu-latest, 1.21)", into the name of the job and the values of
+// its matrix dimensions. ok is false for names without matrix values.
+func ParseJobName(name string) (base string, values []string, ok bool) {
+	if !strings.HasSuffix(name, ")") {
+		return name, nil, false
+	}
+
+	// Find the parenthesis opening the values, which may themselves contain
+	// parentheses
+	depth := 0
+	open := -1
+	for i := len(name) - 1; i >= 0 && open < 0; i-- {
+		switch name[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				open = i
+			}
+		}
+	}
+	if open <= 0 || name[open-1] != ' ' {
+		return name, nil, false
+	}
+	base = strings.TrimSpace(name[:open])
+	if base == "" {
+		return name, nil, false
+	}
+
+	// Split on the commas between values, not those within object values
+	inner := name[open+1 : len(name)-1]
+	start := 0
+	depth = 0
+	for i, r := range inner {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				values = append(values, strings.TrimSpace(inner[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	values = append(values, strings.TrimSpace(inner[start:]))
+	return base, values, true
+}
+
+// Stats summarizes the completed jobs of a matrix or of part of it.
+type Stats struct {
+	TotalJobs              int     `json:"total_jobs"`
+	SuccessCount           int     `json:"success_count"`
+	FailureCount           int     `json:"failure_count"`
+	CancelledCount         int     `json:"cancelled_count"`
+	SuccessRate            float64 `json:"success_rate"`
+	FailureRate            float64 `json:"failure_rate"`
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+	MaxDurationSeconds     float64 `json:"max_duration_seconds"`
+
+	timed int
+}
+
+func (s *Stats) add(job models.Job) {
+	s.TotalJobs++
+	switch job.Conclusion {
+	case "success":
+		s.SuccessCount++
+	case "failure", "timed_out":
+		s.FailureCount++
+	case "cancelled":
+		s.CancelledCount++
+	}
+	if duration, ok := jobDuration(job); ok {
+		seconds := duration.Seconds()
+		s.AverageDurationSeconds += seconds
+		s.timed++
+		if seconds > s.MaxDurationSeconds {
+			s.MaxDurationSeconds = seconds
+		}
+	}
+}
+
+func (s *Stats) finish() {
+	if s.TotalJobs > 0 {
+		total := float64(s.TotalJobs)
+		s.SuccessRate = float64(s.SuccessCount) / total * 100
+		s.FailureRate = float64(s.FailureCount) / total * 100
+	}
+	if s.timed > 0 {
+		s.AverageDurationSeconds /= float64(s.timed)
+	}
+}
+
+// jobDuration is how long a job ran, from when it started, or was created
+// for jobs saved before start times were recorded, to when it completed.
+func jobDuration(job models.Job) (time.Duration, bool) {
+	start := job.StartedAt
+	if start.IsZero() {
+		start = job.CreatedAt
+	}
+	if start.IsZero() || job.CompletedAt.Before(start) {
+		return 0, false
+	}
+	return job.CompletedAt.Sub(start), true
+}
+
+// Leg is one combination of matrix values.
+type Leg struct {
+	Name   string            `json:"name"`
+	Values map[string]string `json:"values"`
+	Stats
+}
+
+// Job is a job of a workflow run with a matrix, with statistics for the whole
+// matrix, for each leg, and for each value of each dimension.
+type Job struct {
+	Name       string   `json:"name"`
+	Dimensions []string `json:"dimensions"`
+	Stats
+	Legs []Leg `json:"legs"`
+	// ByDimension maps each dimension to the statistics of each of its
+	// values, so a failing or slow value shows up across the other
+	// dimensions.
+	ByDimension map[string]map[string]Stats `json:"by_dimension"`
+}
+
+// Aggregate groups jobs whose names carry matrix values by their base job
+// and computes their statistics. Dimensions are named after the matrix keys
+// of the job in the definition, when it is given; values beyond those keys,
+// or of jobs missing from the definition, are named by position, as
+// dimension_1 and so on. Jobs without matrix values are skipped.
+func Aggregate(jobs []models.Job, definition *definitions.Definition) []Job {
+	groups := make(map[string]*Job)
+	legs := make(map[string]map[string]int)
+	for _, job := range jobs {
+		base, values, ok := ParseJobName(job.Name)
+		if !ok {
+			continue
+		}
+		group, exists := groups[base]
+		if !exists {
+			group = &Job{Name: base, ByDimension: make(map[string]map[string]Stats)}
+			groups[base] = group
+			legs[base] = make(map[string]int)
+		}
+
+		names := dimensionNames(definition, base, len(values))
+		for _, name := range names {
+			if _, seen := group.ByDimension[name]; !seen {
+				group.Dimensions = append(group.Dimensions, name)
+				group.ByDimension[name] = make(map[string]Stats)
+			}
+		}
+
+		group.add(job)
+		i, seen := legs[base][job.Name]
+		if !seen {
+			leg := Leg{Name: job.Name, Values: make(map[string]string, len(values))}
+			for j, value := range values {
+				leg.Values[names[j]] = value
+			}
+			i = len(group.Legs)
+			legs[base][job.Name] = i
+			group.Legs = append(group.Legs, leg)
+		}
+		group.Legs[i].add(job)
+		for j, value := range values {
+			stats := group.ByDimension[names[j]][value]
+			stats.add(job)
+			group.ByDimension[names[j]][value] = stats
+		}
+	}
+
+	result := make([]Job, 0, len(groups))
+	for _, group := range groups {
+		group.finish()
+		for i := range group.Legs {
+			group.Legs[i].finish()
+		}
+		sort.Slice(group.Legs, func(i, j int) bool { return group.Legs[i].Name < group.Legs[j].Name })
+		for _, values := range group.ByDimension {
+			for value, stats := range values {
+				stats.finish()
+				values[value] = stats
+			}
+		}
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// dimensionNames names the count values of a job of the named matrix.
+// Keys added only through include come after the written dimensions.
+func dimensionNames(definition *definitions.Definition, base string, count int) []string {
+	var known []string
+	if definition != nil {
+		for _, job := range definition.Jobs {
+			name := job.Name
+			if name == "" {
+				name = job.ID
+			}
+			if name == base {
+				known = job.Dimensions
+				break
+			}
+		}
+	}
+	if len(known) > count {
+		known = nil
+	}
+
+	names := make([]string, count)
+	for i := range names {
+		if i < len(known) {
+			names[i] = known[i]
+		} else {
+			names[i] = fmt.Sprintf("dimension_%d", i+1)
+		}
+	}
+	return names
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Circuit breaker states as reported by GitHubCircuitState.
+const (
+	CircuitClosed   = 0
+	CircuitHalfOpen = 1
+	CircuitOpen     = 2
+)
+
+var (
+	// GitHubRequests counts GitHub API requests by host and outcome, which
+	// is the status code or "error" for network errors.
+	GitHubRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_requests_total",
+		Help: "GitHub API requests by host and outcome.",
+	}, []string{"host", "outcome"})
+
+	// GitHubRetries counts retried GitHub API requests by host.
+	GitHubRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_request_retries_total",
+		Help: "Retried GitHub API requests by host.",
+	}, []string{"host"})
+
+	// GitHubCircuitState is the circuit breaker state per GitHub host:
+	// 0 closed, 1 half-open, 2 open.
+	GitHubCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_circuit_state",
+		Help: "Circuit breaker state per GitHub host (0 closed, 1 half-open, 2 open).",
+	}, []string{"host"})
+
+	// ReconciliationDrift counts runs the reconciler found out of date, by
+	// connection and kind: "missing", "stale" or "stuck". Drift means
+	// webhooks were not delivered or not processed.
+	ReconciliationDrift = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reconciliation_drift_total",
+		Help: "Workflow runs repaired by reconciliation by connection and kind.",
+	}, []string{"connection", "kind"})
+)
@@ -0,0 +1,178 @@
+package pullrequests
+
+import (
+	"sort"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+)
+
+// Summary is the CI history of a pull request.
+type Summary struct {
+	// Pushes is the number of head commits that ran workflows.
+	Pushes int `json:"pushes"`
+	// Runs counts workflow runs, Attempts counts them with their re-runs,
+	// and Reruns is the difference.
+	Runs     int `json:"runs"`
+	Attempts int `json:"attempts"`
+	Reruns   int `json:"reruns"`
+	// FailedRuns is the number of attempts that failed before the pull
+	// request was merged, or so far for unmerged ones.
+	FailedRuns int `json:"failed_runs"`
+	// CIWallTimeSeconds adds up the wall time of every completed attempt.
+	CIWallTimeSeconds float64 `json:"ci_wall_time_seconds"`
+	// FirstGreenAt is when a pushed commit first had every workflow run
+	// succeed, counting re-runs.
+	FirstGreenAt *time.Time `json:"first_green_at"`
+	// TimeToFirstGreenSeconds is from when the pull request was opened to
+	// FirstGreenAt; TimeToMergeSeconds is from opened to merged.
+	TimeToFirstGreenSeconds *float64 `json:"time_to_first_green_seconds"`
+	TimeToMergeSeconds      *float64 `json:"time_to_merge_seconds"`
+}
+
+// Summarize computes the CI history of a pull request from the attempts of
+// its workflow runs.
+func Summarize(pr models.PullRequest, attempts []models.WorkflowRun) Summary {
+	summary := Summary{Attempts: len(attempts)}
+
+	latest := make(map[int64]models.WorkflowRun)
+	for _, attempt := range attempts {
+		if current, ok := latest[attempt.RunID]; !ok || attempt.RunAttempt > current.RunAttempt {
+			latest[attempt.RunID] = attempt
+		}
+		if attempt.Status == "completed" {
+			summary.CIWallTimeSeconds += wallTime(attempt).Seconds()
+			if failed(attempt.Conclusion) && (pr.MergedAt == nil || attempt.UpdatedAt.Before(*pr.MergedAt)) {
+				summary.FailedRuns++
+			}
+		}
+	}
+	summary.Runs = len(latest)
+	summary.Reruns = summary.Attempts - summary.Runs
+
+	// A commit is green once the latest attempt of each of its runs succeeded
+	type commit struct {
+		green   bool
+		greenAt time.Time
+	}
+	commits := make(map[string]*commit)
+	for _, run := range latest {
+		c, ok := commits[run.HeadSHA]
+		if !ok {
+			c = &commit{green: true}
+			commits[run.HeadSHA] = c
+		}
+		if run.Status != "completed" || !passed(run.Conclusion) {
+			c.green = false
+			continue
+		}
+		if run.UpdatedAt.After(c.greenAt) {
+			c.greenAt = run.UpdatedAt
+		}
+	}
+	summary.Pushes = len(commits)
+	for _, c := range commits {
+		if c.green && (summary.FirstGreenAt == nil || c.greenAt.Before(*summary.FirstGreenAt)) {
+			greenAt := c.greenAt
+			summary.FirstGreenAt = &greenAt
+		}
+	}
+
+	if pr.OpenedAt != nil {
+		if summary.FirstGreenAt != nil {
+			summary.TimeToFirstGreenSeconds = seconds(summary.FirstGreenAt.Sub(*pr.OpenedAt))
+		}
+		if pr.MergedAt != nil {
+			summary.TimeToMergeSeconds = seconds(pr.MergedAt.Sub(*pr.OpenedAt))
+		}
+	}
+
+	return summary
+}
+
+// Totals summarizes the CI history of several pull requests.
+type Totals struct {
+	PullRequests int `json:"pull_requests"`
+	Merged       int `json:"merged"`
+	// Medians are taken over the pull requests that have the value.
+	MedianTimeToFirstGreenSeconds *float64 `json:"median_time_to_first_green_seconds"`
+	MedianTimeToMergeSeconds      *float64 `json:"median_time_to_merge_seconds"`
+	MedianCIWallTimeSeconds       *float64 `json:"median_ci_wall_time_seconds"`
+	AveragePushes                 float64  `json:"average_pushes"`
+	AverageFailedRuns             float64  `json:"average_failed_runs"`
+	AverageReruns                 float64  `json:"average_reruns"`
+}
+
+// Total summarizes the CI history of the given pull requests.
+func Total(summaries []Summary) Totals {
+	totals := Totals{PullRequests: len(summaries)}
+	if len(summaries) == 0 {
+		return totals
+	}
+
+	var toGreen, toMerge, wallTimes []float64
+	for _, summary := range summaries {
+		if summary.TimeToFirstGreenSeconds != nil {
+			toGreen = append(toGreen, *summary.TimeToFirstGreenSeconds)
+		}
+		if summary.TimeToMergeSeconds != nil {
+			totals.Merged++
+			toMerge = append(toMerge, *summary.TimeToMergeSeconds)
+		}
+		if summary.Attempts > 0 {
+			wallTimes = append(wallTimes, summary.CIWallTimeSeconds)
+		}
+		totals.AveragePushes += float64(summary.Pushes)
+		totals.AverageFailedRuns += float64(summary.FailedRuns)
+		totals.AverageReruns += float64(summary.Reruns)
+	}
+	count := float64(len(summaries))
+	totals.AveragePushes /= count
+	totals.AverageFailedRuns /= count
+	totals.AverageReruns /= count
+	totals.MedianTimeToFirstGreenSeconds = median(toGreen)
+	totals.MedianTimeToMergeSeconds = median(toMerge)
+	totals.MedianCIWallTimeSeconds = median(wallTimes)
+
+	return totals
+}
+
+// wallTime is how long an attempt ran. Attempts after the first share their
+// run's creation time, so the attempt's start is used when known.
+func wallTime(run models.WorkflowRun) time.Duration {
+	start := run.CreatedAt
+	if run.RunStartedAt != nil {
+		start = *run.RunStartedAt
+	}
+	if run.UpdatedAt.Before(start) {
+		return 0
+	}
+	return run.UpdatedAt.Sub(start)
+}
+
+func failed(conclusion string) bool {
+	return conclusion == "failure" || conclusion == "timed_out" || conclusion == "startup_failure"
+}
+
+// passed reports whether a conclusion doesn't block a pull request.
+func passed(conclusion string) bool {
+	return conclusion == "success" || conclusion == "skipped" || conclusion == "neutral"
+}
+
+func seconds(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+func median(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	m := values[mid]
+	if len(values)%2 == 0 {
+		m = (values[mid-1] + values[mid]) / 2
+	}
+	return &m
+}
@@ -0,0 +1,195 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultServiceName = "github-actions"
+	tracerName         = "github.com/moosh3/github-actions-aggregator/pkg/tracing"
+)
+
+// Exporter converts completed workflow runs into OTLP traces. The run is the
+// root span, each job is a child span of the run and each step is a child
+// span of its job.
+type Exporter struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewExporter creates an Exporter that ships spans to the OTLP/HTTP endpoint
+// configured in cfg.
+func NewExporter(ctx context.Context, cfg config.TracingConfig) (*Exporter, error) {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cfg.Endpoint),
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	client, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", serviceName))
+
+	return NewExporterWithSpanExporter(client, res), nil
+}
+
+// NewExporterWithSpanExporter creates an Exporter that writes spans to the
+// given span exporter. It is mostly useful for tests.
+func NewExporterWithSpanExporter(exporter sdktrace.SpanExporter, res *resource.Resource) *Exporter {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+
+	return &Exporter{
+		provider: provider,
+		tracer:   provider.Tracer(tracerName),
+	}
+}
+
+// ExportRun records a trace for a completed workflow run and its jobs.
+// Spans use the timestamps reported by GitHub rather than the current time,
+// so the resulting trace shows the pipeline as it actually executed.
+func (e *Exporter) ExportRun(ctx context.Context, run *gh.WorkflowRun, jobs []*gh.WorkflowJob) error {
+	if run.GetStatus() != "completed" {
+		return fmt.Errorf("workflow run %d is not completed", run.GetID())
+	}
+
+	runStart := run.GetRunStartedAt().Time
+	if runStart.IsZero() {
+		runStart = run.GetCreatedAt().Time
+	}
+	runEnd := run.GetUpdatedAt().Time
+
+	// Jobs may finish after the run's updated_at timestamp was recorded, so
+	// widen the root span to cover all of its children.
+	for _, job := range jobs {
+		if end := job.GetCompletedAt().Time; end.After(runEnd) {
+			runEnd = end
+		}
+	}
+
+	runCtx, runSpan := e.tracer.Start(ctx, run.GetName(),
+		trace.WithTimestamp(runStart),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(runAttributes(run)...),
+	)
+
+	for _, job := range jobs {
+		e.exportJob(runCtx, run, job)
+	}
+
+	setConclusion(runSpan, run.GetConclusion())
+	runSpan.End(trace.WithTimestamp(runEnd))
+
+	return nil
+}
+
+// exportJob records a span for a job and its steps as a child of ctx.
+func (e *Exporter) exportJob(ctx context.Context, run *gh.WorkflowRun, job *gh.WorkflowJob) {
+	jobStart := job.GetStartedAt().Time
+	jobEnd := job.GetCompletedAt().Time
+	if jobStart.IsZero() || jobEnd.IsZero() {
+		// Jobs that never started (e.g. skipped) have no meaningful duration.
+		return
+	}
+
+	attrs := append(runAttributes(run),
+		attribute.Int64("cicd.pipeline.task.run.id", job.GetID()),
+		attribute.String("cicd.pipeline.task.name", job.GetName()),
+		attribute.String("cicd.pipeline.task.run.url.full", job.GetHTMLURL()),
+		attribute.String("cicd.pipeline.task.conclusion", job.GetConclusion()),
+		attribute.String("cicd.runner.name", job.GetRunnerName()),
+		attribute.String("cicd.runner.group.name", job.GetRunnerGroupName()),
+		attribute.StringSlice("cicd.runner.labels", job.Labels),
+	)
+	if created := job.GetCreatedAt().Time; !created.IsZero() {
+		attrs = append(attrs, attribute.Int64("cicd.pipeline.task.queued_ms", jobStart.Sub(created).Milliseconds()))
+	}
+
+	jobCtx, jobSpan := e.tracer.Start(ctx, job.GetName(),
+		trace.WithTimestamp(jobStart),
+		trace.WithAttributes(attrs...),
+	)
+
+	for _, step := range job.Steps {
+		stepStart := step.GetStartedAt().Time
+		stepEnd := step.GetCompletedAt().Time
+		if stepStart.IsZero() || stepEnd.IsZero() {
+			continue
+		}
+
+		_, stepSpan := e.tracer.Start(jobCtx, step.GetName(),
+			trace.WithTimestamp(stepStart),
+			trace.WithAttributes(
+				attribute.Int64("cicd.pipeline.step.number", step.GetNumber()),
+				attribute.String("cicd.pipeline.step.conclusion", step.GetConclusion()),
+			),
+		)
+		setConclusion(stepSpan, step.GetConclusion())
+		stepSpan.End(trace.WithTimestamp(stepEnd))
+	}
+
+	setConclusion(jobSpan, job.GetConclusion())
+	jobSpan.End(trace.WithTimestamp(jobEnd))
+}
+
+// Shutdown flushes any buffered spans and stops the exporter.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.provider.Shutdown(ctx)
+}
+
+// ForceFlush exports all buffered spans immediately.
+func (e *Exporter) ForceFlush(ctx context.Context) error {
+	return e.provider.ForceFlush(ctx)
+}
+
+// runAttributes returns the attributes shared by every span of a run.
+func runAttributes(run *gh.WorkflowRun) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("vcs.repository.name", run.GetRepository().GetFullName()),
+		attribute.String("vcs.repository.url.full", run.GetRepository().GetHTMLURL()),
+		attribute.String("vcs.repository.ref.name", run.GetHeadBranch()),
+		attribute.String("vcs.repository.ref.revision", run.GetHeadSHA()),
+		attribute.String("cicd.pipeline.name", run.GetName()),
+		attribute.Int64("cicd.pipeline.run.id", run.GetID()),
+		attribute.Int("cicd.pipeline.run.number", run.GetRunNumber()),
+		attribute.Int("cicd.pipeline.run.attempt", run.GetRunAttempt()),
+		attribute.String("cicd.pipeline.run.url.full", run.GetHTMLURL()),
+		attribute.String("cicd.pipeline.run.event", run.GetEvent()),
+		attribute.String("cicd.pipeline.run.conclusion", run.GetConclusion()),
+	}
+}
+
+// setConclusion maps a GitHub conclusion onto the span status.
+func setConclusion(span trace.Span, conclusion string) {
+	switch conclusion {
+	case "success", "skipped", "neutral":
+		span.SetStatus(codes.Ok, "")
+	case "failure", "timed_out", "startup_failure":
+		span.SetStatus(codes.Error, conclusion)
+	}
+}
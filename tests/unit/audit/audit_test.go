@@ -0,0 +1,159 @@
+package audit_test
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/api"
+	"github.com/moosh3/github-actions-aggregator/pkg/audit"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	dbpkg "github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDiff(t *testing.T) {
+	type repo struct {
+		FullName string   `json:"full_name"`
+		Monitor  bool     `json:"monitor"`
+		Branches []string `json:"branches,omitempty"`
+	}
+
+	changes, err := audit.Diff(
+		repo{FullName: "octo/repo", Monitor: false},
+		repo{FullName: "octo/repo", Monitor: true, Branches: []string{"main"}},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]audit.Change{
+		"monitor":  {Before: false, After: true},
+		"branches": {Before: nil, After: []interface{}{"main"}},
+	}, changes)
+}
+
+func TestDiffCreateAndDelete(t *testing.T) {
+	changes, err := audit.Diff(nil, map[string]string{"role": "admin"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]audit.Change{"role": {Before: nil, After: "admin"}}, changes)
+
+	changes, err = audit.Diff(map[string]string{"role": "admin"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]audit.Change{"role": {Before: "admin", After: nil}}, changes)
+
+	_, err = audit.Diff("not an object", nil)
+	assert.Error(t, err)
+}
+
+func TestAuditEventIsAppendOnly(t *testing.T) {
+	event := models.AuditEvent{}
+	assert.ErrorIs(t, event.BeforeUpdate(nil), models.ErrAuditLogAppendOnly)
+	assert.ErrorIs(t, event.BeforeDelete(nil), models.ErrAuditLogAppendOnly)
+}
+
+// auditInsert returns the arguments of the audit event inserted into the
+// scripted database.
+func auditInsert(t *testing.T, database *dbtest.Database) []driver.Value {
+	t.Helper()
+	for _, write := range database.Writes() {
+		if strings.HasPrefix(write.SQL, `INSERT INTO "audit_events"`) {
+			return write.Args
+		}
+	}
+	t.Fatal("no audit event was recorded")
+	return nil
+}
+
+func TestRecordStoresActorTokenAndClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, database := dbtest.Open(t)
+
+	router := gin.New()
+	router.POST("/tokens", func(c *gin.Context) {
+		c.Set("user", &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"})
+		c.Set("api_token", &models.APIToken{Model: gorm.Model{ID: 7}})
+		err := audit.Record(c, db, audit.Entry{Action: audit.ActionTokenCreate, TargetType: "api_token", TargetID: "8"})
+		require.NoError(t, err)
+	})
+
+	req, _ := http.NewRequest("POST", "/tokens", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "ci-bot/1.0")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	args := auditInsert(t, database)
+	assert.Contains(t, args, int64(42))
+	assert.Contains(t, args, "hubot")
+	assert.Contains(t, args, audit.ActionTokenCreate)
+	assert.Contains(t, args, "ci-bot/1.0")
+	assert.Contains(t, args, "192.0.2.1")
+}
+
+func TestRecordOnlyTrustsForwardedForFromProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	clientIP := func(proxies []string) []driver.Value {
+		db, database := dbtest.Open(t)
+		router, err := api.NewEngine(&config.Config{TrustedProxies: proxies})
+		require.NoError(t, err)
+		router.POST("/sync", func(c *gin.Context) {
+			c.Set("user", &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"})
+			require.NoError(t, audit.Record(c, db, audit.Entry{Action: audit.ActionRepositorySync, TargetType: "repository", TargetID: "5"}))
+		})
+
+		req, _ := http.NewRequest("POST", "/sync", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return auditInsert(t, database)
+	}
+
+	untrusted := clientIP(nil)
+	assert.Contains(t, untrusted, "192.0.2.1")
+	assert.NotContains(t, untrusted, "203.0.113.9", "the header can be spoofed by any client")
+
+	trusted := clientIP([]string{"192.0.2.0/24"})
+	assert.Contains(t, trusted, "203.0.113.9")
+
+	_, err := api.NewEngine(&config.Config{TrustedProxies: []string{"not-an-ip"}})
+	assert.Error(t, err)
+}
+
+func TestSyncMonitoredRepositoryIsAudited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.ConfigureAdmins([]string{"octocat"})
+	defer auth.ConfigureAdmins(nil)
+
+	db, database := dbtest.Open(t)
+	database.Stub(`FROM "repositories"`, []string{"id", "connection", "full_name", "name", "private", "monitor"},
+		[]driver.Value{int64(5), "ghes", "octo-org/api", "api", true, true})
+
+	// The repository's connection is unknown, so the background sync stops
+	// before reaching GitHub
+	connections, err := github.NewConnections([]config.ConnectionConfig{{Name: config.DefaultConnection}}, github.NewGovernor(0))
+	require.NoError(t, err)
+	poller := github.NewPoller(&dbpkg.Database{Conn: db}, connections, time.Hour, time.Hour)
+
+	router := gin.New()
+	router.POST("/monitored-repositories/:owner/:repo/sync", func(c *gin.Context) {
+		c.Set("db", db)
+		c.Set("user", &models.GitHubUser{ID: 1, Login: "octocat", Type: "User"})
+	}, api.SyncMonitoredRepository(poller))
+
+	req, _ := http.NewRequest("POST", "/monitored-repositories/octo-org/api/sync?connection=ghes", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	args := auditInsert(t, database)
+	assert.Contains(t, args, audit.ActionRepositorySync)
+	assert.Contains(t, args, "5")
+}
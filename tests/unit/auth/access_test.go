@@ -0,0 +1,134 @@
+package auth_test
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestServiceTokensSeeOnlyGrantedRepositories(t *testing.T) {
+	org := &models.GitHubUser{ID: 7, Login: "octo-org", Type: "Organization"}
+	token := &models.APIToken{Kind: auth.TokenKindService, UserID: 7, Organization: "octo-org", RepositoryIDs: []uint{5, 6}}
+
+	viewer := auth.ViewerFor(org, token)
+	assert.Equal(t, auth.Viewer{UserID: 7, Organization: "octo-org", Connection: config.DefaultConnection, Repositories: []uint{5, 6}}, viewer)
+
+	db, database := dbtest.Open(t)
+	var repos []models.Repository
+	require.NoError(t, db.Scopes(auth.ScopeRepositories(viewer)).Find(&repos).Error)
+	statements := database.Statements()
+	require.Len(t, statements, 1)
+	assert.Contains(t, statements[0].SQL, `(private = $1 OR (connection = $2 AND LOWER(owner_name) = LOWER($3) AND id IN ($4,$5)))`)
+	assert.NotContains(t, statements[0].SQL, "split_part")
+	assert.Equal(t, []driver.Value{false, config.DefaultConnection, "octo-org", uint(5), uint(6)}, statements[0].Args,
+		"the organization is the one on github.com")
+
+	// Without grants, only public repositories are visible
+	viewer.Repositories = nil
+	db, database = dbtest.Open(t)
+	require.NoError(t, db.Scopes(auth.ScopeRepositories(viewer)).Find(&repos).Error)
+	assert.Contains(t, database.Statements()[0].SQL, `WHERE private = $1`)
+	assert.NotContains(t, database.Statements()[0].SQL, "repository_accesses")
+
+	// Service tokens of organizations on other connections see their
+	// repositories there
+	token.Connection = "ghes"
+	viewer = auth.ViewerFor(&models.GitHubUser{Login: "octo-org", Type: "Organization", Connection: "ghes"}, token)
+	db, database = dbtest.Open(t)
+	require.NoError(t, db.Scopes(auth.ScopeRepositories(viewer)).Find(&repos).Error)
+	assert.Equal(t, []driver.Value{false, "ghes", "octo-org", uint(5), uint(6)}, database.Statements()[0].Args)
+}
+
+func TestSaveRepositoryPermissionStoresHighestPermission(t *testing.T) {
+	repo := models.Repository{Model: gorm.Model{ID: 5}, FullName: "octo-org/api"}
+
+	tests := []struct {
+		permissions map[string]bool
+		want        string
+	}{
+		{map[string]bool{"pull": true}, "pull"},
+		{map[string]bool{"pull": true, "triage": true}, "triage"},
+		{map[string]bool{"pull": true, "push": true}, "push"},
+		{map[string]bool{"pull": true, "push": true, "maintain": true}, "maintain"},
+		{map[string]bool{"pull": true, "push": true, "admin": true}, "admin"},
+		{map[string]bool{"pull": false, "admin": true}, "admin"},
+	}
+	for _, tt := range tests {
+		db, database := dbtest.Open(t)
+		require.NoError(t, auth.SaveRepositoryPermission(db, 42, repo, tt.permissions))
+
+		writes := database.Writes()
+		require.Len(t, writes, 1)
+		assert.True(t, strings.HasPrefix(writes[0].SQL, `INSERT INTO "repository_accesses"`))
+		assert.Contains(t, writes[0].Args, tt.want, "permissions %v", tt.permissions)
+		assert.Contains(t, writes[0].SQL, `ON CONFLICT ("user_id","repository_id") DO UPDATE`)
+	}
+
+	// Without any permission the cached access is removed
+	db, database := dbtest.Open(t)
+	require.NoError(t, auth.SaveRepositoryPermission(db, 42, repo, map[string]bool{"pull": false}))
+	writes := database.Writes()
+	require.Len(t, writes, 1)
+	assert.True(t, strings.HasPrefix(writes[0].SQL, `DELETE FROM "repository_accesses"`))
+	assert.Equal(t, []driver.Value{int64(42), uint(5)}, writes[0].Args)
+}
+
+// scopedQuery returns the statement run for a query of model limited by
+// scope.
+func scopedQuery(t *testing.T, model interface{}, scope func(*gorm.DB) *gorm.DB) dbtest.Statement {
+	t.Helper()
+	db, database := dbtest.Open(t)
+	require.NoError(t, db.Model(model).Scopes(scope).Find(model).Error)
+	statements := database.Statements()
+	require.Len(t, statements, 1)
+	return statements[0]
+}
+
+func TestVisibleRepositoryIDsForUser(t *testing.T) {
+	user := auth.Viewer{UserID: 42}
+
+	statement := scopedQuery(t, &[]models.Repository{}, auth.ScopeRepositories(user))
+	assert.Contains(t, statement.SQL, `WHERE id IN (SELECT "id" FROM "repositories" WHERE ((private = $1 OR id IN (SELECT "repository_id" FROM "repository_accesses" WHERE user_id = $2`)
+	assert.Equal(t, []driver.Value{false, int64(42)}, statement.Args, "public repositories and the user's own access")
+	assert.NotContains(t, statement.SQL, "owner_name", "users don't see repositories by owner")
+
+	db, _ := dbtest.Open(t)
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&models.WorkflowRun{}).Where("(connection, repository_id) IN (?)", auth.VisibleGitHubRepositoryIDs(tx, user)).
+			Find(&[]models.WorkflowRun{})
+	})
+	assert.Contains(t, sql, `SELECT "connection","repo_id" FROM "repositories" WHERE id IN (SELECT "id" FROM "repositories"`,
+		"runs reference repositories by their connection and GitHub ID")
+}
+
+func TestScopesFollowRepositoryVisibility(t *testing.T) {
+	user := auth.Viewer{UserID: 42}
+	visibleRepoIDs := `SELECT "connection","repo_id" FROM "repositories" WHERE id IN (SELECT "id" FROM "repositories" WHERE ((private = `
+
+	workflows := scopedQuery(t, &[]models.Workflow{}, auth.ScopeWorkflows(user))
+	assert.Contains(t, workflows.SQL, `FROM "workflows" WHERE ((repository_id IN (SELECT "id" FROM "repositories"`)
+	assert.Contains(t, workflows.SQL, `OR (connection, workflow_id) IN (SELECT "connection","workflow_id" FROM "workflow_runs" WHERE (connection, repository_id) IN (`+visibleRepoIDs,
+		"workflows are also visible through runs of visible repositories")
+	assert.Equal(t, []driver.Value{false, int64(42), false, int64(42)}, workflows.Args)
+
+	runs := scopedQuery(t, &[]models.WorkflowRun{}, auth.ScopeWorkflowRuns(user))
+	assert.Contains(t, runs.SQL, `FROM "workflow_runs" WHERE (connection, repository_id) IN (`+visibleRepoIDs,
+		"GitHub IDs are matched on the same connection")
+	assert.Equal(t, []driver.Value{false, int64(42)}, runs.Args)
+
+	jobs := scopedQuery(t, &[]models.Job{}, auth.ScopeJobs(user))
+	assert.Contains(t, jobs.SQL, `FROM "jobs" WHERE (connection, run_id) IN (SELECT "connection","run_id" FROM "workflow_runs" WHERE (connection, repository_id) IN (`+visibleRepoIDs)
+	assert.Equal(t, []driver.Value{false, int64(42)}, jobs.Args)
+
+	steps := scopedQuery(t, &[]models.TaskStep{}, auth.ScopeSteps(user))
+	assert.Contains(t, steps.SQL, `FROM "task_steps" WHERE job_id IN (SELECT "id" FROM "jobs" WHERE (connection, run_id) IN (SELECT "connection","run_id" FROM "workflow_runs"`)
+	assert.Equal(t, []driver.Value{false, int64(42)}, steps.Args)
+}
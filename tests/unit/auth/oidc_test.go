@@ -0,0 +1,156 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider that issues ID
+// tokens for authorization codes handed out by authorize.
+type mockOIDCProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockOIDCProvider{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize simulates the user logging in at the provider and returns the
+// authorization code the provider would redirect back with.
+func (m *mockOIDCProvider) authorize(authURL string) string {
+	u, _ := url.Parse(authURL)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "code-" + u.Query().Get("state")
+	m.codes[code] = u.Query()
+	return code
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	m.mu.Lock()
+	params, ok := m.codes[r.PostForm.Get("code")]
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || params.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":            m.URL,
+		"sub":            "employee-42",
+		"aud":            params.Get("client_id"),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          params.Get("nonce"),
+		"email":          "octocat@example.com",
+		"email_verified": true,
+		"github_login":   "octocat",
+	})
+	signer, _ := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: m.key, KeyID: "test"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	signed, _ := signer.Sign(claims)
+	idToken, _ := signed.CompactSerialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDCProvider(t *testing.T, mock *mockOIDCProvider) *auth.OIDCProvider {
+	provider, err := auth.NewOIDCProvider(context.Background(), config.OIDCConfig{
+		Name:             "sso",
+		IssuerURL:        mock.URL,
+		ClientID:         "aggregator",
+		ClientSecret:     "secret",
+		RedirectURL:      "http://localhost:8080/callback/sso",
+		GitHubLoginClaim: "github_login",
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := newTestOIDCProvider(t, mock)
+	assert.Equal(t, "sso", provider.Name())
+
+	verifier := oauth2.GenerateVerifier()
+	authURL := provider.AuthCodeURL("state-1", "nonce-1", verifier)
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "nonce-1", u.Query().Get("nonce"))
+
+	identity, err := provider.Exchange(context.Background(), mock.authorize(authURL), "nonce-1", verifier)
+	require.NoError(t, err)
+	assert.Equal(t, "employee-42", identity.Subject)
+	assert.Equal(t, "octocat@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "octocat", identity.GitHubLogin)
+}
+
+func TestOIDCProviderRejectsWrongVerifierAndNonce(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := newTestOIDCProvider(t, mock)
+
+	verifier := oauth2.GenerateVerifier()
+	code := mock.authorize(provider.AuthCodeURL("state-2", "nonce-2", verifier))
+
+	_, err := provider.Exchange(context.Background(), code, "nonce-2", oauth2.GenerateVerifier())
+	assert.Error(t, err, "PKCE verifier must match the challenge")
+
+	_, err = provider.Exchange(context.Background(), code, "other-nonce", verifier)
+	assert.Error(t, err, "nonce must match")
+}
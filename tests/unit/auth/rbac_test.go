@@ -0,0 +1,194 @@
+package auth_test
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, auth.RoleAtLeast(auth.RoleAdmin, auth.RoleMaintainer))
+	assert.True(t, auth.RoleAtLeast(auth.RoleMaintainer, auth.RoleMaintainer))
+	assert.False(t, auth.RoleAtLeast(auth.RoleViewer, auth.RoleMaintainer))
+	assert.False(t, auth.RoleAtLeast("", auth.RoleViewer))
+	assert.False(t, auth.ValidRole("owner"))
+}
+
+func TestOrganizationRoleWithoutDatabase(t *testing.T) {
+	auth.ConfigureAdmins([]string{"Octocat"})
+	defer auth.ConfigureAdmins(nil)
+
+	role, err := auth.OrganizationRole(nil, &models.GitHubUser{Login: "octocat", Type: "User"}, "ghes", "octo-org")
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, role, "instance admins are admins of every organization on every connection")
+
+	org := &models.GitHubUser{Login: "octo-org", Type: "Organization"}
+	role, err = auth.OrganizationRole(nil, org, config.DefaultConnection, "Octo-Org")
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, role)
+
+	role, err = auth.OrganizationRole(nil, org, config.DefaultConnection, "other-org")
+	assert.NoError(t, err)
+	assert.Empty(t, role)
+
+	role, err = auth.OrganizationRole(nil, org, "ghes", "octo-org")
+	assert.NoError(t, err)
+	assert.Empty(t, role, "an organization of the same name on another connection is a different one")
+}
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.ConfigureAdmins([]string{"octocat"})
+	defer auth.ConfigureAdmins(nil)
+
+	serve := func(login string) int {
+		router := gin.New()
+		router.GET("/admin", func(c *gin.Context) {
+			c.Set("user", &models.GitHubUser{Login: login, Type: "User"})
+		}, auth.RequireAdmin(), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req, _ := http.NewRequest("GET", "/admin", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("octocat"))
+	assert.Equal(t, http.StatusForbidden, serve("hubot"))
+}
+
+func TestPermissionsRole(t *testing.T) {
+	assert.Equal(t, auth.RoleAdmin, auth.PermissionsRole(map[string]bool{"admin": true, "push": true, "pull": true}))
+	assert.Equal(t, auth.RoleMaintainer, auth.PermissionsRole(map[string]bool{"maintain": true}))
+	assert.Equal(t, auth.RoleMaintainer, auth.PermissionsRole(map[string]bool{"push": true, "pull": true}))
+	assert.Equal(t, auth.RoleViewer, auth.PermissionsRole(map[string]bool{"triage": true}))
+	assert.Empty(t, auth.PermissionsRole(nil))
+}
+
+func TestOrganizationRoleFromMembershipAndBindings(t *testing.T) {
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"}
+
+	db, database := dbtest.Open(t)
+	database.Stub(`FROM "organization_memberships"`, []string{"user_id", "organization", "role"},
+		[]driver.Value{int64(42), "octo-org", "member"})
+	role, err := auth.OrganizationRole(db, user, config.DefaultConnection, "octo-org")
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleViewer, role, "members are viewers")
+
+	// A binding on one of the user's teams raises the role
+	database.Stub(`FROM "role_bindings"`, []string{"organization", "subject_type", "team_slug", "role"},
+		[]driver.Value{"octo-org", auth.SubjectTeam, "platform", auth.RoleMaintainer})
+	role, err = auth.OrganizationRole(db, user, config.DefaultConnection, "octo-org")
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleMaintainer, role)
+
+	// Teams are matched through the user's team memberships in the
+	// organization
+	var bindings *dbtest.Statement
+	for _, statement := range database.Statements() {
+		if strings.Contains(statement.SQL, `FROM "role_bindings"`) {
+			statement := statement
+			bindings = &statement
+		}
+	}
+	require.NotNil(t, bindings)
+	assert.Contains(t, bindings.SQL, `team_slug IN (SELECT "slug" FROM "team_memberships" WHERE (user_id = $5 AND LOWER(organization) = LOWER($6))`)
+	assert.Contains(t, bindings.SQL, "repository_id IS NULL", "organization roles only use organization-wide bindings")
+	assert.Equal(t, []driver.Value{"octo-org", auth.SubjectUser, int64(42), auth.SubjectTeam, int64(42), "octo-org"}, bindings.Args)
+
+	db, database = dbtest.Open(t)
+	database.Stub(`FROM "organization_memberships"`, []string{"user_id", "organization", "role"},
+		[]driver.Value{int64(42), "octo-org", "admin"})
+	role, err = auth.OrganizationRole(db, user, config.DefaultConnection, "octo-org")
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, role, "owners are admins")
+}
+
+func TestRepositoryRole(t *testing.T) {
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"}
+	private := models.Repository{Model: gorm.Model{ID: 5}, Connection: config.DefaultConnection, FullName: "octo-org/api", Private: true}
+	public := models.Repository{Model: gorm.Model{ID: 6}, Connection: config.DefaultConnection, FullName: "octo-org/docs"}
+
+	db, _ := dbtest.Open(t)
+	role, err := auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Empty(t, role, "private repositories need access")
+	role, err = auth.RepositoryRole(db, user, public)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleViewer, role, "everyone views public repositories")
+
+	db, database := dbtest.Open(t)
+	database.Stub(`FROM "repository_accesses"`, []string{"user_id", "repository_id", "permission"},
+		[]driver.Value{int64(42), int64(5), "push"})
+	role, err = auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleMaintainer, role, "push access makes a maintainer")
+
+	db, database = dbtest.Open(t)
+	database.Stub(`AND repository_id = $7`, []string{"organization", "subject_type", "user_id", "repository_id", "role"},
+		[]driver.Value{"octo-org", auth.SubjectUser, int64(42), int64(5), auth.RoleAdmin})
+	role, err = auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, role, "repository bindings apply")
+	assert.True(t, database.Executed("repository_id IS NULL"), "organization bindings apply too")
+}
+
+func TestRepositoryRoleOnOtherConnections(t *testing.T) {
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"}
+	private := models.Repository{Model: gorm.Model{ID: 7}, Connection: "ghes", FullName: "octo-org/api", Private: true}
+	public := models.Repository{Model: gorm.Model{ID: 8}, Connection: "ghes", FullName: "octo-org/docs"}
+
+	db, database := dbtest.Open(t)
+	role, err := auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Empty(t, role)
+	role, err = auth.RepositoryRole(db, user, public)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleViewer, role)
+
+	// Memberships are those synced from the repository's connection, and
+	// github.com role bindings don't apply
+	for _, statement := range database.Statements() {
+		assert.NotContains(t, statement.SQL, `"role_bindings"`)
+		if strings.Contains(statement.SQL, `FROM "organization_memberships"`) {
+			assert.Contains(t, statement.SQL, "connection = $2")
+			assert.Equal(t, "ghes", statement.Args[1])
+		}
+	}
+	assert.True(t, database.Executed(`FROM "organization_memberships"`))
+
+	// A member of octo-org on the connection views its repositories, and
+	// the permissions synced from it apply
+	db, database = dbtest.Open(t)
+	database.Stub(`FROM "organization_memberships"`, []string{"user_id", "connection", "organization", "role"},
+		[]driver.Value{int64(42), "ghes", "octo-org", "member"})
+	role, err = auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleViewer, role)
+
+	database.Stub(`FROM "repository_accesses"`, []string{"user_id", "repository_id", "permission"},
+		[]driver.Value{int64(42), int64(7), "push"})
+	role, err = auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleMaintainer, role)
+
+	auth.ConfigureAdmins([]string{"hubot"})
+	defer auth.ConfigureAdmins(nil)
+	db, _ = dbtest.Open(t)
+	role, err = auth.RepositoryRole(db, user, private)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, role, "instance admins manage every connection")
+}
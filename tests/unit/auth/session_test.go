@@ -0,0 +1,105 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMiddlewareRejectsUnsignedSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.ConfigureSessions(config.SessionConfig{Secret: "test-secret", CookieName: "session"})
+
+	router := gin.New()
+	router.Use(auth.AuthMiddleware())
+	router.GET("/protected", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, value := range []string{"12345", "abc.def", "abc."} {
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: value})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "cookie %q", value)
+	}
+}
+
+func TestLogoutClearsCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.ConfigureSessions(config.SessionConfig{Secret: "test-secret", CookieName: "session", Secure: true, SameSite: "strict"})
+
+	router := gin.New()
+	router.POST("/logout", auth.Logout)
+
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "session", cookies[0].Name)
+		assert.Empty(t, cookies[0].Value)
+		assert.True(t, cookies[0].MaxAge < 0)
+		assert.True(t, cookies[0].Secure)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+	}
+}
+
+func TestLoginCookiesAreLax(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.ConfigureSessions(config.SessionConfig{Secret: "test-secret", CookieName: "session", SameSite: "strict"})
+
+	router := gin.New()
+	router.GET("/login", auth.GitHubLogin)
+
+	req, _ := http.NewRequest("GET", "/login", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	assert.NotEmpty(t, cookies)
+	for _, cookie := range cookies {
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite, "cookie %s", cookie.Name)
+	}
+}
+
+func TestLogoutRejectsCrossSiteRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.ConfigureSessions(config.SessionConfig{Secret: "test-secret", CookieName: "session", SameSite: "none"})
+
+	router := gin.New()
+	router.POST("/logout", auth.RequireSameOrigin(), auth.Logout)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"no headers", nil, http.StatusOK},
+		{"same origin", map[string]string{"Origin": "http://aggregator.example.com", "Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"other origin", map[string]string{"Origin": "https://evil.example.com"}, http.StatusForbidden},
+		{"cross site", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "http://aggregator.example.com/logout", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package badges_test
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/badges"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(sha, conclusion string, created time.Time, duration time.Duration) models.WorkflowRun {
+	r := models.WorkflowRun{HeadSHA: sha, Status: "completed", Conclusion: conclusion}
+	r.CreatedAt = created
+	r.UpdatedAt = created.Add(duration)
+	return r
+}
+
+func TestBadgeSVG(t *testing.T) {
+	svg, err := badges.Badge{Label: "CI", Message: "97% (30d)", Color: badges.ColorBrightGreen}.SVG()
+	require.NoError(t, err)
+
+	assert.NoError(t, xml.Unmarshal(svg, new(interface{})), "badge is well-formed XML")
+	assert.Contains(t, string(svg), "97% (30d)")
+	assert.Contains(t, string(svg), `fill="#4c1"`)
+}
+
+func TestBadgeSVGEscapesText(t *testing.T) {
+	svg, err := badges.Badge{Label: "<script>", Message: "a&b", Color: badges.ColorRed}.SVG()
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(svg), "<script>")
+	assert.NoError(t, xml.Unmarshal(svg, new(interface{})))
+}
+
+func TestForRuns(t *testing.T) {
+	now := time.Now()
+	runs := []models.WorkflowRun{
+		run("a", "failure", now.Add(-3*time.Hour), 2*time.Minute),
+		run("a", "success", now.Add(-2*time.Hour), 4*time.Minute),
+		run("b", "success", now.Add(-time.Hour), 6*time.Minute),
+		run("c", "failure", now, 10*time.Minute),
+		{Status: "in_progress", HeadSHA: "d"},
+	}
+
+	status := badges.ForRuns(badges.MetricStatus, "CI", runs, 30)
+	assert.Equal(t, "failing", status.Message)
+	assert.Equal(t, badges.ColorRed, status.Color)
+
+	rate := badges.ForRuns(badges.MetricSuccessRate, "CI", runs, 30)
+	assert.Equal(t, "50% (30d)", rate.Message)
+
+	duration := badges.ForRuns(badges.MetricDuration, "CI", runs, 7)
+	assert.Equal(t, "5m 0s (7d)", duration.Message)
+
+	flakiness := badges.ForRuns(badges.MetricFlakiness, "CI", runs, 30)
+	assert.Equal(t, "33% (30d)", flakiness.Message)
+
+	empty := badges.ForRuns(badges.MetricSuccessRate, "CI", nil, 30)
+	assert.Equal(t, "no runs", empty.Message)
+}
+
+func TestParseMetric(t *testing.T) {
+	metric, err := badges.ParseMetric("success_rate")
+	assert.NoError(t, err)
+	assert.Equal(t, badges.MetricSuccessRate, metric)
+
+	_, err = badges.ParseMetric("coverage")
+	assert.Error(t, err)
+}
+
+func TestToken(t *testing.T) {
+	token := badges.Token("secret", "octo/private")
+
+	assert.True(t, badges.VerifyToken("secret", "octo/private", token))
+	assert.False(t, badges.VerifyToken("secret", "octo/other", token))
+	assert.False(t, badges.VerifyToken("rotated", "octo/private", token))
+	assert.False(t, badges.VerifyToken("", "octo/private", badges.Token("", "octo/private")))
+}
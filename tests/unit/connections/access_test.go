@@ -0,0 +1,93 @@
+package connections_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncConnectionAccessOfMappedUsers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v3/repos/octo-org/api/collaborators":
+			w.Write([]byte(`[
+				{"login": "hubot-corp", "permissions": {"pull": true}},
+				{"login": "someone-else", "permissions": {"admin": true, "push": true, "pull": true}}
+			]`))
+		case r.URL.Path == "/api/v3/orgs/octo-org":
+			w.Write([]byte(`{"id": 9, "login": "octo-org"}`))
+		case r.URL.Path == "/api/v3/orgs/octo-org/members" && r.URL.Query().Get("role") == "member":
+			w.Write([]byte(`[{"login": "HUBOT-corp"}]`))
+		case r.URL.Path == "/api/v3/orgs/octo-org/members":
+			w.Write([]byte(`[]`))
+		default:
+			// octo-org/legacy can't be listed
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	connections, err := github.NewConnections([]config.ConnectionConfig{{
+		Name: "ghes", BaseURL: server.URL + "/api/v3/", AccessToken: "ghes-token",
+		Users: map[string]string{"Hubot": "hubot-corp", "mona": "mona-corp"},
+	}}, github.NewGovernor(0))
+	require.NoError(t, err)
+	connection, err := connections.Get("ghes")
+	require.NoError(t, err)
+
+	// hubot logged in, mona never did
+	conn, database := dbtest.Open(t)
+	database.Stub(`FROM "git_hub_users"`, []string{"id", "login", "type"},
+		[]driver.Value{int64(42), "hubot", "User"})
+	database.Stub(`FROM "repositories"`, []string{"id", "connection", "full_name", "name"},
+		[]driver.Value{int64(5), "ghes", "octo-org/api", "api"},
+		[]driver.Value{int64(6), "ghes", "octo-org/legacy", "legacy"})
+
+	syncer := github.NewAccessSyncer(&db.Database{Conn: conn}, connections, 0)
+	require.NoError(t, syncer.SyncConnection(context.Background(), connection))
+
+	var access, membership []dbtest.Statement
+	var deletes []dbtest.Statement
+	for _, statement := range database.Writes() {
+		switch {
+		case strings.HasPrefix(statement.SQL, `INSERT INTO "repository_accesses"`):
+			access = append(access, statement)
+		case strings.HasPrefix(statement.SQL, `INSERT INTO "organization_memberships"`):
+			membership = append(membership, statement)
+		case strings.HasPrefix(statement.SQL, "DELETE"):
+			deletes = append(deletes, statement)
+		}
+	}
+
+	// The non-admin viewer gets read access and the member role on ghes
+	require.Len(t, access, 1, "only mapped users are stored")
+	assert.Contains(t, access[0].Args, int64(42))
+	assert.Contains(t, access[0].Args, uint(5))
+	assert.Contains(t, access[0].Args, "pull")
+	assert.NotContains(t, access[0].Args, "admin")
+
+	require.Len(t, membership, 1)
+	assert.Contains(t, membership[0].Args, int64(42))
+	assert.Contains(t, membership[0].Args, "ghes")
+	assert.Contains(t, membership[0].Args, "octo-org")
+	assert.Contains(t, membership[0].Args, "member")
+
+	// Stored access of the connection is replaced, except for octo-org/legacy
+	require.Len(t, deletes, 2)
+	assert.Contains(t, deletes[0].SQL, `"repository_accesses"`)
+	assert.Contains(t, deletes[0].Args, "ghes")
+	assert.Contains(t, deletes[0].Args, uint(6), "repositories that can't be listed keep their access")
+	assert.Contains(t, deletes[1].SQL, `"organization_memberships"`)
+	assert.Equal(t, []driver.Value{"ghes"}, deletes[1].Args)
+}
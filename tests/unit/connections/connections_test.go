@@ -0,0 +1,123 @@
+package connections_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubConnectionsStartWithDefault(t *testing.T) {
+	cfg := &config.Config{
+		GitHub:      config.GitHubConfig{AccessToken: "public-token", WebhookSecret: "public-secret"},
+		Connections: []config.ConnectionConfig{{Name: "ghes", BaseURL: "https://github.example.com/api/v3/"}},
+	}
+
+	connections := cfg.GitHubConnections()
+	require.Len(t, connections, 2)
+	assert.Equal(t, config.DefaultConnection, connections[0].Name)
+	assert.Equal(t, "public-token", connections[0].AccessToken)
+	assert.Equal(t, "public-secret", connections[0].WebhookSecret)
+	assert.Equal(t, "ghes", connections[1].Name)
+}
+
+func TestNewConnections(t *testing.T) {
+	connections, err := github.NewConnections([]config.ConnectionConfig{
+		{Name: config.DefaultConnection, AccessToken: "a"},
+		{Name: "ghes", BaseURL: "https://github.example.com/api/v3/", AccessToken: "b", WebhookSecret: "s"},
+	}, github.NewGovernor(0))
+	require.NoError(t, err)
+
+	connection, err := connections.Get("")
+	require.NoError(t, err)
+	assert.Equal(t, config.DefaultConnection, connection.Name, "empty name is the default connection")
+
+	connection, err = connections.Get("ghes")
+	require.NoError(t, err)
+	assert.Equal(t, "s", connection.WebhookSecret)
+
+	_, err = connections.Get("unknown")
+	assert.Error(t, err)
+
+	all := connections.All()
+	require.Len(t, all, 2)
+	assert.Equal(t, "ghes", all[0].Name)
+	assert.Equal(t, config.DefaultConnection, all[1].Name)
+}
+
+func TestNewConnectionsRejectsInvalidConfig(t *testing.T) {
+	_, err := github.NewConnections([]config.ConnectionConfig{{Name: ""}}, github.NewGovernor(0))
+	assert.Error(t, err, "name is required")
+
+	_, err = github.NewConnections([]config.ConnectionConfig{{Name: "ghes"}, {Name: "ghes"}}, github.NewGovernor(0))
+	assert.Error(t, err, "names must be unique")
+}
+
+func TestEnterpriseConnectionUsesBaseURL(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/octo-org/api" {
+			http.NotFound(w, r)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 7, "name": "api", "full_name": "octo-org/api"}`))
+	}))
+	defer server.Close()
+
+	connections, err := github.NewConnections([]config.ConnectionConfig{
+		{Name: "ghes", BaseURL: server.URL + "/api/v3/", AccessToken: "ghes-token"},
+	}, github.NewGovernor(0))
+	require.NoError(t, err)
+	connection, err := connections.Get("ghes")
+	require.NoError(t, err)
+
+	repo, err := connection.Client.GetRepository("octo-org", "api")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), repo.GetID())
+	assert.Equal(t, "Bearer ghes-token", authorization)
+}
+
+func TestSavesAreKeyedByConnection(t *testing.T) {
+	conn, database := dbtest.Open(t)
+	store := &db.Database{Conn: conn}
+
+	require.NoError(t, store.SaveWorkflowRun("ghes", &gh.WorkflowRun{ID: gh.Int64(1), RunAttempt: gh.Int(1)}, ""))
+	require.NoError(t, store.SaveWorkflowJob("ghes", &gh.WorkflowJob{ID: gh.Int64(2), RunID: gh.Int64(1)}))
+	repository := models.Repository{Connection: "ghes"}
+	repository.ID = 5
+	require.NoError(t, store.SaveRepositoryWorkflow(repository, &gh.Workflow{ID: gh.Int64(3)}))
+
+	// GitHub IDs are only unique per instance, so lookups match the connection
+	for _, table := range []string{"workflow_runs", "jobs", "workflows"} {
+		var found bool
+		for _, statement := range database.Statements() {
+			if strings.HasPrefix(statement.SQL, `SELECT`) && strings.Contains(statement.SQL, `FROM "`+table+`" WHERE (connection = $1 AND`) {
+				found = true
+				assert.Equal(t, "ghes", statement.Args[0])
+			}
+		}
+		assert.True(t, found, "%s are looked up by connection", table)
+	}
+
+	// and the rows are saved with it
+	writes := database.Writes()
+	require.NotEmpty(t, writes)
+	for _, statement := range writes {
+		if strings.HasPrefix(statement.SQL, `INSERT INTO "workflow_runs"`) || strings.HasPrefix(statement.SQL, `INSERT INTO "jobs"`) ||
+			strings.HasPrefix(statement.SQL, `INSERT INTO "workflows"`) {
+			assert.Contains(t, statement.SQL, `"connection"`)
+			assert.Contains(t, statement.Args, "ghes")
+		}
+	}
+}
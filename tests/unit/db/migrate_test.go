@@ -0,0 +1,55 @@
+package db_test
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// index returns the position of the first statement containing match, or -1.
+func index(statements []dbtest.Statement, match string) int {
+	for i, statement := range statements {
+		if strings.Contains(statement.SQL, match) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestMigrateFromTablesWithoutRunIDs(t *testing.T) {
+	conn, database := dbtest.Open(t)
+	// The tables exist, with none of the columns added since
+	database.Stub(`FROM information_schema.tables WHERE`, []string{"count"}, []driver.Value{int64(1)})
+	database.Stub(`FROM INFORMATION_SCHEMA.columns WHERE`, []string{"count"}, []driver.Value{int64(0)})
+
+	require.NoError(t, db.Migrate(conn))
+
+	statements := database.Statements()
+	assert.Equal(t, -1, index(statements, `run_id = 0`), "rows aren't matched on a column that doesn't exist yet")
+
+	cleared := index(statements, `DELETE FROM workflow_runs`)
+	added := index(statements, `ALTER TABLE "workflow_runs" ADD "run_id"`)
+	indexed := index(statements, `"idx_workflow_runs_connection_run_attempt"`)
+	require.NotEqual(t, -1, cleared, "runs without run IDs are removed")
+	require.NotEqual(t, -1, added)
+	require.NotEqual(t, -1, indexed)
+	assert.Less(t, cleared, added)
+	assert.Less(t, cleared, indexed, "the rows are gone before the unique index is created")
+}
+
+func TestMigrateRemovesDuplicateAttempts(t *testing.T) {
+	conn, database := dbtest.Open(t)
+	database.Stub(`FROM information_schema.tables WHERE`, []string{"count"}, []driver.Value{int64(1)})
+	database.Stub(`FROM INFORMATION_SCHEMA.columns WHERE`, []string{"count"}, []driver.Value{int64(1)})
+
+	require.NoError(t, db.Migrate(conn))
+
+	statements := database.Statements()
+	assert.NotEqual(t, -1, index(statements, `DELETE FROM workflow_runs WHERE run_id = 0 OR run_id IS NULL`))
+	assert.NotEqual(t, -1, index(statements, `newer.connection = workflow_runs.connection AND newer.id > workflow_runs.id`))
+}
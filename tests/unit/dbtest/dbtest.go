@@ -0,0 +1,176 @@
+// Package dbtest provides a scripted database for unit tests of code that
+// queries through gorm. Queries return the rows stubbed for them, or none,
+// and every statement is recorded so tests can check what was written.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Statement is a query or command run against the database.
+type Statement struct {
+	SQL  string
+	Args []driver.Value
+}
+
+type stub struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+// Database records the statements run against it and answers queries from
+// its stubs.
+type Database struct {
+	mu         sync.Mutex
+	stubs      []stub
+	statements []Statement
+}
+
+// Open returns a gorm connection, using the Postgres dialect, to a new
+// scripted database.
+func Open(t *testing.T) (*gorm.DB, *Database) {
+	t.Helper()
+	database := &Database{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(database)}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("failed to open scripted database: %v", err)
+	}
+	return db, database
+}
+
+// Stub makes queries containing match return the given rows. The first stub
+// that matches a query is used.
+func (d *Database) Stub(match string, columns []string, rows ...[]driver.Value) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stubs = append(d.stubs, stub{match: match, columns: columns, rows: rows})
+}
+
+// Statements returns the statements run so far.
+func (d *Database) Statements() []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Statement(nil), d.statements...)
+}
+
+// Writes returns the inserts, updates and deletes run so far.
+func (d *Database) Writes() []Statement {
+	var writes []Statement
+	for _, statement := range d.Statements() {
+		verb, _, _ := strings.Cut(strings.TrimSpace(statement.SQL), " ")
+		switch strings.ToUpper(verb) {
+		case "INSERT", "UPDATE", "DELETE":
+			writes = append(writes, statement)
+		}
+	}
+	return writes
+}
+
+// Executed reports whether a statement containing match was run.
+func (d *Database) Executed(match string) bool {
+	for _, statement := range d.Statements() {
+		if strings.Contains(statement.SQL, match) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Database) record(query string, args []driver.NamedValue) stub {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	d.statements = append(d.statements, Statement{SQL: query, Args: values})
+	for _, s := range d.stubs {
+		if strings.Contains(query, s.match) {
+			return s
+		}
+	}
+	return stub{}
+}
+
+// Connect implements driver.Connector.
+func (d *Database) Connect(context.Context) (driver.Conn, error) {
+	return &conn{database: d}, nil
+}
+
+// Driver implements driver.Connector.
+func (d *Database) Driver() driver.Driver {
+	return scriptedDriver{}
+}
+
+type scriptedDriver struct{}
+
+func (scriptedDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("dbtest: open with dbtest.Open")
+}
+
+type conn struct {
+	database *Database
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("dbtest: prepared statements are not supported")
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return tx{}, nil }
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s := c.database.record(query, args)
+	return &rows{columns: s.columns, values: s.rows}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.database.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+// CheckNamedValue accepts every argument as is, so slices and other values
+// gorm passes through reach the recorded statement.
+func (c *conn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+
+func (r *rows) Close() error { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
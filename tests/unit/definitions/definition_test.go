@@ -0,0 +1,144 @@
+package definitions_test
+
+import (
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ciWorkflow = `
+name: CI
+on:
+  push:
+    branches: [main]
+  pull_request:
+permissions:
+  contents: read
+jobs:
+  test:
+    runs-on: ${{ matrix.os }}
+    strategy:
+      matrix:
+        os: [ubuntu-latest, macos-latest]
+        go: ["1.22", "1.23"]
+        include:
+          - os: windows-latest
+            go: "1.23"
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+      - run: go test ./...
+  deploy:
+    needs: test
+    runs-on:
+      group: deployers
+      labels: [self-hosted, linux]
+    permissions: write-all
+    uses: octo-org/workflows/.github/workflows/deploy.yml@main
+`
+
+func TestParse(t *testing.T) {
+	definition, err := definitions.Parse([]byte(ciWorkflow))
+	require.NoError(t, err)
+
+	assert.Equal(t, "CI", definition.Name)
+	assert.Equal(t, []string{"pull_request", "push"}, definition.Triggers)
+	assert.Equal(t, map[string]string{"contents": "read"}, definition.Permissions)
+	require.Len(t, definition.Jobs, 2)
+
+	deploy := definition.Jobs[0]
+	assert.Equal(t, "deploy", deploy.ID)
+	assert.Equal(t, []string{"group:deployers", "self-hosted", "linux"}, deploy.RunsOn)
+	assert.Equal(t, []string{"test"}, deploy.Needs)
+	assert.Equal(t, map[string]string{"*": "write-all"}, deploy.Permissions)
+	assert.Equal(t, []string{"octo-org/workflows/.github/workflows/deploy.yml@main"}, deploy.Uses)
+
+	test := definition.Jobs[1]
+	assert.Equal(t, "test", test.ID)
+	assert.Equal(t, []string{"${{ matrix.os }}"}, test.RunsOn)
+	assert.Equal(t, map[string][]string{
+		"os": {"ubuntu-latest", "macos-latest"},
+		"go": {"1.22", "1.23"},
+	}, test.Matrix)
+	assert.Equal(t, []string{"os", "go"}, test.Dimensions)
+	assert.Equal(t, []string{"actions/checkout@v4", "actions/setup-go@v5"}, test.Uses)
+
+	assert.Equal(t, []string{
+		"actions/checkout@v4",
+		"actions/setup-go@v5",
+		"octo-org/workflows/.github/workflows/deploy.yml@main",
+	}, definition.Actions())
+}
+
+func TestParseTriggerForms(t *testing.T) {
+	definition, err := definitions.Parse([]byte("on: push\njobs: {}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"push"}, definition.Triggers)
+
+	definition, err = definitions.Parse([]byte("on: [push, workflow_dispatch]\njobs: {}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"push", "workflow_dispatch"}, definition.Triggers)
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := definitions.Parse([]byte("jobs: [unclosed"))
+	assert.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	before := definitions.Definition{
+		Name:     "CI",
+		Triggers: []string{"push"},
+		Jobs: []definitions.Job{
+			{ID: "build", RunsOn: []string{"ubuntu-22.04"}, Uses: []string{"actions/checkout@v3"}},
+			{ID: "lint", RunsOn: []string{"ubuntu-22.04"}},
+		},
+	}
+	after := definitions.Definition{
+		Name:     "CI",
+		Triggers: []string{"pull_request", "push"},
+		Jobs: []definitions.Job{
+			{ID: "build", RunsOn: []string{"ubuntu-24.04"}, Uses: []string{"actions/checkout@v3"}},
+			{ID: "test", RunsOn: []string{"ubuntu-24.04"}},
+		},
+	}
+
+	changes := definitions.Diff(before, after)
+	fields := make([]string, 0, len(changes))
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	assert.Equal(t, []string{"triggers", "jobs.build.runs_on", "jobs.lint", "jobs.test"}, fields)
+
+	assert.Equal(t, []string{"ubuntu-22.04"}, changes[1].Before)
+	assert.Equal(t, []string{"ubuntu-24.04"}, changes[1].After)
+	assert.NotNil(t, changes[2].Before)
+	assert.Nil(t, changes[2].After, "removed job")
+	assert.Nil(t, changes[3].Before, "added job")
+}
+
+func TestDiffIdentical(t *testing.T) {
+	definition, err := definitions.Parse([]byte(ciWorkflow))
+	require.NoError(t, err)
+	assert.Empty(t, definitions.Diff(definition, definition))
+}
+
+func TestDefinitionChangesKeepsReverts(t *testing.T) {
+	commits := []models.WorkflowDefinitionCommit{
+		{CommitSHA: "a1", DefinitionID: 1},
+		{CommitSHA: "a2", DefinitionID: 1},
+		{CommitSHA: "b1", DefinitionID: 2},
+		{CommitSHA: "a3", DefinitionID: 1},
+	}
+
+	changes := models.DefinitionChanges(commits)
+	var shas []string
+	for _, commit := range changes {
+		shas = append(shas, commit.CommitSHA)
+	}
+	assert.Equal(t, []string{"a1", "b1", "a3"}, shas)
+	assert.Empty(t, models.DefinitionChanges(nil))
+}
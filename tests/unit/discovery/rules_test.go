@@ -0,0 +1,101 @@
+package discovery_test
+
+import (
+	"testing"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func repository(name string, topics ...string) *gh.Repository {
+	return &gh.Repository{
+		Name:       gh.String(name),
+		FullName:   gh.String("octo-org/" + name),
+		Owner:      &gh.User{Login: gh.String("octo-org")},
+		Visibility: gh.String("public"),
+		Topics:     topics,
+	}
+}
+
+func TestRuleMatchesNamePatterns(t *testing.T) {
+	rule := discovery.Rule{
+		Organization: "Octo-Org",
+		Include:      []string{"service-*", "re:^lib-[a-z]+$"},
+		Exclude:      []string{"*-sandbox"},
+	}
+	assert.True(t, rule.Matches(repository("service-api")))
+	assert.True(t, rule.Matches(repository("lib-core")))
+	assert.False(t, rule.Matches(repository("lib-core2")), "regex must match")
+	assert.False(t, rule.Matches(repository("service-sandbox")), "exclude wins")
+	assert.False(t, rule.Matches(repository("website")))
+
+	other := repository("service-api")
+	other.Owner.Login = gh.String("other-org")
+	assert.False(t, rule.Matches(other), "only repositories of the organization match")
+}
+
+func TestRuleMatchesWholeNamesIgnoringCase(t *testing.T) {
+	rule := discovery.Rule{
+		Organization: "octo-org",
+		Include:      []string{"re:lib-[a-z-]+", "API-*"},
+		Exclude:      []string{"re:.*-SANDBOX"},
+	}
+	assert.True(t, rule.Matches(repository("lib-core")))
+	assert.True(t, rule.Matches(repository("Lib-Core")), "regular expressions ignore case like globs")
+	assert.True(t, rule.Matches(repository("api-gateway")))
+	assert.False(t, rule.Matches(repository("old-lib-core")), "regular expressions match the whole name")
+	assert.False(t, rule.Matches(repository("lib-core2")))
+	assert.False(t, rule.Matches(repository("lib-core-sandbox")), "exclude regular expressions ignore case")
+
+	alternatives := discovery.Rule{Organization: "octo-org", Include: []string{"re:web|docs"}}
+	assert.True(t, alternatives.Matches(repository("docs")))
+	assert.False(t, alternatives.Matches(repository("webhooks")), "every alternative is anchored")
+}
+
+func TestRuleMatchesTopicsAndVisibility(t *testing.T) {
+	rule := discovery.Rule{Organization: "octo-org", Topics: []string{"ci"}, ExcludeTopics: []string{"deprecated"}}
+	assert.True(t, rule.Matches(repository("api", "go", "CI")))
+	assert.False(t, rule.Matches(repository("api", "go")))
+	assert.False(t, rule.Matches(repository("api", "ci", "deprecated")))
+
+	private := repository("api")
+	private.Visibility = gh.String("private")
+	rule = discovery.Rule{Organization: "octo-org", Visibility: "private"}
+	assert.True(t, rule.Matches(private))
+	assert.False(t, rule.Matches(repository("api")))
+}
+
+func TestRuleSkipsArchivedAndForks(t *testing.T) {
+	archived := repository("old")
+	archived.Archived = gh.Bool(true)
+	fork := repository("upstream")
+	fork.Fork = gh.Bool(true)
+
+	rule := discovery.Rule{Organization: "octo-org"}
+	assert.False(t, rule.Matches(archived))
+	assert.False(t, rule.Matches(fork))
+
+	rule.IncludeArchived, rule.IncludeForks = true, true
+	assert.True(t, rule.Matches(archived))
+	assert.True(t, rule.Matches(fork))
+}
+
+func TestRuleValidate(t *testing.T) {
+	assert.NoError(t, discovery.Rule{Organization: "octo-org", Include: []string{"svc-*"}}.Validate())
+	assert.Error(t, discovery.Rule{}.Validate(), "organization is required")
+	assert.Error(t, discovery.Rule{Organization: "octo-org", Visibility: "secret"}.Validate())
+	assert.Error(t, discovery.Rule{Organization: "octo-org", Include: []string{"re:("}}.Validate())
+	assert.Error(t, discovery.Rule{Organization: "octo-org", Exclude: []string{"[a-"}}.Validate())
+}
+
+func TestMatchesAny(t *testing.T) {
+	rules := []discovery.Rule{
+		{Organization: "octo-org", Include: []string{"api"}},
+		{Organization: "octo-org", Topics: []string{"ci"}},
+	}
+	assert.True(t, discovery.MatchesAny(rules, repository("api")))
+	assert.True(t, discovery.MatchesAny(rules, repository("web", "ci")))
+	assert.False(t, discovery.MatchesAny(rules, repository("web")))
+	assert.False(t, discovery.MatchesAny(nil, repository("api")))
+}
@@ -0,0 +1,99 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMatches(t *testing.T) {
+	event := events.Event{Repository: "octo/repo", Workflow: "CI", Branch: "main"}
+
+	assert.True(t, events.Filter{}.Matches(event))
+	assert.True(t, events.Filter{Repositories: []string{"octo/other", "octo/repo"}}.Matches(event))
+	assert.True(t, events.Filter{Workflows: []string{"CI"}, Branches: []string{"main"}}.Matches(event))
+	assert.False(t, events.Filter{Branches: []string{"develop"}}.Matches(event))
+	assert.False(t, events.Filter{Repositories: []string{"octo/repo"}, Workflows: []string{"Release"}}.Matches(event))
+}
+
+func TestFilterAllowedRepositories(t *testing.T) {
+	event := events.Event{Connection: "github.com", Repository: "octo/private"}
+	allowed := events.NewAllowlist([]events.RepositoryKey{{Connection: "github.com", FullName: "octo/private"}})
+
+	assert.True(t, events.Filter{AllowedRepositories: allowed}.Matches(event))
+	assert.False(t, events.Filter{AllowedRepositories: events.NewAllowlist(nil)}.Matches(event))
+	assert.False(t, events.Filter{
+		Repositories:        []string{"octo/private"},
+		AllowedRepositories: events.NewAllowlist([]events.RepositoryKey{{Connection: "github.com", FullName: "octo/public"}}),
+	}.Matches(event))
+
+	// A repository of the same name on another connection is a different one
+	ghes := events.Event{Connection: "ghes", Repository: "octo/private"}
+	assert.False(t, events.Filter{AllowedRepositories: allowed}.Matches(ghes))
+}
+
+func TestAllowlistReplaceAppliesToSubscriptions(t *testing.T) {
+	broker := events.NewBroker()
+	allowed := events.NewAllowlist([]events.RepositoryKey{{Connection: "github.com", FullName: "octo/private"}})
+	sub := broker.Subscribe(events.Filter{AllowedRepositories: allowed})
+
+	event := events.Event{Connection: "github.com", Repository: "octo/private"}
+	broker.Publish(event)
+	assert.Len(t, sub.C, 1)
+
+	// Access was revoked
+	allowed.Replace(nil)
+	broker.Publish(event)
+	assert.Len(t, sub.C, 1, "events of repositories no longer allowed aren't delivered")
+
+	allowed.Replace([]events.RepositoryKey{{Connection: "ghes", FullName: "octo/private"}})
+	broker.Publish(events.Event{Connection: "ghes", Repository: "octo/private"})
+	assert.Len(t, sub.C, 2)
+}
+
+func TestBrokerPublish(t *testing.T) {
+	broker := events.NewBroker()
+	mainSub := broker.Subscribe(events.Filter{Branches: []string{"main"}})
+	allSub := broker.Subscribe(events.Filter{})
+
+	broker.Publish(events.Event{Type: events.TypeWorkflowRun, Branch: "feature"})
+	broker.Publish(events.Event{Type: events.TypeWorkflowJob, Branch: "main"})
+
+	select {
+	case event := <-mainSub.C:
+		assert.Equal(t, events.TypeWorkflowJob, event.Type)
+		assert.False(t, event.Timestamp.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("expected event for main branch")
+	}
+	assert.Len(t, allSub.C, 2)
+
+	broker.Unsubscribe(mainSub)
+	_, ok := <-mainSub.C
+	assert.False(t, ok, "channel is closed after unsubscribe")
+
+	// Unsubscribing twice must not panic.
+	broker.Unsubscribe(mainSub)
+}
+
+func TestBrokerDropsEventsForSlowSubscribers(t *testing.T) {
+	broker := events.NewBroker()
+	sub := broker.Subscribe(events.Filter{})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			broker.Publish(events.Event{})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+	assert.NotZero(t, len(sub.C))
+}
@@ -0,0 +1,95 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/export"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRuns = []export.RunRecord{
+	{
+		RunID:      1,
+		Repository: "octo/repo",
+		Connection: "github.com",
+		Name:       "CI",
+		HeadBranch: "main",
+		Status:     "completed",
+		Conclusion: "success",
+		RunNumber:  10,
+		CreatedAt:  time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC),
+		UpdatedAt:  time.Date(2024, 10, 1, 12, 5, 0, 0, time.UTC),
+	},
+	{
+		RunID:      2,
+		Repository: "octo/repo",
+		Connection: "ghes",
+		Name:       "CI, nightly",
+		Status:     "completed",
+		Conclusion: "failure",
+		CreatedAt:  time.Date(2024, 10, 2, 12, 0, 0, 0, time.UTC),
+	},
+}
+
+func encode(t *testing.T, format export.Format) *bytes.Buffer {
+	var buf bytes.Buffer
+	enc, err := export.NewEncoder[export.RunRecord](&buf, format)
+	require.NoError(t, err)
+	for _, run := range testRuns {
+		require.NoError(t, enc.Encode(run))
+	}
+	require.NoError(t, enc.Close())
+	return &buf
+}
+
+func TestCSVEncoder(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(encode(t, export.FormatCSV).String()), "\n")
+
+	require.Len(t, lines, 3)
+	assert.Equal(t, "run_id,repository,connection,workflow_id,name,head_branch,head_sha,event,status,conclusion,run_number,run_attempt,created_at,updated_at", lines[0])
+	assert.Equal(t, "1,octo/repo,github.com,0,CI,main,,,completed,success,10,0,2024-10-01T12:00:00Z,2024-10-01T12:05:00Z", lines[1])
+	assert.Equal(t, `2,octo/repo,ghes,0,"CI, nightly",,,,completed,failure,0,0,2024-10-02T12:00:00Z,`, lines[2])
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(encode(t, export.FormatNDJSON).String()), "\n")
+
+	require.Len(t, lines, 2)
+	var run export.RunRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &run))
+	assert.Equal(t, testRuns[1], run)
+}
+
+func TestParquetEncoder(t *testing.T) {
+	buf := encode(t, export.FormatParquet)
+
+	rows, err := parquet.Read[export.RunRecord](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "CI, nightly", rows[1].Name)
+	assert.True(t, testRuns[0].CreatedAt.Equal(rows[0].CreatedAt))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	now := time.Now()
+	valid := export.Options{Dataset: export.DatasetJobs, Format: export.FormatCSV, Since: now.Add(-time.Hour), Until: now}
+	assert.NoError(t, valid.Validate())
+
+	invalid := valid
+	invalid.Dataset = "users"
+	assert.Error(t, invalid.Validate())
+
+	invalid = valid
+	invalid.Format = "xml"
+	assert.Error(t, invalid.Validate())
+
+	invalid = valid
+	invalid.Since = now.Add(time.Hour)
+	assert.Error(t, invalid.Validate())
+}
@@ -0,0 +1,236 @@
+package graphql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/graphql"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var repositoryColumns = []string{"id", "full_name", "name", "private"}
+
+func repositoryRows(n int) [][]driver.Value {
+	rows := make([][]driver.Value, 0, n)
+	for id := 1; id <= n; id++ {
+		name := fmt.Sprintf("repo-%d", id)
+		rows = append(rows, []driver.Value{int64(id), "octo-org/" + name, name, false})
+	}
+	return rows
+}
+
+type result struct {
+	Repositories struct {
+		TotalCount int
+		PageInfo   struct {
+			HasNextPage bool
+			EndCursor   string
+		}
+		Edges []struct {
+			Cursor string
+			Node   struct{ FullName string }
+		}
+	}
+}
+
+func run(t *testing.T, query string, setup func(*dbtest.Database)) (result, []string, *dbtest.Database) {
+	t.Helper()
+	conn, database := dbtest.Open(t)
+	if setup != nil {
+		setup(database)
+	}
+	schema, err := graphql.NewSchema(conn)
+	require.NoError(t, err)
+
+	ctx := auth.WithUser(context.Background(), &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"})
+	resp := schema.Exec(ctx, query, "", nil)
+
+	var errs []string
+	for _, err := range resp.Errors {
+		errs = append(errs, err.Message)
+	}
+	var data result
+	if resp.Data != nil {
+		require.NoError(t, json.Unmarshal(resp.Data, &data))
+	}
+	return data, errs, database
+}
+
+// selecting returns the queries run against a table.
+func selecting(database *dbtest.Database, table string) []dbtest.Statement {
+	var statements []dbtest.Statement
+	for _, statement := range database.Statements() {
+		if strings.Contains(statement.SQL, `FROM "`+table+`" WHERE`) && strings.HasPrefix(statement.SQL, "SELECT") {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func TestRepositoriesAreScopedToUser(t *testing.T) {
+	_, errs, database := run(t, `{ repositories { totalCount edges { node { fullName } } } }`, nil)
+	require.Empty(t, errs)
+
+	statements := selecting(database, "repositories")
+	require.Len(t, statements, 2, "the count and the page")
+	for _, statement := range statements {
+		assert.Contains(t, statement.SQL, `"repository_accesses"`)
+		assert.Contains(t, statement.Args, int64(42), "visibility is checked for the user in the context")
+	}
+}
+
+func TestQueriesRequireUser(t *testing.T) {
+	conn, database := dbtest.Open(t)
+	schema, err := graphql.NewSchema(conn)
+	require.NoError(t, err)
+
+	resp := schema.Exec(context.Background(), `{ repositories { totalCount } }`, "", nil)
+	require.Len(t, resp.Errors, 1)
+	assert.Contains(t, resp.Errors[0].Message, "unauthorized")
+	assert.Empty(t, database.Statements())
+}
+
+func TestRepositoriesFirstPage(t *testing.T) {
+	data, errs, database := run(t, `{
+		repositories(first: 2) {
+			totalCount
+			pageInfo { hasNextPage endCursor }
+			edges { cursor node { fullName } }
+		}
+	}`, func(database *dbtest.Database) {
+		database.Stub(`SELECT count(*) FROM "repositories"`, []string{"count"}, []driver.Value{int64(3)})
+		database.Stub(`SELECT * FROM "repositories"`, repositoryColumns, repositoryRows(3)...)
+	})
+	require.Empty(t, errs)
+
+	page := data.Repositories
+	assert.Equal(t, 3, page.TotalCount)
+	assert.True(t, page.PageInfo.HasNextPage, "a third row was found past the page")
+	require.Len(t, page.Edges, 2)
+	assert.Equal(t, "octo-org/repo-1", page.Edges[0].Node.FullName)
+	assert.Equal(t, "octo-org/repo-2", page.Edges[1].Node.FullName)
+	assert.Equal(t, page.Edges[1].Cursor, page.PageInfo.EndCursor)
+
+	statements := selecting(database, "repositories")
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[1].SQL, "ORDER BY id LIMIT")
+	assert.Equal(t, 3, statements[1].Args[len(statements[1].Args)-1], "one row more than the page is loaded")
+}
+
+func TestRepositoriesAfterCursor(t *testing.T) {
+	first, errs, _ := run(t, `{ repositories(first: 2) { pageInfo { endCursor } } }`, func(database *dbtest.Database) {
+		database.Stub(`SELECT * FROM "repositories"`, repositoryColumns, repositoryRows(2)...)
+	})
+	require.Empty(t, errs)
+	cursor := first.Repositories.PageInfo.EndCursor
+	require.NotEmpty(t, cursor)
+
+	data, errs, database := run(t, `{ repositories(first: 2, after: "`+cursor+`") { pageInfo { hasNextPage } } }`, nil)
+	require.Empty(t, errs)
+	assert.False(t, data.Repositories.PageInfo.HasNextPage)
+
+	statements := selecting(database, "repositories")
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[1].SQL, `WHERE id > $1`)
+	assert.EqualValues(t, 2, statements[1].Args[0], "the page starts after the cursor")
+}
+
+func TestRepositoriesPageSizeIsCapped(t *testing.T) {
+	_, errs, database := run(t, `{ repositories(first: 1000) { totalCount } }`, nil)
+	require.Empty(t, errs)
+
+	statements := selecting(database, "repositories")
+	require.Len(t, statements, 2)
+	assert.Equal(t, 101, statements[1].Args[len(statements[1].Args)-1])
+}
+
+func TestRepositoriesRejectsInvalidArguments(t *testing.T) {
+	_, errs, _ := run(t, `{ repositories(first: -1) { totalCount } }`, nil)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "first must not be negative")
+
+	_, errs, _ = run(t, `{ repositories(after: "not-a-cursor") { totalCount } }`, nil)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "invalid cursor")
+}
+
+func TestDeepQueriesAreRejected(t *testing.T) {
+	schema, err := graphql.NewSchema(nil)
+	require.NoError(t, err)
+
+	errs := schema.Validate(`{
+		workflowRun(id: 1) { workflow { repository { workflows { edges { node {
+			runs { edges { node { workflow { repository { workflows { edges { node { runs { totalCount } } } } } } } } }
+		} } } } } }
+	}`)
+	require.NotEmpty(t, errs)
+	assert.Contains(t, errs[0].Message, "exceeds max depth 15")
+}
+
+func TestComplexQueriesAreRejected(t *testing.T) {
+	_, errs, _ := run(t, `{
+		repositories(first: 100) { edges { node {
+			workflows(first: 100) { edges { node { name } } }
+		} } }
+	}`, func(database *dbtest.Database) {
+		database.Stub(`SELECT * FROM "repositories"`, repositoryColumns, repositoryRows(100)...)
+		database.Stub(`SELECT * FROM "workflows"`, repositoryColumns, repositoryRows(100)...)
+	})
+	require.NotEmpty(t, errs)
+	assert.Contains(t, errs[0], "query is too complex")
+}
+
+// matching returns the queries against table whose arguments start with
+// the connection and GitHub ID.
+func matching(database *dbtest.Database, table, connection string, id int64) []dbtest.Statement {
+	var statements []dbtest.Statement
+	for _, statement := range selecting(database, table) {
+		if strings.Contains(statement.SQL, "connection = $1") && len(statement.Args) >= 2 &&
+			statement.Args[0] == connection && statement.Args[1] == id {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func TestWorkflowsAndRunsAreMatchedOnTheirConnection(t *testing.T) {
+	// GitHub IDs are only unique per connection, so a github.com workflow may
+	// share this GHES workflow's ID
+	_, errs, database := run(t, `{ workflow(id: 1) { runs { totalCount } stats { totalRuns } } }`, func(database *dbtest.Database) {
+		database.Stub(`SELECT * FROM "workflows"`, []string{"id", "connection", "workflow_id"},
+			[]driver.Value{int64(1), "ghes", int64(20)})
+	})
+	require.Empty(t, errs)
+	assert.Len(t, matching(database, "workflow_runs", "ghes", 20), 3, "the count, the page and the stats")
+
+	_, errs, database = run(t, `{ workflowRun(id: 2) { workflow { name } jobs { totalCount } } }`, func(database *dbtest.Database) {
+		database.Stub(`SELECT * FROM "workflow_runs"`, []string{"id", "connection", "run_id", "workflow_id", "run_attempt"},
+			[]driver.Value{int64(2), "ghes", int64(10), int64(20), int64(1)})
+	})
+	require.Empty(t, errs)
+	assert.Len(t, matching(database, "workflows", "ghes", 20), 1)
+	assert.Len(t, matching(database, "jobs", "ghes", 10), 2, "the count and the page")
+}
+
+func TestRepositoryByFullNameIsLookedUpOnConnection(t *testing.T) {
+	_, errs, database := run(t, `{ repository(fullName: "octo-org/api") { name } }`, nil)
+	require.Empty(t, errs)
+	statements := selecting(database, "repositories")
+	require.Len(t, statements, 1)
+	assert.Contains(t, statements[0].SQL, "connection = $1 AND full_name = $2")
+	assert.Equal(t, []driver.Value{"github.com", "octo-org/api"}, statements[0].Args[:2])
+
+	_, errs, database = run(t, `{ repository(fullName: "octo-org/api", connection: "ghes") { name } }`, nil)
+	require.Empty(t, errs)
+	statements = selecting(database, "repositories")
+	require.Len(t, statements, 1)
+	assert.Equal(t, "ghes", statements[0].Args[0])
+}
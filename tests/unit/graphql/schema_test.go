@@ -0,0 +1,41 @@
+package graphql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaBindsToResolvers(t *testing.T) {
+	schema, err := graphql.NewSchema(nil)
+	require.NoError(t, err)
+
+	errs := schema.Validate(`{
+		repositories(first: 5, language: "Go") {
+			totalCount
+			pageInfo { hasNextPage endCursor }
+			edges {
+				cursor
+				node {
+					fullName
+					workflows(state: "active") {
+						edges { node { name stats { successRate totalRuns } runs(branch: "main") { edges { node { headSha durationSeconds jobs { edges { node { name steps { name } } } } } } } } }
+					}
+				}
+			}
+		}
+	}`)
+	assert.Empty(t, errs)
+}
+
+func TestRepositoryRequiresArgument(t *testing.T) {
+	schema, err := graphql.NewSchema(nil)
+	require.NoError(t, err)
+
+	resp := schema.Exec(context.Background(), `{ repository { name } }`, "", nil)
+	require.Len(t, resp.Errors, 1)
+	assert.Contains(t, resp.Errors[0].Message, "either id or fullName is required")
+}
@@ -0,0 +1,87 @@
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/inventory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		uses string
+		want inventory.Reference
+	}{
+		{"actions/checkout@v4", inventory.Reference{Action: "actions/checkout", Ref: "v4", Kind: inventory.KindAction}},
+		{
+			"actions/checkout@b4ffde65f46336ab88eb53be808477a3936bae11",
+			inventory.Reference{Action: "actions/checkout", Ref: "b4ffde65f46336ab88eb53be808477a3936bae11", Kind: inventory.KindAction, Pinned: true},
+		},
+		{"github/codeql-action/init@v3", inventory.Reference{Action: "github/codeql-action/init", Ref: "v3", Kind: inventory.KindAction}},
+		{
+			"octo-org/workflows/.github/workflows/deploy.yml@main",
+			inventory.Reference{Action: "octo-org/workflows/.github/workflows/deploy.yml", Ref: "main", Kind: inventory.KindWorkflow},
+		},
+		{"./.github/actions/setup", inventory.Reference{Action: "./.github/actions/setup", Kind: inventory.KindLocal, Pinned: true}},
+		{"docker://alpine:3.20", inventory.Reference{Action: "alpine", Ref: "3.20", Kind: inventory.KindDocker}},
+		{"docker://ghcr.io/octo/tool", inventory.Reference{Action: "ghcr.io/octo/tool", Kind: inventory.KindDocker}},
+		{
+			"docker://alpine@sha256:beefdbd8a1da6d2915566fde36db9db0b524eb737fc57cd1367effd16dc0d06d",
+			inventory.Reference{Action: "alpine", Ref: "sha256:beefdbd8a1da6d2915566fde36db9db0b524eb737fc57cd1367effd16dc0d06d", Kind: inventory.KindDocker, Pinned: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uses, func(t *testing.T) {
+			assert.Equal(t, tt.want, inventory.ParseReference(tt.uses))
+		})
+	}
+}
+
+func TestCheckerDeprecated(t *testing.T) {
+	checker := inventory.NewChecker([]string{"octo-org/legacy-action", "octo-org/tool@v1"})
+
+	deprecated := func(uses string) bool {
+		return checker.Deprecated(inventory.ParseReference(uses))
+	}
+	assert.True(t, deprecated("actions/checkout@v2"))
+	assert.True(t, deprecated("actions/checkout@v3.6.0"))
+	assert.True(t, deprecated("Actions/Upload-Artifact@v3"))
+	assert.False(t, deprecated("actions/checkout@v4"))
+	assert.False(t, deprecated("actions/checkout@v30"))
+	assert.False(t, deprecated("actions/checkout@b4ffde65f46336ab88eb53be808477a3936bae11"), "SHAs are not resolved")
+	assert.True(t, deprecated("actions/create-release@v1"))
+	assert.True(t, deprecated("octo-org/legacy-action@main"))
+	assert.True(t, deprecated("octo-org/tool/sub@v1"))
+	assert.False(t, deprecated("octo-org/tool@v2"))
+	assert.False(t, deprecated("./actions/checkout"))
+}
+
+func TestBuilder(t *testing.T) {
+	builder := inventory.NewBuilder(inventory.NewChecker(nil))
+	builder.Add("github.com", "octo-org/web", ".github/workflows/ci.yml", "test", "actions/checkout@v4")
+	builder.Add("github.com", "octo-org/web", ".github/workflows/ci.yml", "lint", "actions/checkout@v4")
+	builder.Add("github.com", "octo-org/api", ".github/workflows/ci.yml", "test", "actions/checkout@v4")
+	builder.Add("github.com", "octo-org/api", ".github/workflows/ci.yml", "test", "actions/checkout@v2")
+	builder.Add("github.com", "octo-org/api", ".github/workflows/ci.yml", "test", "./.github/actions/setup")
+
+	entries := builder.Entries()
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "./.github/actions/setup", entries[0].Action)
+	assert.False(t, entries[0].Flagged())
+
+	assert.Equal(t, "v2", entries[1].Ref)
+	assert.True(t, entries[1].Deprecated)
+	assert.True(t, entries[1].Flagged())
+
+	checkout := entries[2]
+	assert.Equal(t, "v4", checkout.Ref)
+	assert.False(t, checkout.Deprecated)
+	assert.True(t, checkout.Flagged(), "tags are not pinned")
+	require.Len(t, checkout.Usages, 2)
+	assert.Equal(t, "octo-org/api", checkout.Usages[0].Repository)
+	assert.Equal(t, "octo-org/web", checkout.Usages[1].Repository)
+	assert.Equal(t, []string{"test", "lint"}, checkout.Usages[1].Jobs)
+}
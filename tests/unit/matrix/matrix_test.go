@@ -0,0 +1,89 @@
+package matrix_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/definitions"
+	"github.com/moosh3/github-actions-aggregator/pkg/matrix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJobName(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		values []string
+		ok     bool
+	}{
+		{"test (ubuntu-latest, 1.21)", "test", []string{"ubuntu-latest", "1.21"}, true},
+		{"Build and test (windows-latest)", "Build and test", []string{"windows-latest"}, true},
+		{"test (ubuntu-latest, {name: a, b: 1})", "test", []string{"ubuntu-latest", "{name: a, b: 1}"}, true},
+		{"test (linux (arm64), 1.21)", "test", []string{"linux (arm64)", "1.21"}, true},
+		{"lint", "lint", nil, false},
+		{"(ubuntu-latest)", "(ubuntu-latest)", nil, false},
+		{"deploy(prod)", "deploy(prod)", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, values, ok := matrix.ParseJobName(tt.name)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.base, base)
+			assert.Equal(t, tt.values, values)
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	job := func(name, conclusion string, minutes int) models.Job {
+		return models.Job{Name: name, Status: "completed", Conclusion: conclusion, StartedAt: start, CompletedAt: start.Add(time.Duration(minutes) * time.Minute)}
+	}
+	jobs := []models.Job{
+		job("test (ubuntu-latest, 1.22)", "success", 4),
+		job("test (ubuntu-latest, 1.23)", "success", 6),
+		job("test (windows-latest, 1.22)", "failure", 10),
+		job("test (windows-latest, 1.23)", "failure", 14),
+		job("test (windows-latest, 1.23)", "success", 12),
+		job("lint", "success", 1),
+	}
+	definition := &definitions.Definition{Jobs: []definitions.Job{{ID: "test", Dimensions: []string{"os", "go"}}}}
+
+	result := matrix.Aggregate(jobs, definition)
+	require.Len(t, result, 1)
+	test := result[0]
+	assert.Equal(t, "test", test.Name)
+	assert.Equal(t, []string{"os", "go"}, test.Dimensions)
+	assert.Equal(t, 5, test.TotalJobs)
+	assert.InDelta(t, 60, test.SuccessRate, 0.001)
+
+	require.Len(t, test.Legs, 4)
+	windows := test.Legs[3]
+	assert.Equal(t, "test (windows-latest, 1.23)", windows.Name)
+	assert.Equal(t, map[string]string{"os": "windows-latest", "go": "1.23"}, windows.Values)
+	assert.Equal(t, 2, windows.TotalJobs)
+	assert.InDelta(t, 13*60, windows.AverageDurationSeconds, 0.001)
+	assert.InDelta(t, 14*60, windows.MaxDurationSeconds, 0.001)
+
+	byOS := test.ByDimension["os"]
+	assert.InDelta(t, 0, byOS["ubuntu-latest"].FailureRate, 0.001)
+	assert.InDelta(t, 200.0/3, byOS["windows-latest"].FailureRate, 0.001)
+	assert.InDelta(t, 12*60, byOS["windows-latest"].AverageDurationSeconds, 0.001)
+	assert.Equal(t, 3, test.ByDimension["go"]["1.23"].TotalJobs)
+}
+
+func TestAggregateWithoutDefinition(t *testing.T) {
+	jobs := []models.Job{
+		{Name: "build (arm64)", Conclusion: "success"},
+		{Name: "build (amd64, race)", Conclusion: "failure"},
+	}
+
+	result := matrix.Aggregate(jobs, nil)
+	require.Len(t, result, 1)
+	assert.Equal(t, []string{"dimension_1", "dimension_2"}, result[0].Dimensions)
+	assert.Equal(t, 1, result[0].ByDimension["dimension_2"]["race"].FailureCount)
+	assert.Zero(t, result[0].AverageDurationSeconds, "jobs without times are not timed")
+}
@@ -0,0 +1,290 @@
+package memberships_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/api"
+	"github.com/moosh3/github-actions-aggregator/pkg/auth"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const accessToken = "gho_secret"
+
+// redirect sends every request to server, whatever host it was for.
+type redirect struct {
+	server *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.server.Scheme
+	req.URL.Host = r.server.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newGitHub serves the OAuth token exchange and the API calls made at login
+// for hubot, an admin of octo-org and member of its platform team. The
+// returned context sends GitHub requests to it.
+func newGitHub(t *testing.T) context.Context {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/login/oauth/access_token" {
+			w.Write([]byte(`{"access_token": "` + accessToken + `", "token_type": "bearer"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+accessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Bad credentials"}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"id": 42, "login": "hubot", "type": "User"}`))
+		case "/user/memberships/orgs":
+			w.Write([]byte(`[{"state": "active", "role": "admin", "organization": {"id": 7, "login": "octo-org"}}]`))
+		case "/user/teams":
+			w.Write([]byte(`[{"id": 9, "slug": "platform", "name": "Platform", "organization": {"id": 7, "login": "octo-org"}}]`))
+		case "/user/repos":
+			w.Write([]byte(`[]`))
+		case "/repos/octo-org/api":
+			w.Write([]byte(`{"id": 100, "full_name": "octo-org/api"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: redirect{server: u}})
+}
+
+// write returns the first insert into table.
+func write(t *testing.T, database *dbtest.Database, table string) dbtest.Statement {
+	t.Helper()
+	for _, statement := range database.Writes() {
+		if strings.HasPrefix(statement.SQL, `INSERT INTO "`+table+`"`) {
+			return statement
+		}
+	}
+	t.Fatalf("nothing was inserted into %s", table)
+	return dbtest.Statement{}
+}
+
+// login logs hubot in and returns the encrypted OAuth token that was stored.
+func login(t *testing.T, ctx context.Context) ([]byte, *dbtest.Database) {
+	t.Helper()
+	db, database := dbtest.Open(t)
+	user, err := auth.GitHubProvider.Authenticate(ctx, db, "code", "", "verifier", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hubot", user.Login)
+
+	stored := write(t, database, "user_tokens")
+	var encrypted []byte
+	for _, arg := range stored.Args {
+		if b, ok := arg.([]byte); ok {
+			encrypted = b
+		}
+	}
+	require.NotEmpty(t, encrypted)
+	return encrypted, database
+}
+
+// fetch fetches a repository with hubot's token stored as encrypted.
+func fetch(t *testing.T, ctx context.Context, encrypted []byte) error {
+	t.Helper()
+	db, database := dbtest.Open(t)
+	database.Stub(`FROM "user_tokens"`, []string{"id", "user_id", "encrypted_token"},
+		[]driver.Value{int64(1), int64(42), encrypted})
+	_, err := auth.FetchRepository(ctx, db, 42, "octo-org", "api")
+	return err
+}
+
+func TestLoginFailsWithoutGitHubUser(t *testing.T) {
+	auth.ConfigureTokenEncryption("test-key")
+	for name, respond := range map[string]func(w http.ResponseWriter){
+		"unauthorized": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Bad credentials"}`))
+		},
+		"server error": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{}`))
+		},
+		"no ID": func(w http.ResponseWriter) {
+			w.Write([]byte(`{"login": "hubot"}`))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/login/oauth/access_token" {
+					w.Write([]byte(`{"access_token": "` + accessToken + `", "token_type": "bearer"}`))
+					return
+				}
+				respond(w)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			require.NoError(t, err)
+			ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: redirect{server: u}})
+
+			db, database := dbtest.Open(t)
+			_, err = auth.GitHubProvider.Authenticate(ctx, db, "code", "", "verifier", nil)
+			assert.Error(t, err)
+			assert.Empty(t, database.Writes(), "no user is stored")
+		})
+	}
+}
+
+func TestStoredTokenRoundTrip(t *testing.T) {
+	auth.ConfigureTokenEncryption("test-key")
+	ctx := newGitHub(t)
+
+	encrypted, _ := login(t, ctx)
+	assert.False(t, bytes.Contains(encrypted, []byte(accessToken)), "the token is encrypted at rest")
+
+	// The stored token authenticates later GitHub requests for the user
+	assert.NoError(t, fetch(t, ctx, encrypted))
+
+	// Each save uses a new nonce
+	again, _ := login(t, ctx)
+	assert.NotEqual(t, encrypted, again)
+}
+
+func TestStoredTokenTamperDetection(t *testing.T) {
+	auth.ConfigureTokenEncryption("test-key")
+	ctx := newGitHub(t)
+	encrypted, _ := login(t, ctx)
+
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)-1] ^= 0x01
+	assert.ErrorIs(t, fetch(t, ctx, tampered), auth.ErrNoStoredToken)
+
+	assert.ErrorIs(t, fetch(t, ctx, encrypted[:4]), auth.ErrNoStoredToken, "truncated tokens are rejected")
+
+	auth.ConfigureTokenEncryption("other-key")
+	defer auth.ConfigureTokenEncryption("test-key")
+	assert.ErrorIs(t, fetch(t, ctx, encrypted), auth.ErrNoStoredToken, "tokens can't be read with another key")
+}
+
+func TestSyncMemberships(t *testing.T) {
+	auth.ConfigureTokenEncryption("test-key")
+	ctx := newGitHub(t)
+
+	db, database := dbtest.Open(t)
+	require.NoError(t, auth.SyncMemberships(ctx, db, 42, &oauth2.Token{AccessToken: accessToken}))
+
+	org := write(t, database, "git_hub_users")
+	assert.Contains(t, org.Args, int64(7))
+	assert.Contains(t, org.Args, "Organization", "organizations are stored so service tokens can act as them")
+
+	membership := write(t, database, "organization_memberships")
+	assert.Contains(t, membership.Args, int64(42))
+	assert.Contains(t, membership.Args, "octo-org")
+	assert.Contains(t, membership.Args, "admin")
+
+	team := write(t, database, "team_memberships")
+	assert.Contains(t, team.Args, int64(9))
+	assert.Contains(t, team.Args, "platform")
+
+	// Memberships are replaced, not added to
+	var deleted []dbtest.Statement
+	for _, statement := range database.Writes() {
+		if strings.HasPrefix(statement.SQL, "DELETE") {
+			deleted = append(deleted, statement)
+		}
+	}
+	require.Len(t, deleted, 2)
+	assert.Contains(t, deleted[0].SQL, `"organization_memberships"`)
+	assert.Equal(t, []driver.Value{int64(42), config.DefaultConnection}, deleted[0].Args,
+		"memberships synced from other connections are kept")
+	assert.Contains(t, deleted[1].SQL, `"team_memberships"`)
+	assert.Equal(t, []driver.Value{int64(42)}, deleted[1].Args)
+}
+
+func TestSyncMembershipsKeepsMembershipsOnError(t *testing.T) {
+	ctx := newGitHub(t)
+
+	db, database := dbtest.Open(t)
+	err := auth.SyncMemberships(ctx, db, 42, &oauth2.Token{AccessToken: "revoked"})
+	assert.Error(t, err)
+	assert.Empty(t, database.Writes(), "a failed sync leaves the stored memberships alone")
+}
+
+func TestGetMe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Name: "Hubot", Type: "User"}
+
+	db, database := dbtest.Open(t)
+	database.Stub(`FROM "organization_memberships"`, []string{"user_id", "organization_id", "organization", "role"},
+		[]driver.Value{int64(42), int64(7), "octo-org", "admin"})
+	database.Stub(`FROM "team_memberships"`, []string{"user_id", "team_id", "organization", "slug", "name"},
+		[]driver.Value{int64(42), int64(9), "octo-org", "platform", "Platform"})
+	database.Stub(`SELECT * FROM "repositories"`, []string{"id", "full_name", "private"},
+		[]driver.Value{int64(5), "octo-org/api", true},
+		[]driver.Value{int64(6), "octocat/hello-world", false})
+	database.Stub(`FROM "repository_accesses"`, []string{"user_id", "repository_id", "permission"},
+		[]driver.Value{int64(42), int64(5), "admin"})
+
+	router := gin.New()
+	router.GET("/me", func(c *gin.Context) {
+		c.Set("db", db)
+		c.Set("user", user)
+	}, api.GetMe)
+	req, _ := http.NewRequest("GET", "/me", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var me struct {
+		Login         string
+		Organizations []struct {
+			ID    int64
+			Login string
+			Role  string
+		}
+		Teams []struct {
+			Organization string
+			Slug         string
+		}
+		Repositories []struct {
+			FullName   string `json:"full_name"`
+			Private    bool
+			Permission string
+		}
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, "hubot", me.Login)
+	require.Len(t, me.Organizations, 1)
+	assert.Equal(t, "octo-org", me.Organizations[0].Login)
+	assert.Equal(t, "admin", me.Organizations[0].Role)
+	require.Len(t, me.Teams, 1)
+	assert.Equal(t, "platform", me.Teams[0].Slug)
+	require.Len(t, me.Repositories, 2)
+	assert.Equal(t, "admin", me.Repositories[0].Permission, "permissions come from the user's access")
+	assert.Equal(t, "pull", me.Repositories[1].Permission, "public repositories are readable")
+
+	// Only repositories visible to the user are listed
+	var scoped bool
+	for _, statement := range database.Statements() {
+		if strings.HasPrefix(statement.SQL, `SELECT * FROM "repositories"`) {
+			scoped = strings.Contains(statement.SQL, `"repository_accesses"`)
+		}
+	}
+	assert.True(t, scoped)
+}
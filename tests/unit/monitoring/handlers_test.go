@@ -0,0 +1,151 @@
+package monitoring_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moosh3/github-actions-aggregator/pkg/api"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/moosh3/github-actions-aggregator/tests/unit/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// newGitHub serves octo-org/api, a private repository, and 404 for
+// everything else, on both github.com and a GHES connection.
+func newGitHub(t *testing.T) *github.Connections {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/octo-org/api" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 7, "name": "api", "full_name": "octo-org/api", "private": true}`))
+	}))
+	t.Cleanup(server.Close)
+
+	connections, err := github.NewConnections([]config.ConnectionConfig{
+		{Name: config.DefaultConnection, BaseURL: server.URL + "/api/v3/", AccessToken: "token"},
+		{Name: "ghes", BaseURL: server.URL + "/api/v3/", AccessToken: "ghes-token"},
+	}, github.NewGovernor(0))
+	require.NoError(t, err)
+	return connections
+}
+
+func monitor(t *testing.T, conn *gorm.DB, connections *github.Connections, user *models.GitHubUser, connection, fullName string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	database := &db.Database{Conn: conn}
+	poller := github.NewPoller(database, connections, time.Hour, time.Hour)
+
+	router := gin.New()
+	router.POST("/monitored-repositories", func(c *gin.Context) {
+		c.Set("db", conn)
+		c.Set("user", user)
+	}, api.MonitorRepository(database, connections, poller))
+
+	body := `{"full_name": "` + fullName + `", "connection": "` + connection + `"}`
+	req, _ := http.NewRequest("POST", "/monitored-repositories", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMonitorRepositoryHidesRepositoriesWithoutRole(t *testing.T) {
+	connections := newGitHub(t)
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"}
+
+	conn, database := dbtest.Open(t)
+	forbidden := monitor(t, conn, connections, user, "ghes", "octo-org/api")
+	assert.Empty(t, database.Writes(), "nothing is stored before the role check")
+
+	conn, _ = dbtest.Open(t)
+	missing := monitor(t, conn, connections, user, "ghes", "octo-org/missing")
+
+	assert.Equal(t, http.StatusNotFound, forbidden.Code)
+	assert.Equal(t, missing.Code, forbidden.Code)
+	assert.JSONEq(t, missing.Body.String(), forbidden.Body.String(),
+		"a repository the user may not monitor looks missing")
+}
+
+func TestMonitorRepositoryAsOrganizationAdmin(t *testing.T) {
+	connections := newGitHub(t)
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"}
+
+	conn, database := dbtest.Open(t)
+	database.Stub(`FROM "organization_memberships"`, []string{"user_id", "organization", "role"},
+		[]driver.Value{int64(42), "octo-org", "admin"})
+	database.Stub(`FROM "repositories" WHERE (connection = $1 AND full_name = $2)`,
+		[]string{"id", "connection", "full_name", "name", "private", "monitor"},
+		[]driver.Value{int64(5), config.DefaultConnection, "octo-org/api", "api", true, false})
+
+	w := monitor(t, conn, connections, user, config.DefaultConnection, "octo-org/api")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"monitor":true`)
+
+	var saved, audited bool
+	for _, write := range database.Writes() {
+		saved = saved || strings.HasPrefix(write.SQL, `INSERT INTO "repositories"`)
+		audited = audited || strings.HasPrefix(write.SQL, `INSERT INTO "audit_events"`)
+	}
+	assert.True(t, saved, "the repository is saved from GitHub")
+	assert.True(t, audited, "the change is audited")
+}
+
+func TestMonitorRepositoryIgnoresOrganizationRolesOfOtherConnections(t *testing.T) {
+	connections := newGitHub(t)
+	user := &models.GitHubUser{ID: 42, Login: "hubot", Type: "User"}
+
+	// The user owns octo-org on github.com, which says nothing about the
+	// organization of the same name on the GHES connection
+	conn, database := dbtest.Open(t)
+
+	w := monitor(t, conn, connections, user, "ghes", "octo-org/api")
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	assert.Empty(t, database.Writes())
+	var queried bool
+	for _, statement := range database.Statements() {
+		if strings.Contains(statement.SQL, `FROM "organization_memberships"`) {
+			queried = true
+			assert.Contains(t, statement.Args, "ghes", "only memberships of the connection are consulted")
+			assert.NotContains(t, statement.Args, config.DefaultConnection)
+		}
+	}
+	assert.True(t, queried)
+}
+
+func TestListWorkflowsFollowsPages(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`{"total_count": 2, "workflows": [{"id": 2, "path": ".github/workflows/release.yml"}]}`))
+			return
+		}
+		w.Header().Set("Link", `<`+server.URL+r.URL.Path+`?page=2>; rel="next"`)
+		w.Write([]byte(`{"total_count": 2, "workflows": [{"id": 1, "path": ".github/workflows/ci.yml"}]}`))
+	}))
+	defer server.Close()
+
+	connections, err := github.NewConnections([]config.ConnectionConfig{
+		{Name: "ghes", BaseURL: server.URL + "/api/v3/", AccessToken: "ghes-token"},
+	}, github.NewGovernor(0))
+	require.NoError(t, err)
+	connection, err := connections.Get("ghes")
+	require.NoError(t, err)
+
+	workflows, err := connection.Client.ListWorkflows("octo-org", "api")
+	require.NoError(t, err)
+	require.Len(t, workflows, 2)
+	assert.Equal(t, int64(2), workflows[1].GetID())
+}
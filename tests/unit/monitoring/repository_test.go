@@ -0,0 +1,33 @@
+package monitoring_test
+
+import (
+	"testing"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryOwnerLogin(t *testing.T) {
+	repo := models.Repository{Name: "hello-world", FullName: "octo-org/hello-world"}
+	assert.Equal(t, "octo-org", repo.OwnerLogin())
+}
+
+func TestRepositoryTracksWorkflow(t *testing.T) {
+	all := models.Repository{}
+	assert.True(t, all.TracksWorkflow(".github/workflows/ci.yml", "CI"), "no tracked workflows tracks everything")
+
+	repo := models.Repository{TrackedWorkflows: []string{"ci.yml", "Release"}}
+	assert.True(t, repo.TracksWorkflow(".github/workflows/ci.yml", "CI"), "file name matches the path")
+	assert.True(t, repo.TracksWorkflow(".github/workflows/release.yml", "Release"), "name matches")
+	assert.False(t, repo.TracksWorkflow(".github/workflows/lint.yml", "Lint"))
+
+	repo = models.Repository{TrackedWorkflows: []string{".github/workflows/lint.yml"}}
+	assert.True(t, repo.TracksWorkflow(".github/workflows/lint.yml", "Lint"), "full path matches")
+}
+
+func TestRepositoryIgnoresBranch(t *testing.T) {
+	repo := models.Repository{IgnoredBranches: []string{"dependabot/npm", "gh-pages"}}
+	assert.True(t, repo.IgnoresBranch("gh-pages"))
+	assert.False(t, repo.IgnoresBranch("main"))
+	assert.False(t, models.Repository{}.IgnoresBranch("main"))
+}
@@ -0,0 +1,37 @@
+package monitoring_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleInterval(t *testing.T) {
+	now := time.Now()
+	schedule := github.Schedule{Active: 5 * time.Minute, Dormant: time.Hour}
+
+	assert.Equal(t, 5*time.Minute, schedule.Interval(now.Add(-48*time.Hour), true, now), "unfinished runs keep a repository active")
+	assert.Equal(t, 5*time.Minute, schedule.Interval(now.Add(-10*time.Minute), false, now))
+	assert.Equal(t, 30*time.Minute, schedule.Interval(now.Add(-6*time.Hour), false, now), "interval grows with idle time")
+	assert.Equal(t, time.Hour, schedule.Interval(now.Add(-48*time.Hour), false, now))
+	assert.Equal(t, time.Hour, schedule.Interval(time.Time{}, false, now), "never active is dormant")
+}
+
+func TestScheduleIntervalOverrideAboveDormant(t *testing.T) {
+	now := time.Now()
+	schedule := github.Schedule{Active: 2 * time.Hour, Dormant: time.Hour}
+
+	assert.Equal(t, 2*time.Hour, schedule.Interval(time.Time{}, false, now))
+}
+
+func TestScheduleNextJitter(t *testing.T) {
+	now := time.Now()
+	schedule := github.Schedule{Active: 10 * time.Minute, Dormant: time.Hour}
+
+	for i := 0; i < 100; i++ {
+		next := schedule.Next(now, false, now)
+		assert.WithinRange(t, next, now.Add(9*time.Minute), now.Add(11*time.Minute))
+	}
+}
@@ -0,0 +1,94 @@
+package pullrequests_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/pullrequests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var opened = time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+func attempt(runID int64, number int, sha, conclusion string, start time.Time, minutes int) models.WorkflowRun {
+	return models.WorkflowRun{
+		RunID:        runID,
+		RunAttempt:   number,
+		HeadSHA:      sha,
+		Status:       "completed",
+		Conclusion:   conclusion,
+		CreatedAt:    start,
+		RunStartedAt: &start,
+		UpdatedAt:    start.Add(time.Duration(minutes) * time.Minute),
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	merged := opened.Add(5 * time.Hour)
+	pr := models.PullRequest{Number: 7, OpenedAt: &opened, MergedAt: &merged}
+	attempts := []models.WorkflowRun{
+		// First push: tests fail, then pass on a re-run
+		attempt(1, 1, "aaa", "success", opened, 5),
+		attempt(2, 1, "aaa", "failure", opened, 10),
+		attempt(2, 2, "aaa", "success", opened.Add(time.Hour), 20),
+		// Second push: green straight away, but after the re-run
+		attempt(3, 1, "bbb", "success", opened.Add(2*time.Hour), 5),
+		attempt(4, 1, "bbb", "success", opened.Add(2*time.Hour), 15),
+		// After the merge
+		attempt(5, 1, "ccc", "failure", merged.Add(time.Hour), 5),
+	}
+
+	summary := pullrequests.Summarize(pr, attempts)
+	assert.Equal(t, 3, summary.Pushes)
+	assert.Equal(t, 5, summary.Runs)
+	assert.Equal(t, 6, summary.Attempts)
+	assert.Equal(t, 1, summary.Reruns)
+	assert.Equal(t, 1, summary.FailedRuns, "failures after the merge are not counted")
+	assert.InDelta(t, 60*60, summary.CIWallTimeSeconds, 0.001)
+
+	require.NotNil(t, summary.FirstGreenAt)
+	assert.Equal(t, opened.Add(80*time.Minute), *summary.FirstGreenAt)
+	require.NotNil(t, summary.TimeToFirstGreenSeconds)
+	assert.InDelta(t, 80*60, *summary.TimeToFirstGreenSeconds, 0.001)
+	require.NotNil(t, summary.TimeToMergeSeconds)
+	assert.InDelta(t, 5*60*60, *summary.TimeToMergeSeconds, 0.001)
+}
+
+func TestSummarizeWithoutDetails(t *testing.T) {
+	inProgress := attempt(1, 1, "aaa", "", opened, 0)
+	inProgress.Status = "in_progress"
+
+	summary := pullrequests.Summarize(models.PullRequest{Number: 8}, []models.WorkflowRun{
+		inProgress,
+		attempt(2, 1, "aaa", "success", opened, 5),
+	})
+	assert.Equal(t, 1, summary.Pushes)
+	assert.Nil(t, summary.FirstGreenAt, "a commit with runs in progress is not green")
+	assert.Nil(t, summary.TimeToFirstGreenSeconds)
+	assert.Nil(t, summary.TimeToMergeSeconds)
+}
+
+func TestTotal(t *testing.T) {
+	seconds := func(s float64) *float64 { return &s }
+	totals := pullrequests.Total([]pullrequests.Summary{
+		{Pushes: 2, Attempts: 3, Reruns: 1, FailedRuns: 1, CIWallTimeSeconds: 600, TimeToFirstGreenSeconds: seconds(100), TimeToMergeSeconds: seconds(1000)},
+		{Pushes: 1, Attempts: 1, CIWallTimeSeconds: 300, TimeToFirstGreenSeconds: seconds(300)},
+		{Pushes: 3, Attempts: 4, Reruns: 2, FailedRuns: 2, CIWallTimeSeconds: 900},
+	})
+
+	assert.Equal(t, 3, totals.PullRequests)
+	assert.Equal(t, 1, totals.Merged)
+	require.NotNil(t, totals.MedianTimeToFirstGreenSeconds)
+	assert.InDelta(t, 200, *totals.MedianTimeToFirstGreenSeconds, 0.001)
+	require.NotNil(t, totals.MedianTimeToMergeSeconds)
+	assert.InDelta(t, 1000, *totals.MedianTimeToMergeSeconds, 0.001)
+	require.NotNil(t, totals.MedianCIWallTimeSeconds)
+	assert.InDelta(t, 600, *totals.MedianCIWallTimeSeconds, 0.001)
+	assert.InDelta(t, 2, totals.AveragePushes, 0.001)
+	assert.InDelta(t, 1, totals.AverageFailedRuns, 0.001)
+	assert.InDelta(t, 1, totals.AverageReruns, 0.001)
+
+	assert.Nil(t, pullrequests.Total(nil).MedianCIWallTimeSeconds)
+}
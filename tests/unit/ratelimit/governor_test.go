@@ -0,0 +1,101 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rateLimitServer answers every request with the given rate limit headers.
+func rateLimitServer(t *testing.T, limit, remaining int, reset time.Time) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.Header().Set("X-RateLimit-Resource", "core")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(client *http.Client, url string, priority github.Priority, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(github.WithPriority(context.Background(), priority), timeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestGovernorTracksRateLimitHeaders(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	server := rateLimitServer(t, 5000, 4999, reset)
+
+	governor := github.NewGovernor(0.5)
+	client := &http.Client{Transport: governor.Transport("github.com", nil)}
+	require.NoError(t, get(client, server.URL, github.PriorityBackground, time.Second))
+
+	budget := governor.Budget("github.com")
+	assert.Equal(t, 5000, budget.Limit)
+	assert.Equal(t, 4999, budget.Remaining)
+	assert.True(t, reset.Equal(budget.Reset))
+	assert.Len(t, governor.Budgets(), 1)
+}
+
+func TestGovernorKeepsReserveForHighPriority(t *testing.T) {
+	// 40 of 100 requests are left, below the half reserved for high priority
+	server := rateLimitServer(t, 100, 40, time.Now().Add(time.Hour))
+
+	governor := github.NewGovernor(0.5)
+	client := &http.Client{Transport: governor.Transport("github.com", nil)}
+	require.NoError(t, get(client, server.URL, github.PriorityHigh, time.Second))
+
+	err := get(client, server.URL, github.PriorityBackground, 100*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "background requests wait for the reset")
+
+	assert.NoError(t, get(client, server.URL, github.PriorityHigh, 100*time.Millisecond))
+}
+
+func TestGovernorSpreadsBackgroundRequests(t *testing.T) {
+	// 100 requests over the next 10 seconds: one every 100ms
+	server := rateLimitServer(t, 100, 100, time.Now().Add(10*time.Second))
+
+	governor := github.NewGovernor(1)
+	client := &http.Client{Transport: governor.Transport("github.com", nil)}
+	require.NoError(t, get(client, server.URL, github.PriorityBackground, time.Second))
+
+	start := time.Now()
+	require.NoError(t, get(client, server.URL, github.PriorityBackground, time.Second))
+	require.NoError(t, get(client, server.URL, github.PriorityBackground, time.Second))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestGovernorHonorsRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	governor := github.NewGovernor(0.5)
+	client := &http.Client{Transport: governor.Transport("ghes", nil)}
+	require.NoError(t, get(client, server.URL, github.PriorityHigh, time.Second))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), governor.Budget("ghes").BlockedUntil, 5*time.Second)
+
+	err := get(client, server.URL, github.PriorityHigh, 100*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "even high priority requests back off")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,133 @@
+package reconciliation_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRun(status, conclusion string, updatedAt time.Time) *gh.WorkflowRun {
+	return &gh.WorkflowRun{
+		ID:         gh.Int64(1),
+		Status:     gh.String(status),
+		Conclusion: gh.String(conclusion),
+		UpdatedAt:  &gh.Timestamp{Time: updatedAt},
+	}
+}
+
+// rerun returns a copy of run as the given attempt.
+func rerun(run *gh.WorkflowRun, attempt int) *gh.WorkflowRun {
+	copied := *run
+	copied.RunAttempt = gh.Int(attempt)
+	return &copied
+}
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	reconciler := github.NewReconciler(nil, nil, config.ReconciliationConfig{
+		StuckAfter: 2 * time.Hour,
+		Grace:      10 * time.Minute,
+	})
+
+	completed := newRun("completed", "success", now.Add(-time.Hour))
+
+	tests := []struct {
+		name   string
+		stored *models.WorkflowRun
+		run    *gh.WorkflowRun
+		want   github.Drift
+	}{
+		{
+			name: "missing",
+			run:  completed,
+			want: github.DriftMissing,
+		},
+		{
+			name:   "up to date",
+			stored: &models.WorkflowRun{Status: "completed", Conclusion: "success", UpdatedAt: now.Add(-time.Hour)},
+			run:    completed,
+			want:   github.DriftNone,
+		},
+		{
+			name:   "stale conclusion",
+			stored: &models.WorkflowRun{Status: "completed", Conclusion: "failure", UpdatedAt: now.Add(-time.Hour)},
+			run:    completed,
+			want:   github.DriftStale,
+		},
+		{
+			name:   "recently in progress",
+			stored: &models.WorkflowRun{Status: "in_progress", UpdatedAt: now.Add(-time.Hour)},
+			run:    completed,
+			want:   github.DriftStale,
+		},
+		{
+			name:   "stuck in progress",
+			stored: &models.WorkflowRun{Status: "in_progress", UpdatedAt: now.Add(-3 * time.Hour)},
+			run:    completed,
+			want:   github.DriftStuck,
+		},
+		{
+			name:   "still in progress on GitHub",
+			stored: &models.WorkflowRun{Status: "in_progress", UpdatedAt: now.Add(-3 * time.Hour)},
+			run:    newRun("in_progress", "", now.Add(-3*time.Hour)),
+			want:   github.DriftNone,
+		},
+		{
+			name:   "newer attempt on GitHub",
+			stored: &models.WorkflowRun{RunAttempt: 1, Status: "completed", Conclusion: "success", UpdatedAt: now.Add(-time.Hour)},
+			run:    rerun(completed, 2),
+			want:   github.DriftStale,
+		},
+		{
+			name:   "latest attempt stored",
+			stored: &models.WorkflowRun{RunAttempt: 2, Status: "completed", Conclusion: "success", UpdatedAt: now.Add(-time.Hour)},
+			run:    rerun(completed, 2),
+			want:   github.DriftNone,
+		},
+		{
+			name: "within grace period",
+			run:  newRun("completed", "success", now.Add(-time.Minute)),
+			want: github.DriftNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, reconciler.Compare(tt.stored, tt.run, now))
+		})
+	}
+}
+
+func TestRepairKeepsRunWhenJobsFail(t *testing.T) {
+	var jobRequests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/octo/app/actions/runs/1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		jobRequests++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := gh.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	// Without a database, saving the run would panic: the failed job fetch
+	// must stop the repair before it
+	reconciler := github.NewReconciler(nil, nil, config.ReconciliationConfig{})
+	repo := models.Repository{Name: "app", FullName: "octo/app"}
+	run := newRun("completed", "success", time.Now())
+	run.RunAttempt = gh.Int(1)
+
+	err := reconciler.Repair(context.Background(), client, repo, run)
+	assert.Error(t, err)
+	assert.Equal(t, 1, jobRequests)
+}
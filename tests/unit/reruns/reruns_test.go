@@ -0,0 +1,103 @@
+package reruns_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailedJobsOnly(t *testing.T) {
+	previous := []models.Job{
+		{Name: "build", Conclusion: "success"},
+		{Name: "test (windows-latest)", Conclusion: "failure"},
+		{Name: "test (ubuntu-latest)", Conclusion: "success"},
+	}
+
+	assert.True(t, models.FailedJobsOnly(previous, []models.Job{{Name: "test (windows-latest)"}}))
+	assert.False(t, models.FailedJobsOnly(previous, []models.Job{
+		{Name: "build"}, {Name: "test (windows-latest)"}, {Name: "test (ubuntu-latest)"},
+	}))
+	assert.False(t, models.FailedJobsOnly(previous, nil), "jobs of the attempt are not known yet")
+	assert.False(t, models.FailedJobsOnly(nil, []models.Job{{Name: "build"}}))
+}
+
+func TestFailedJobsOnlyAfterRepeatedFailure(t *testing.T) {
+	// Attempt 2 re-ran the failed job, which failed again; attempt 3 re-runs
+	// it once more, keeping the jobs that succeeded in attempt 1
+	earlier := []models.Job{
+		{Name: "build", Conclusion: "success", RunAttempt: 1},
+		{Name: "test", Conclusion: "failure", RunAttempt: 1},
+		{Name: "test", Conclusion: "failure", RunAttempt: 2},
+	}
+	assert.True(t, models.FailedJobsOnly(earlier, []models.Job{{Name: "test", RunAttempt: 3}}))
+	assert.False(t, models.FailedJobsOnly(earlier[2:], []models.Job{{Name: "test", RunAttempt: 3}}),
+		"attempt 2 alone has no successful jobs to keep")
+}
+
+func TestSummarizeReruns(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	attempt := func(runID int64, number int, conclusion, actor string, failedOnly bool) models.WorkflowRun {
+		startedAt := start.Add(time.Duration(number-1) * time.Hour)
+		return models.WorkflowRun{
+			RunID:           runID,
+			RunAttempt:      number,
+			Conclusion:      conclusion,
+			TriggeringActor: actor,
+			RerunFailedJobs: failedOnly,
+			CreatedAt:       start,
+			RunStartedAt:    &startedAt,
+			UpdatedAt:       startedAt.Add(10 * time.Minute),
+		}
+	}
+	runs := []models.WorkflowRun{
+		attempt(1, 1, "success", "octocat", false),
+		attempt(2, 2, "success", "hubot", true),
+		attempt(2, 1, "failure", "octocat", false),
+		attempt(3, 1, "failure", "octocat", false),
+		attempt(3, 2, "failure", "hubot", false),
+		attempt(3, 3, "failure", "octocat", true),
+		attempt(4, 1, "success", "octocat", false),
+	}
+
+	summary := models.SummarizeReruns(runs)
+	assert.Equal(t, 4, summary.TotalRuns)
+	assert.Equal(t, 2, summary.RerunRuns)
+	assert.InDelta(t, 50, summary.RerunRate, 0.001)
+	assert.Equal(t, 3, summary.Reruns)
+	assert.Equal(t, 2, summary.FailedJobsReruns)
+	assert.Equal(t, 1, summary.FullReruns)
+	assert.Zero(t, summary.UnrecordedReruns)
+	assert.Equal(t, 1, summary.PassedOnRerun)
+	assert.InDelta(t, 50, summary.PassedOnRerunRate, 0.001)
+	assert.InDelta(t, 30, summary.SupersededMinutes, 0.001)
+	assert.Equal(t, map[string]int{"hubot": 2, "octocat": 1}, summary.RerunsByActor)
+}
+
+func TestSummarizeRerunsWithMissingAttempts(t *testing.T) {
+	// Attempt 2 of run 1 was made before the run was first stored
+	runs := []models.WorkflowRun{
+		{RunID: 1, RunAttempt: 1, Conclusion: "failure"},
+		{RunID: 1, RunAttempt: 3, Conclusion: "success", RerunFailedJobs: true},
+		// The same run ID on another connection is another run
+		{Connection: "ghes", RunID: 1, RunAttempt: 1, Conclusion: "success"},
+	}
+
+	summary := models.SummarizeReruns(runs)
+	assert.Equal(t, 2, summary.TotalRuns)
+	assert.Equal(t, 1, summary.RerunRuns)
+	assert.Equal(t, 2, summary.Reruns)
+	assert.Equal(t, 1, summary.FailedJobsReruns)
+	assert.Zero(t, summary.FullReruns)
+	assert.Equal(t, 1, summary.UnrecordedReruns)
+	assert.Equal(t, summary.Reruns, summary.FailedJobsReruns+summary.FullReruns+summary.UnrecordedReruns)
+}
+
+func TestSummarizeRerunsWithoutReruns(t *testing.T) {
+	summary := models.SummarizeReruns([]models.WorkflowRun{{RunID: 1, RunAttempt: 1}})
+	assert.Equal(t, 1, summary.TotalRuns)
+	assert.Zero(t, summary.RerunRate)
+	assert.Zero(t, summary.PassedOnRerunRate)
+	assert.Empty(t, summary.RerunsByActor)
+}
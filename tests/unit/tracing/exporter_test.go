@@ -0,0 +1,98 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gh "github.com/google/go-github/v50/github"
+	"github.com/moosh3/github-actions-aggregator/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func timestamp(t time.Time) *gh.Timestamp {
+	return &gh.Timestamp{Time: t}
+}
+
+func TestExportRun(t *testing.T) {
+	start := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	run := &gh.WorkflowRun{
+		ID:           gh.Int64(42),
+		Name:         gh.String("CI"),
+		HeadBranch:   gh.String("main"),
+		HeadSHA:      gh.String("abc123"),
+		Status:       gh.String("completed"),
+		Conclusion:   gh.String("failure"),
+		RunStartedAt: timestamp(start),
+		UpdatedAt:    timestamp(start.Add(5 * time.Minute)),
+		Repository:   &gh.Repository{FullName: gh.String("octo/repo")},
+	}
+	jobs := []*gh.WorkflowJob{
+		{
+			ID:          gh.Int64(1),
+			Name:        gh.String("test"),
+			Conclusion:  gh.String("failure"),
+			RunnerName:  gh.String("runner-1"),
+			StartedAt:   timestamp(start.Add(time.Minute)),
+			CompletedAt: timestamp(start.Add(6 * time.Minute)),
+			Steps: []*gh.TaskStep{
+				{
+					Name:        gh.String("Run tests"),
+					Number:      gh.Int64(1),
+					Conclusion:  gh.String("failure"),
+					StartedAt:   timestamp(start.Add(2 * time.Minute)),
+					CompletedAt: timestamp(start.Add(6 * time.Minute)),
+				},
+			},
+		},
+		{
+			// Skipped jobs never start and are not exported.
+			ID:         gh.Int64(2),
+			Name:       gh.String("deploy"),
+			Conclusion: gh.String("skipped"),
+		},
+	}
+
+	spanExporter := tracetest.NewInMemoryExporter()
+	exporter := tracing.NewExporterWithSpanExporter(spanExporter, resource.Empty())
+
+	err := exporter.ExportRun(context.Background(), run, jobs)
+	require.NoError(t, err)
+	require.NoError(t, exporter.ForceFlush(context.Background()))
+
+	spans := spanExporter.GetSpans()
+	require.Len(t, spans, 3)
+
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	root := byName["CI"]
+	job := byName["test"]
+	step := byName["Run tests"]
+
+	assert.False(t, root.Parent.IsValid())
+	assert.Equal(t, root.SpanContext.SpanID(), job.Parent.SpanID())
+	assert.Equal(t, job.SpanContext.SpanID(), step.Parent.SpanID())
+	assert.Equal(t, root.SpanContext.TraceID(), step.SpanContext.TraceID())
+
+	assert.Equal(t, start, root.StartTime)
+	assert.Equal(t, start.Add(6*time.Minute), root.EndTime, "root span covers late jobs")
+	assert.Equal(t, codes.Error, root.Status.Code)
+	assert.Contains(t, job.Attributes, attribute.String("cicd.runner.name", "runner-1"))
+	assert.Contains(t, job.Attributes, attribute.String("vcs.repository.name", "octo/repo"))
+}
+
+func TestExportRunRequiresCompletedRun(t *testing.T) {
+	exporter := tracing.NewExporterWithSpanExporter(tracetest.NewInMemoryExporter(), resource.Empty())
+
+	err := exporter.ExportRun(context.Background(), &gh.WorkflowRun{Status: gh.String("in_progress")}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moosh3/github-actions-aggregator/pkg/config"
+	"github.com/moosh3/github-actions-aggregator/pkg/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer fails the first failures requests with a 502.
+func flakyServer(t *testing.T, failures int32) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestResilientTransportRetriesIdempotentRequests(t *testing.T) {
+	server, requests := flakyServer(t, 2)
+	client := &http.Client{Transport: github.NewResilientTransport(nil, config.GitHubHTTPConfig{MaxRetries: 3})}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestResilientTransportDoesNotRetryPost(t *testing.T) {
+	server, requests := flakyServer(t, 1)
+	client := &http.Client{Transport: github.NewResilientTransport(nil, config.GitHubHTTPConfig{MaxRetries: 3})}
+
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestResilientTransportTimesOutRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: github.NewResilientTransport(nil, config.GitHubHTTPConfig{
+		Timeout:    50 * time.Millisecond,
+		MaxRetries: 1,
+	})}
+	start := time.Now()
+	_, err := client.Get(server.URL)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestResilientTransportOpensCircuit(t *testing.T) {
+	github.ConfigureTransport(config.GitHubHTTPConfig{BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	defer github.ConfigureTransport(config.GitHubHTTPConfig{})
+
+	server, requests := flakyServer(t, 100)
+	client := &http.Client{Transport: github.NewResilientTransport(nil, config.GitHubHTTPConfig{MaxRetries: 1})}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, github.BreakerOpen, github.BreakerStates()[host])
+
+	_, err = client.Get(server.URL)
+	assert.True(t, errors.Is(err, github.ErrCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests), "no requests while the circuit is open")
+}
+
+func TestBreakerHalfOpensAfterCooldown(t *testing.T) {
+	breaker := github.NewBreaker("example.com", 2, 50*time.Millisecond)
+	assert.True(t, breaker.Allow())
+
+	breaker.Failure()
+	assert.Equal(t, github.BreakerClosed, breaker.State())
+	breaker.Failure()
+	assert.Equal(t, github.BreakerOpen, breaker.State())
+	assert.False(t, breaker.Allow())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, github.BreakerHalfOpen, breaker.State())
+	assert.True(t, breaker.Allow(), "one trial request")
+	assert.False(t, breaker.Allow(), "only one trial at a time")
+
+	breaker.Failure()
+	assert.Equal(t, github.BreakerOpen, breaker.State(), "a failed trial opens the circuit again")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	breaker.Success()
+	assert.Equal(t, github.BreakerClosed, breaker.State())
+}
+
+// pacedServer allows 10 requests over the next 2 to 3 seconds, so the
+// governor spaces background requests over 200ms apart.
+func pacedServer(t *testing.T) (*httptest.Server, *int32) {
+	reset := time.Now().Add(3 * time.Second)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("X-RateLimit-Limit", "10")
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.Header().Set("X-RateLimit-Resource", "core")
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestResilientTransportDoesNotTimeOutGovernorWaits(t *testing.T) {
+	github.ConfigureTransport(config.GitHubHTTPConfig{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+	defer github.ConfigureTransport(config.GitHubHTTPConfig{})
+
+	server, requests := pacedServer(t)
+	governor := github.NewGovernor(1)
+	client := &http.Client{Transport: github.NewResilientTransport(governor.Transport("ghes", nil), config.GitHubHTTPConfig{
+		Timeout:    100 * time.Millisecond,
+		MaxRetries: 3,
+	})}
+
+	// After the first response the governor holds each request back longer
+	// than the request timeout
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err, "request %d", i)
+		resp.Body.Close()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond, "requests were paced")
+	assert.Equal(t, int32(4), atomic.LoadInt32(requests), "no request was retried")
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, github.BreakerClosed, github.BreakerStates()[host])
+}
+
+func TestResilientTransportIgnoresCancelledWaits(t *testing.T) {
+	github.ConfigureTransport(config.GitHubHTTPConfig{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+	defer github.ConfigureTransport(config.GitHubHTTPConfig{})
+
+	server, requests := pacedServer(t)
+	governor := github.NewGovernor(1)
+	client := &http.Client{Transport: github.NewResilientTransport(governor.Transport("ghes", nil), config.GitHubHTTPConfig{
+		MaxRetries: 3,
+	})}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	client.Get(server.URL)
+
+	// The caller gives up while the governor holds the request back
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, github.BreakerClosed, github.BreakerStates()[host], "cancellations are not failures of the host")
+}